@@ -0,0 +1,65 @@
+package analysis
+
+import (
+	"sol_test/types"
+	"sort"
+)
+
+// IsNFTCandidate reports whether a token account holds what looks like an
+// NFT: a single unit of a token without decimals.
+func IsNFTCandidate(amount types.TokenAmount) bool {
+	return amount.Decimals == 0 && amount.Amount == "1"
+}
+
+// IsNFT reports whether an NFT candidate really is one. Fungible tokens
+// without decimals declare themselves through their token standard.
+func IsNFT(asset types.TokenMetaData) bool {
+	switch asset.Content.Metadata.TokenStandard {
+	case "Fungible", "FungibleAsset":
+		return false
+	}
+	return true
+}
+
+// NFTFromAsset builds the wallet view of an NFT from its DAS asset.
+func NFTFromAsset(mint string, asset types.TokenMetaData) types.MyNFT {
+	nft := types.MyNFT{
+		Address:       mint,
+		Name:          asset.Content.Metadata.Name,
+		Symbol:        asset.Content.Metadata.Symbol,
+		Image:         asset.Content.Links.Image,
+		TokenStandard: asset.Content.Metadata.TokenStandard,
+		Compressed:    asset.Compression.Compressed,
+	}
+	// Anyone can claim a collection, so only verified ones group NFTs.
+	for _, group := range asset.Grouping {
+		if group.GroupKey == "collection" && (group.Verified == nil || *group.Verified) {
+			nft.Collection = group.GroupValue
+		}
+	}
+	if asset.Compression.Compressed {
+		compression := asset.Compression
+		nft.Compression = &compression
+	}
+	return nft
+}
+
+// GroupNFTs groups NFTs by collection, largest collection first. NFTs
+// without a collection end up in a group with an empty Collection.
+func GroupNFTs(nfts []types.MyNFT) []types.NFTCollection {
+	index := make(map[string]int)
+	var collections []types.NFTCollection
+	for _, nft := range nfts {
+		i, ok := index[nft.Collection]
+		if !ok {
+			i = len(collections)
+			index[nft.Collection] = i
+			collections = append(collections, types.NFTCollection{Collection: nft.Collection})
+		}
+		collections[i].NFTs = append(collections[i].NFTs, nft)
+	}
+	sort.SliceStable(collections, func(a, b int) bool {
+		return len(collections[a].NFTs) > len(collections[b].NFTs)
+	})
+	return collections
+}
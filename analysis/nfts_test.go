@@ -0,0 +1,32 @@
+package analysis
+
+import (
+	"sol_test/types"
+	"testing"
+)
+
+func TestGroupNFTsOnVerifiedCollections(t *testing.T) {
+	verified, unverified := true, false
+	asset := func(mint, collection string, isVerified *bool) types.MyNFT {
+		var asset types.TokenMetaData
+		asset.Grouping = []types.Grouping{{GroupKey: "collection", GroupValue: collection, Verified: isVerified}}
+		return NFTFromAsset(mint, asset)
+	}
+	nfts := []types.MyNFT{
+		asset("a", "Lads", &verified),
+		// DAS leaves verified out when it only lists verified collections.
+		asset("b", "Lads", nil),
+		// Claims to be a Lad without the collection's signature.
+		asset("c", "Lads", &unverified),
+	}
+	collections := GroupNFTs(nfts)
+	if len(collections) != 2 {
+		t.Fatalf("collections = %+v, want Lads and no collection", collections)
+	}
+	if collections[0].Collection != "Lads" || len(collections[0].NFTs) != 2 {
+		t.Errorf("first collection = %+v, want the two verified Lads", collections[0])
+	}
+	if collections[1].Collection != "" || collections[1].NFTs[0].Address != "c" {
+		t.Errorf("second collection = %+v, want the unverified NFT alone", collections[1])
+	}
+}
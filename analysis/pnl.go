@@ -0,0 +1,191 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"sol_test/solana"
+	"sol_test/types"
+	"sort"
+	"strings"
+)
+
+// CostBasisMethod selects how sells are matched against earlier buys.
+type CostBasisMethod string
+
+const (
+	FIFO        CostBasisMethod = "fifo"
+	LIFO        CostBasisMethod = "lifo"
+	AverageCost CostBasisMethod = "average"
+)
+
+// ParseCostBasisMethod parses a method name, defaulting to FIFO when empty.
+func ParseCostBasisMethod(s string) (CostBasisMethod, error) {
+	switch method := CostBasisMethod(strings.ToLower(s)); method {
+	case "":
+		return FIFO, nil
+	case FIFO, LIFO, AverageCost:
+		return method, nil
+	}
+	return "", fmt.Errorf("unknown cost basis method %q", s)
+}
+
+// PriceLookup returns the USD price of mint at a unix block time.
+type PriceLookup func(mint string, blockTime int64) (float64, bool)
+
+// Trade is a buy (positive Amount) or sell (negative Amount) of a single mint.
+type Trade struct {
+	Mint      string
+	BlockTime int64
+	Amount    float64
+	ValueUSD  float64
+	FeeUSD    float64
+}
+
+// Position is the result of replaying a mint's trades.
+type Position struct {
+	Mint string
+	// Amount is what is left of the bought lots.
+	Amount float64
+	// Invested is the total spent on buys, fees included.
+	Invested float64
+	// CostBasis is the cost of the lots still held.
+	CostBasis         float64
+	AverageEntryPrice float64
+	RealizedPnL       float64
+	UnrealizedPnL     float64
+	ROI               float64
+}
+
+// PnL is the realized plus unrealized profit of the position.
+func (p Position) PnL() float64 {
+	return p.RealizedPnL + p.UnrealizedPnL
+}
+
+type lot struct {
+	amount float64
+	cost   float64
+}
+
+// TradesFromSwaps splits swaps valued by ValueSwaps into per-mint trades.
+// Swaps without a USD value are skipped.
+func TradesFromSwaps(swaps []types.Swap) []Trade {
+	var trades []Trade
+	for _, swap := range swaps {
+		if swap.ValueUSD == 0 {
+			continue
+		}
+		sell := Trade{Mint: swap.InMint, BlockTime: swap.BlockTime, Amount: -swap.InAmount, ValueUSD: swap.ValueUSD}
+		buy := Trade{Mint: swap.OutMint, BlockTime: swap.BlockTime, Amount: swap.OutAmount, ValueUSD: swap.ValueUSD}
+		// The fee is charged once, to the token side of the swap: it lowers
+		// the proceeds of selling a token for a quote mint and raises the
+		// cost of buying one otherwise.
+		if isQuoteMint(swap.OutMint) && !isQuoteMint(swap.InMint) {
+			sell.FeeUSD = swap.FeeUSD
+		} else {
+			buy.FeeUSD = swap.FeeUSD
+		}
+		trades = append(trades, sell, buy)
+	}
+	return trades
+}
+
+func swapValue(swap types.Swap, prices PriceLookup) (float64, bool) {
+	sides := []struct {
+		mint   string
+		amount float64
+	}{{swap.InMint, swap.InAmount}, {swap.OutMint, swap.OutAmount}}
+	// Prefer the quote side of the trade.
+	sort.SliceStable(sides, func(a, b int) bool {
+		return isQuoteMint(sides[a].mint) && !isQuoteMint(sides[b].mint)
+	})
+	for _, side := range sides {
+		if price, ok := prices(side.mint, swap.BlockTime); ok {
+			return side.amount * price, true
+		}
+	}
+	return 0, false
+}
+
+func isQuoteMint(mint string) bool {
+	return mint == solana.NativeMint || solana.IsStablecoin(mint)
+}
+
+// ComputePosition replays the trades of mint in block time order and values
+// what is still held at currentPrice. Sells beyond the bought amount (tokens
+// received by transfer or airdrop) have no cost basis, so their proceeds are
+// fully realized profit.
+func ComputePosition(mint string, trades []Trade, method CostBasisMethod, currentPrice float64) Position {
+	var mintTrades []Trade
+	for _, trade := range trades {
+		if trade.Mint == mint {
+			mintTrades = append(mintTrades, trade)
+		}
+	}
+	sort.SliceStable(mintTrades, func(a, b int) bool {
+		return mintTrades[a].BlockTime < mintTrades[b].BlockTime
+	})
+
+	position := Position{Mint: mint}
+	var (
+		lots        []lot
+		totalBought float64
+	)
+	for _, trade := range mintTrades {
+		if trade.Amount > 0 {
+			cost := trade.ValueUSD + trade.FeeUSD
+			position.Invested += cost
+			totalBought += trade.Amount
+			lots = append(lots, lot{amount: trade.Amount, cost: cost})
+			if method == AverageCost {
+				lots = mergeLots(lots)
+			}
+			continue
+		}
+
+		sold := -trade.Amount
+		proceeds := trade.ValueUSD - trade.FeeUSD
+		var matchedCost float64
+		for sold > 0 && len(lots) > 0 {
+			i := 0
+			if method == LIFO {
+				i = len(lots) - 1
+			}
+			take := math.Min(sold, lots[i].amount)
+			cost := lots[i].cost * take / lots[i].amount
+			matchedCost += cost
+			lots[i].amount -= take
+			lots[i].cost -= cost
+			sold -= take
+			if lots[i].amount <= 0 {
+				lots = append(lots[:i], lots[i+1:]...)
+			}
+		}
+		position.RealizedPnL += proceeds - matchedCost
+	}
+
+	for _, l := range lots {
+		position.Amount += l.amount
+		position.CostBasis += l.cost
+	}
+	switch {
+	case position.Amount > 0:
+		position.AverageEntryPrice = position.CostBasis / position.Amount
+	case totalBought > 0:
+		position.AverageEntryPrice = position.Invested / totalBought
+	}
+	position.UnrealizedPnL = position.Amount*currentPrice - position.CostBasis
+	if position.Invested > 0 {
+		position.ROI = position.PnL() / position.Invested
+	}
+	return position
+}
+
+// mergeLots collapses all lots into one carrying the average cost.
+func mergeLots(lots []lot) []lot {
+	var merged lot
+	for _, l := range lots {
+		merged.amount += l.amount
+		merged.cost += l.cost
+	}
+	return []lot{merged}
+}
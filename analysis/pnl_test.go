@@ -0,0 +1,108 @@
+package analysis
+
+import (
+	"sol_test/solana"
+	"sol_test/types"
+	"testing"
+)
+
+func TestTradesFromSwapsChargesFeeToTokenSide(t *testing.T) {
+	buy := types.Swap{InMint: solana.NativeMint, InAmount: 1, OutMint: bonkMint, OutAmount: 1000, BlockTime: 1, ValueUSD: 100, FeeUSD: 1}
+	sell := types.Swap{InMint: bonkMint, InAmount: 1000, OutMint: usdcMint, OutAmount: 150, BlockTime: 2, ValueUSD: 150, FeeUSD: 1}
+	trades := TradesFromSwaps([]types.Swap{buy, sell})
+
+	fees := map[string]float64{}
+	for _, trade := range trades {
+		fees[trade.Mint] += trade.FeeUSD
+	}
+	if fees[bonkMint] != 2 || fees[solana.NativeMint] != 0 || fees[usdcMint] != 0 {
+		t.Fatalf("fees = %v, want both on %s", fees, bonkMint)
+	}
+
+	// Bought for 100 + 1, sold for 150 - 1.
+	position := ComputePosition(bonkMint, trades, FIFO, 0)
+	if position.RealizedPnL != 48 || position.Invested != 101 {
+		t.Errorf("realized %v on %v invested, want 48 on 101", position.RealizedPnL, position.Invested)
+	}
+}
+
+// costBasisTrades buys, oversells what was bought, then buys again around a
+// transfer in and sells part of the holdings. Every method matches the
+// oversell against the whole first lot and realizes 300 - 102 = 198 on it.
+var costBasisTrades = []Trade{
+	{Mint: bonkMint, BlockTime: 1, Amount: 100, ValueUSD: 100, FeeUSD: 2},
+	// 50 more than bought: their proceeds have no cost basis.
+	{Mint: bonkMint, BlockTime: 2, Amount: -150, ValueUSD: 300},
+	{Mint: bonkMint, BlockTime: 3, Amount: 100, ValueUSD: 200},
+	// Received by transfer, at no cost.
+	{Mint: bonkMint, BlockTime: 4, Amount: 50},
+	{Mint: bonkMint, BlockTime: 5, Amount: 100, ValueUSD: 400},
+	// Uses up one lot and part of the next.
+	{Mint: bonkMint, BlockTime: 6, Amount: -120, ValueUSD: 610, FeeUSD: 10},
+	{Mint: jupMint, BlockTime: 6, Amount: 1000, ValueUSD: 1000},
+}
+
+// costBasisPrice is the price the remaining 130 tokens are valued at.
+const costBasisPrice = 5
+
+func TestComputePositionFIFO(t *testing.T) {
+	// The first lot is gone; the sale takes the 100 bought for 200 and 20
+	// of the free ones, leaving 30 free and 100 bought for 400.
+	testCostBasis(t, FIFO, costBasisWant{
+		realized:     198 + 600 - 200,
+		unrealized:   130*costBasisPrice - 400,
+		costBasis:    400,
+		averageEntry: 400.0 / 130,
+	})
+}
+
+func TestComputePositionLIFO(t *testing.T) {
+	// The sale takes the 100 bought for 400 and 20 of the free ones,
+	// leaving 100 bought for 200 and 30 free.
+	testCostBasis(t, LIFO, costBasisWant{
+		realized:     198 + 600 - 400,
+		unrealized:   130*costBasisPrice - 200,
+		costBasis:    200,
+		averageEntry: 200.0 / 130,
+	})
+}
+
+func TestComputePositionAverageCost(t *testing.T) {
+	// 250 tokens cost 600 when the sale takes 120 of them at 2.4 each.
+	testCostBasis(t, AverageCost, costBasisWant{
+		realized:     198 + 600 - 288,
+		unrealized:   130*costBasisPrice - 312,
+		costBasis:    312,
+		averageEntry: 2.4,
+	})
+}
+
+// costBasisWant is the position expected from costBasisTrades.
+type costBasisWant struct {
+	realized, unrealized, costBasis, averageEntry float64
+}
+
+func testCostBasis(t *testing.T, method CostBasisMethod, want costBasisWant) {
+	t.Helper()
+	position := ComputePosition(bonkMint, costBasisTrades, method, costBasisPrice)
+	// Whatever the method, the totals are the same: the 130 held are worth
+	// 650, sales brought 900 and buys cost 702.
+	tests := []struct {
+		name      string
+		got, want float64
+	}{
+		{"realized", position.RealizedPnL, want.realized},
+		{"unrealized", position.UnrealizedPnL, want.unrealized},
+		{"cost basis", position.CostBasis, want.costBasis},
+		{"average entry", position.AverageEntryPrice, want.averageEntry},
+		{"amount", position.Amount, 130},
+		{"invested", position.Invested, 702},
+		{"total", position.PnL(), 650 + 900 - 702},
+		{"roi", position.ROI, 848.0 / 702},
+	}
+	for _, test := range tests {
+		if !closeTo(test.got, test.want) {
+			t.Errorf("%s %s = %v, want %v", method, test.name, test.got, test.want)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package analysis
+
+import (
+	"sol_test/solana"
+	"sol_test/types"
+	"sort"
+	"time"
+)
+
+// dustAmount is the balance below which a token is left out of a point.
+const dustAmount = 1e-9
+
+// PortfolioHistory rebuilds the wallet's holdings at every interval from to
+// back to from and values them with price. holdings are the current
+// balances by mint, with SOL and wrapped SOL under the wrapped SOL mint.
+// Holdings are rolled back by undoing the balance changes recorded in each
+// transaction, so transactions must cover at least everything after from,
+// newest first. Points are returned oldest first.
+func PortfolioHistory(wallet string, holdings map[string]float64, transactions []types.TransactionResponse, from, to time.Time, interval time.Duration, price PriceLookup) []types.PortfolioPoint {
+	current := make(map[string]float64, len(holdings))
+	for mint, amount := range holdings {
+		current[mint] = amount
+	}
+
+	var points []types.PortfolioPoint
+	next := 0
+	for at := to; !at.Before(from); at = at.Add(-interval) {
+		for ; next < len(transactions); next++ {
+			tx := transactions[next].Result
+			if tx == nil {
+				continue
+			}
+			if tx.BlockTime <= at.Unix() {
+				break
+			}
+			for mint, delta := range balanceDeltas(wallet, tx) {
+				current[mint] -= delta
+			}
+		}
+		points = append(points, portfolioPoint(current, at.Unix(), price))
+	}
+
+	for i, j := 0, len(points)-1; i < j; i, j = i+1, j-1 {
+		points[i], points[j] = points[j], points[i]
+	}
+	return points
+}
+
+// portfolioPoint values holdings at timestamp.
+func portfolioPoint(holdings map[string]float64, timestamp int64, price PriceLookup) types.PortfolioPoint {
+	point := types.PortfolioPoint{Timestamp: timestamp, SolBalance: holdings[solana.NativeMint]}
+	if solPrice, ok := price(solana.NativeMint, timestamp); ok {
+		point.SolValue = point.SolBalance * solPrice
+	}
+	point.Value = point.SolValue
+	for mint, amount := range holdings {
+		if mint == solana.NativeMint || amount < dustAmount {
+			continue
+		}
+		holding := types.TokenHolding{Mint: mint, Amount: amount}
+		if p, ok := price(mint, timestamp); ok {
+			holding.Price = p
+			holding.Value = amount * p
+		}
+		point.Value += holding.Value
+		point.Tokens = append(point.Tokens, holding)
+	}
+	sort.Slice(point.Tokens, func(a, b int) bool {
+		if point.Tokens[a].Value != point.Tokens[b].Value {
+			return point.Tokens[a].Value > point.Tokens[b].Value
+		}
+		return point.Tokens[a].Mint < point.Tokens[b].Mint
+	})
+	return point
+}
+
+// balanceDeltas returns how much the transaction changed the wallet's
+// balances by mint: the wallet account's lamports, fee included, and the
+// token accounts it owns. Wrapped SOL is counted as SOL.
+func balanceDeltas(wallet string, tx *types.TransactionResult) map[string]float64 {
+	deltas := make(map[string]float64)
+	for i, key := range AccountKeys(tx) {
+		if key == wallet && i < len(tx.Meta.PreBalances) && i < len(tx.Meta.PostBalances) {
+			deltas[solana.NativeMint] += float64(tx.Meta.PostBalances[i]-tx.Meta.PreBalances[i]) / solana.LamportsPerSol
+		}
+	}
+	for _, balance := range tx.Meta.PreTokenBalances {
+		if balance.Owner == wallet {
+			deltas[balance.Mint] -= balance.UiTokenAmount.UiAmount
+		}
+	}
+	for _, balance := range tx.Meta.PostTokenBalances {
+		if balance.Owner == wallet {
+			deltas[balance.Mint] += balance.UiTokenAmount.UiAmount
+		}
+	}
+	return deltas
+}
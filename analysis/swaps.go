@@ -0,0 +1,194 @@
+package analysis
+
+import (
+	"math"
+	"math/big"
+	"sol_test/solana"
+	"sol_test/types"
+	"sort"
+)
+
+// Swap venues.
+const (
+	VenueJupiter       = "jupiter"
+	VenueRaydiumAMM    = "raydium-amm"
+	VenueRaydiumCLMM   = "raydium-clmm"
+	VenueRaydiumCPMM   = "raydium-cpmm"
+	VenueOrcaWhirlpool = "orca-whirlpool"
+	VenuePumpFun       = "pump.fun"
+	VenuePumpSwap      = "pump-swap"
+	VenueMeteoraDLMM   = "meteora-dlmm"
+	VenueMeteoraAMM    = "meteora-amm"
+)
+
+// swapPrograms maps known DEX program IDs to their venue.
+var swapPrograms = map[string]string{
+	"JUP6LkbZbjS1jKKwapdHNy74zcZ3tLUZoi5QNyVTaV4":  VenueJupiter,
+	"JUP4Fb2cqiRUcaTHdrPC8h2gNsA2ETXiPDD33WcGuJB":  VenueJupiter,
+	"675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8": VenueRaydiumAMM,
+	"CAMMCzo5YL8w4VFF8KVHrK22GGUsp5VTaW7grrKgrWqK": VenueRaydiumCLMM,
+	"CPMMoo8L3F4NbTegBCKVNunggL7H1ZpdTHKxQB5qKP1C": VenueRaydiumCPMM,
+	"whirLbMiicVdio4qvUfM5KAg6Ct8VwpYzGff3uctyCc":  VenueOrcaWhirlpool,
+	"6EF8rrecthR5Dkzon8Nwu78hRvfCKubJ14M5uBEwF6P":  VenuePumpFun,
+	"pAMMBay6oceH9fJKBRHGP5D4bD4sWpmSwMn52FMfXEA":  VenuePumpSwap,
+	"LBUZKhRxPF3XUpBCjp4YzTKgLccjZhTSDM9o3Cm2Kvo":  VenueMeteoraDLMM,
+	"Eo7WjKq67rjJQSZxS6z3YkapzY3eMj6Xy8X5EQVn5UaB": VenueMeteoraAMM,
+}
+
+// solDustLamports is the SOL balance change below which SOL isn't considered
+// a side of the swap, e.g. leftover rent from token-to-token trades.
+const solDustLamports = 100_000
+
+// balanceChange is the wallet's net change of one mint in a transaction.
+type balanceChange struct {
+	mint     string
+	raw      *big.Int
+	decimals int
+}
+
+func (c balanceChange) amount() float64 {
+	f, _ := new(big.Float).SetInt(c.raw).Float64()
+	return math.Abs(f) / math.Pow10(c.decimals)
+}
+
+// DetectSwaps classifies the transactions in which wallet traded on a known DEX.
+func DetectSwaps(wallet string, transactions []types.TransactionResponse) []types.Swap {
+	swaps := []types.Swap{}
+	for _, tx := range transactions {
+		if tx.Result == nil {
+			continue
+		}
+		if swap, ok := DetectSwap(wallet, tx.Result); ok {
+			swaps = append(swaps, swap)
+		}
+	}
+	return swaps
+}
+
+// DetectSwap reports whether tx is a swap made by wallet. The venue comes
+// from the programs invoked (an aggregator wins over the AMMs it routes
+// through) and the amounts from the wallet's balance changes, so routed and
+// multi-hop trades collapse into a single in/out pair. When several mints
+// moved the same way, tokens take precedence over SOL.
+func DetectSwap(wallet string, tx *types.TransactionResult) (types.Swap, bool) {
+	if tx.Meta.Err != nil {
+		return types.Swap{}, false
+	}
+	keys := AccountKeys(tx)
+	venue := swapVenue(instructions(tx, keys))
+	if venue == "" {
+		return types.Swap{}, false
+	}
+
+	var in, out *balanceChange
+	for _, change := range walletChanges(wallet, tx, keys) {
+		switch change.raw.Sign() {
+		case -1:
+			if in == nil || change.mint != solana.NativeMint {
+				in = &change
+			}
+		case 1:
+			if out == nil || change.mint != solana.NativeMint {
+				out = &change
+			}
+		}
+	}
+	if in == nil || out == nil {
+		return types.Swap{}, false
+	}
+
+	swap := types.Swap{
+		Slot:      tx.Slot,
+		BlockTime: tx.BlockTime,
+		InMint:    in.mint,
+		InAmount:  in.amount(),
+		OutMint:   out.mint,
+		OutAmount: out.amount(),
+		Venue:     venue,
+	}
+	if len(tx.Transaction.Signatures) > 0 {
+		swap.Signature = tx.Transaction.Signatures[0]
+	}
+	if len(keys) > 0 && keys[0] == wallet {
+		swap.Fee = float64(tx.Meta.Fee) / solana.LamportsPerSol
+	}
+	return swap, true
+}
+
+// swapVenue returns the venue of the first aggregator invoked, or else of the first AMM.
+func swapVenue(ixs []instruction) string {
+	venue := ""
+	for _, ix := range ixs {
+		v, ok := swapPrograms[ix.program]
+		if !ok {
+			continue
+		}
+		if v == VenueJupiter {
+			return v
+		}
+		if venue == "" {
+			venue = v
+		}
+	}
+	return venue
+}
+
+// walletChanges returns the wallet's net change per mint, sorted by mint.
+// SOL is measured over the wallet account plus every token account it owns,
+// so wrapping SOL and paying rent for new token accounts cancel out, and the
+// network fee is added back. Wrapped SOL token balances are therefore skipped.
+func walletChanges(wallet string, tx *types.TransactionResult, keys []string) []balanceChange {
+	changes := make(map[string]*balanceChange)
+	add := func(mint string, decimals int, raw *big.Int) {
+		change, ok := changes[mint]
+		if !ok {
+			change = &balanceChange{mint: mint, raw: new(big.Int), decimals: decimals}
+			changes[mint] = change
+		}
+		change.raw.Add(change.raw, raw)
+	}
+
+	owned := make(map[int]bool)
+	for i, balances := range [][]types.TokenBalance{tx.Meta.PreTokenBalances, tx.Meta.PostTokenBalances} {
+		sign := big.NewInt(int64(2*i - 1)) // -1 for pre, +1 for post
+		for _, balance := range balances {
+			if balance.Owner != wallet {
+				continue
+			}
+			owned[balance.AccountIndex] = true
+			if balance.Mint == solana.NativeMint {
+				continue
+			}
+			raw, ok := new(big.Int).SetString(balance.UiTokenAmount.Amount, 10)
+			if !ok {
+				continue
+			}
+			add(balance.Mint, balance.UiTokenAmount.Decimals, raw.Mul(raw, sign))
+		}
+	}
+
+	var lamports int64
+	for i, key := range keys {
+		if key != wallet && !owned[i] {
+			continue
+		}
+		if i < len(tx.Meta.PostBalances) && i < len(tx.Meta.PreBalances) {
+			lamports += tx.Meta.PostBalances[i] - tx.Meta.PreBalances[i]
+		}
+	}
+	if len(keys) > 0 && keys[0] == wallet {
+		lamports += int64(tx.Meta.Fee)
+	}
+	if lamports <= -solDustLamports || lamports >= solDustLamports {
+		add(solana.NativeMint, 9, big.NewInt(lamports))
+	}
+
+	sorted := make([]balanceChange, 0, len(changes))
+	for _, change := range changes {
+		if change.raw.Sign() != 0 {
+			sorted = append(sorted, *change)
+		}
+	}
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].mint < sorted[b].mint })
+	return sorted
+}
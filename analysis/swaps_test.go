@@ -0,0 +1,118 @@
+package analysis
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sol_test/solana"
+	"sol_test/types"
+	"testing"
+)
+
+// swapWallet signs every transaction in testdata/swaps.
+const swapWallet = "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU"
+
+// Mints traded in testdata/swaps.
+const (
+	usdcMint = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+	bonkMint = "DezXAZ8z7PnrnRJjz3wXBoRgixCa6xjnB7YaB1pPB263"
+	jupMint  = "JUPyiwrYJFskUPiHa7hkeR8VUtAeFoSYbKedZNsDvCN"
+	wifMint  = "EKpQGSJtjMFqKZ9KQanSqYXRcF8fBopzLHYxdM65zcjm"
+	pumpMint = "2qEHjDLDLbuBgRYvsxhc5D6uDWAivNFZGan56P1tpump"
+)
+
+// loadTransaction reads a getTransaction response from testdata/swaps.
+func loadTransaction(t *testing.T, name string) *types.TransactionResult {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "swaps", name+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var response types.TransactionResponse
+	if err := json.Unmarshal(data, &response); err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if response.Result == nil {
+		t.Fatalf("%s: no result", name)
+	}
+	return response.Result
+}
+
+func TestDetectSwap(t *testing.T) {
+	tests := []struct {
+		fixture   string
+		venue     string
+		inMint    string
+		inAmount  float64
+		outMint   string
+		outAmount float64
+	}{
+		// SOL is wrapped into a temporary account closed in the same transaction.
+		{"raydium_amm", VenueRaydiumAMM, solana.NativeMint, 1, bonkMint, 41000},
+		{"raydium_clmm", VenueRaydiumCLMM, usdcMint, 200, jupMint, 245.123456},
+		// SOL is received in a temporary wSOL account and unwrapped.
+		{"orca_whirlpool", VenueOrcaWhirlpool, wifMint, 100, solana.NativeMint, 0.75},
+		// Routed through Whirlpool and Raydium; the aggregator names the venue,
+		// the intermediate SOL isn't a side, and the new account's rent isn't spent.
+		{"jupiter", VenueJupiter, usdcMint, 100, bonkMint, 3500000},
+		// The bonding curve fee is part of the SOL spent.
+		{"pump_fun", VenuePumpFun, solana.NativeMint, 0.505, pumpMint, 17234567.891234},
+		{"meteora_dlmm", VenueMeteoraDLMM, bonkMint, 41000, usdcMint, 25.4321},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			tx := loadTransaction(t, test.fixture)
+			swap, ok := DetectSwap(swapWallet, tx)
+			if !ok {
+				t.Fatal("no swap detected")
+			}
+			if swap.Venue != test.venue {
+				t.Errorf("venue = %q, want %q", swap.Venue, test.venue)
+			}
+			if swap.InMint != test.inMint || !closeTo(swap.InAmount, test.inAmount) {
+				t.Errorf("in = %v %s, want %v %s", swap.InAmount, swap.InMint, test.inAmount, test.inMint)
+			}
+			if swap.OutMint != test.outMint || !closeTo(swap.OutAmount, test.outAmount) {
+				t.Errorf("out = %v %s, want %v %s", swap.OutAmount, swap.OutMint, test.outAmount, test.outMint)
+			}
+			if swap.Signature != tx.Transaction.Signatures[0] || swap.Slot != tx.Slot {
+				t.Errorf("swap is for %s at %d, want %s at %d", swap.Signature, swap.Slot, tx.Transaction.Signatures[0], tx.Slot)
+			}
+			if swap.Fee != 0.000005 {
+				t.Errorf("fee = %v, want 0.000005", swap.Fee)
+			}
+		})
+	}
+}
+
+func TestDetectSwapIgnoresOtherWallets(t *testing.T) {
+	tx := loadTransaction(t, "raydium_amm")
+	if swap, ok := DetectSwap("11111111111111111111111111111111", tx); ok {
+		t.Errorf("detected %+v for a wallet that didn't trade", swap)
+	}
+}
+
+func TestDetectSwapIgnoresFailedTransactions(t *testing.T) {
+	tx := loadTransaction(t, "raydium_clmm")
+	tx.Meta.Err = map[string]interface{}{"InstructionError": []interface{}{1, "Custom"}}
+	if swap, ok := DetectSwap(swapWallet, tx); ok {
+		t.Errorf("detected %+v in a failed transaction", swap)
+	}
+}
+
+func TestDetectSwapRequiresKnownVenue(t *testing.T) {
+	tx := loadTransaction(t, "meteora_dlmm")
+	for i, key := range tx.Transaction.Message.AccountKeys {
+		if swapPrograms[key] != "" {
+			tx.Transaction.Message.AccountKeys[i] = "UnknownDex1111111111111111111111111111111111"
+		}
+	}
+	if swap, ok := DetectSwap(swapWallet, tx); ok {
+		t.Errorf("detected %+v without a known DEX program", swap)
+	}
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) <= 1e-9*math.Max(1, math.Abs(b))
+}
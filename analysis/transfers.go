@@ -0,0 +1,309 @@
+package analysis
+
+import (
+	"encoding/binary"
+	"math"
+	"sol_test/solana"
+	"sol_test/types"
+)
+
+// System program instructions.
+const (
+	systemTransfer         = 2
+	systemTransferWithSeed = 11
+)
+
+// SPL token instructions, shared by the Token and Token-2022 programs.
+const (
+	tokenTransfer        = 3
+	tokenMintTo          = 7
+	tokenBurn            = 8
+	tokenCloseAccount    = 9
+	tokenTransferChecked = 12
+	tokenMintToChecked   = 14
+	tokenBurnChecked     = 15
+)
+
+// instruction is a top-level or inner instruction with its accounts resolved.
+type instruction struct {
+	program  string
+	accounts []string
+	data     []byte
+}
+
+// tokenAccount is what the transaction's token balances tell us about a token account.
+type tokenAccount struct {
+	mint     string
+	owner    string
+	decimals int
+}
+
+// txContext holds the lookups needed to decode the instructions of one transaction.
+type txContext struct {
+	wallet        string
+	keys          []string
+	tokenAccounts map[string]tokenAccount
+	preBalances   map[string]int64
+}
+
+// DecodeTransactions turns raw transactions into transfer events relative to wallet.
+func DecodeTransactions(wallet string, transactions []types.TransactionResponse) []types.DecodedTransaction {
+	decoded := make([]types.DecodedTransaction, 0, len(transactions))
+	for _, tx := range transactions {
+		if tx.Result == nil {
+			continue
+		}
+		decoded = append(decoded, DecodeTransaction(wallet, tx.Result))
+	}
+	return decoded
+}
+
+// DecodeTransaction extracts SOL and SPL token transfers, mints, burns and
+// closed accounts from a transaction, including those made by inner
+// instructions. Failed transactions only carry their fee.
+func DecodeTransaction(wallet string, tx *types.TransactionResult) types.DecodedTransaction {
+	keys := AccountKeys(tx)
+	decoded := types.DecodedTransaction{
+		Slot:      tx.Slot,
+		BlockTime: tx.BlockTime,
+		Fee:       float64(tx.Meta.Fee) / solana.LamportsPerSol,
+		Failed:    tx.Meta.Err != nil,
+		Transfers: []types.TransferEvent{},
+	}
+	if len(tx.Transaction.Signatures) > 0 {
+		decoded.Signature = tx.Transaction.Signatures[0]
+	}
+	if len(keys) > 0 {
+		decoded.FeePayer = keys[0]
+	}
+	if decoded.Failed {
+		return decoded
+	}
+
+	ctx := txContext{
+		wallet:        wallet,
+		keys:          keys,
+		tokenAccounts: tokenAccounts(tx, keys),
+		preBalances:   make(map[string]int64),
+	}
+	for i, balance := range tx.Meta.PreBalances {
+		if i < len(keys) {
+			ctx.preBalances[keys[i]] = balance
+		}
+	}
+
+	for _, ix := range instructions(tx, keys) {
+		if event, ok := ctx.decode(ix); ok {
+			decoded.Transfers = append(decoded.Transfers, event)
+		}
+	}
+	return decoded
+}
+
+// AccountKeys returns the static account keys followed by the writable and
+// readonly addresses loaded from lookup tables, in the order instructions index them.
+func AccountKeys(tx *types.TransactionResult) []string {
+	keys := make([]string, 0, len(tx.Transaction.Message.AccountKeys)+
+		len(tx.Meta.LoadedAddresses.Writable)+len(tx.Meta.LoadedAddresses.Readonly))
+	keys = append(keys, tx.Transaction.Message.AccountKeys...)
+	keys = append(keys, tx.Meta.LoadedAddresses.Writable...)
+	keys = append(keys, tx.Meta.LoadedAddresses.Readonly...)
+	return keys
+}
+
+// instructions flattens the transaction's instructions in execution order,
+// each top-level instruction followed by the inner instructions it invoked.
+func instructions(tx *types.TransactionResult, keys []string) []instruction {
+	inner := make(map[int][]types.Instruction)
+	for _, group := range tx.Meta.InnerInstructions {
+		inner[group.Index] = append(inner[group.Index], group.Instructions...)
+	}
+
+	var flat []instruction
+	for i, ix := range tx.Transaction.Message.Instructions {
+		flat = append(flat, resolve(keys, ix.ProgramIdIndex, ix.Accounts, ix.Data))
+		for _, innerIx := range inner[i] {
+			flat = append(flat, resolve(keys, innerIx.ProgramIdIndex, innerIx.Accounts, innerIx.Data))
+		}
+	}
+	return flat
+}
+
+func resolve(keys []string, programIndex int, accountIndexes []int, data string) instruction {
+	ix := instruction{program: key(keys, programIndex)}
+	for _, index := range accountIndexes {
+		ix.accounts = append(ix.accounts, key(keys, index))
+	}
+	// Undecodable data simply yields no event.
+	ix.data, _ = solana.DecodeBase58(data)
+	return ix
+}
+
+func key(keys []string, index int) string {
+	if index < 0 || index >= len(keys) {
+		return ""
+	}
+	return keys[index]
+}
+
+// tokenAccounts maps every token account in the transaction to its mint,
+// owner and decimals using the pre and post token balances.
+func tokenAccounts(tx *types.TransactionResult, keys []string) map[string]tokenAccount {
+	accounts := make(map[string]tokenAccount)
+	for _, balances := range [][]types.TokenBalance{tx.Meta.PreTokenBalances, tx.Meta.PostTokenBalances} {
+		for _, balance := range balances {
+			accounts[key(keys, balance.AccountIndex)] = tokenAccount{
+				mint:     balance.Mint,
+				owner:    balance.Owner,
+				decimals: balance.UiTokenAmount.Decimals,
+			}
+		}
+	}
+	return accounts
+}
+
+func (c *txContext) decode(ix instruction) (types.TransferEvent, bool) {
+	switch ix.program {
+	case solana.SystemProgramID:
+		return c.decodeSystem(ix)
+	case solana.TokenProgramID, solana.Token2022ProgramID:
+		return c.decodeToken(ix)
+	}
+	return types.TransferEvent{}, false
+}
+
+func (c *txContext) decodeSystem(ix instruction) (types.TransferEvent, bool) {
+	if len(ix.data) < 12 {
+		return types.TransferEvent{}, false
+	}
+	lamports := binary.LittleEndian.Uint64(ix.data[4:12])
+	var from, to string
+	switch binary.LittleEndian.Uint32(ix.data[:4]) {
+	case systemTransfer:
+		if len(ix.accounts) < 2 {
+			return types.TransferEvent{}, false
+		}
+		from, to = ix.accounts[0], ix.accounts[1]
+	case systemTransferWithSeed:
+		if len(ix.accounts) < 3 {
+			return types.TransferEvent{}, false
+		}
+		from, to = ix.accounts[0], ix.accounts[2]
+	default:
+		return types.TransferEvent{}, false
+	}
+	return c.event(types.TransferTypeTransfer, ix.program, from, to, "", "", solana.NativeMint, lamports, 9), true
+}
+
+func (c *txContext) decodeToken(ix instruction) (types.TransferEvent, bool) {
+	if len(ix.data) == 0 {
+		return types.TransferEvent{}, false
+	}
+	kind := ix.data[0]
+	if kind == tokenCloseAccount {
+		return c.decodeCloseAccount(ix)
+	}
+	if len(ix.data) < 9 {
+		return types.TransferEvent{}, false
+	}
+	amount := binary.LittleEndian.Uint64(ix.data[1:9])
+
+	switch kind {
+	case tokenTransfer, tokenTransferChecked:
+		source, destination := 0, 1
+		if kind == tokenTransferChecked {
+			destination = 2
+		}
+		if len(ix.accounts) <= destination {
+			return types.TransferEvent{}, false
+		}
+		fromAccount, toAccount := ix.accounts[source], ix.accounts[destination]
+		info := c.tokenAccounts[fromAccount]
+		if info.mint == "" {
+			info = c.tokenAccounts[toAccount]
+		}
+		if kind == tokenTransferChecked {
+			info.mint = ix.accounts[1]
+			if len(ix.data) >= 10 {
+				info.decimals = int(ix.data[9])
+			}
+		}
+		return c.event(types.TransferTypeTransfer, ix.program,
+			c.owner(fromAccount), c.owner(toAccount), fromAccount, toAccount,
+			info.mint, amount, info.decimals), true
+
+	case tokenMintTo, tokenMintToChecked:
+		if len(ix.accounts) < 2 {
+			return types.TransferEvent{}, false
+		}
+		mint, toAccount := ix.accounts[0], ix.accounts[1]
+		decimals := c.tokenAccounts[toAccount].decimals
+		if kind == tokenMintToChecked && len(ix.data) >= 10 {
+			decimals = int(ix.data[9])
+		}
+		return c.event(types.TransferTypeMint, ix.program, "", c.owner(toAccount), "", toAccount, mint, amount, decimals), true
+
+	case tokenBurn, tokenBurnChecked:
+		if len(ix.accounts) < 2 {
+			return types.TransferEvent{}, false
+		}
+		fromAccount, mint := ix.accounts[0], ix.accounts[1]
+		decimals := c.tokenAccounts[fromAccount].decimals
+		if kind == tokenBurnChecked && len(ix.data) >= 10 {
+			decimals = int(ix.data[9])
+		}
+		return c.event(types.TransferTypeBurn, ix.program, c.owner(fromAccount), "", fromAccount, "", mint, amount, decimals), true
+	}
+	return types.TransferEvent{}, false
+}
+
+// decodeCloseAccount reports the rent lamports released to the destination
+// when a token account is closed.
+func (c *txContext) decodeCloseAccount(ix instruction) (types.TransferEvent, bool) {
+	if len(ix.accounts) < 3 {
+		return types.TransferEvent{}, false
+	}
+	account, destination, owner := ix.accounts[0], ix.accounts[1], ix.accounts[2]
+	lamports := c.preBalances[account]
+	if lamports < 0 {
+		lamports = 0
+	}
+	return c.event(types.TransferTypeCloseAccount, ix.program, owner, destination, account, "", solana.NativeMint, uint64(lamports), 9), true
+}
+
+// owner returns the wallet owning a token account, falling back to the
+// account itself when the transaction doesn't say.
+func (c *txContext) owner(account string) string {
+	if info, ok := c.tokenAccounts[account]; ok && info.owner != "" {
+		return info.owner
+	}
+	return account
+}
+
+func (c *txContext) event(kind, program, from, to, fromAccount, toAccount, mint string, amount uint64, decimals int) types.TransferEvent {
+	return types.TransferEvent{
+		Type:        kind,
+		Program:     program,
+		From:        from,
+		To:          to,
+		FromAccount: fromAccount,
+		ToAccount:   toAccount,
+		Mint:        mint,
+		RawAmount:   amount,
+		Decimals:    decimals,
+		Amount:      float64(amount) / math.Pow10(decimals),
+		Direction:   direction(c.wallet, from, to),
+	}
+}
+
+func direction(wallet, from, to string) string {
+	switch {
+	case from == wallet && to == wallet:
+		return types.DirectionSelf
+	case from == wallet:
+		return types.DirectionOut
+	case to == wallet:
+		return types.DirectionIn
+	}
+	return types.DirectionNone
+}
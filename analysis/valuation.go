@@ -0,0 +1,210 @@
+package analysis
+
+import (
+	"sol_test/solana"
+	"sol_test/types"
+	"sort"
+	"sync"
+	"time"
+)
+
+// SolUSDCPool is the SOL/USDC pool used for SOL's price history, SOL being
+// its base token.
+const SolUSDCPool = "58oQChx4yWmvKdwLLZzBi4ChoCc2fqCUWBkwMihLYQo2"
+
+// CandleFetcher loads the most recent OHLCV candles of a token in a pool in USD.
+type CandleFetcher func(series types.PoolSeries, timeframe string, aggregate int) ([]types.Candle, error)
+
+// PoolFinder returns the pool series whose price history is used for mint.
+type PoolFinder func(mint string) (types.PoolSeries, error)
+
+// resolution is an OHLCV timeframe together with how far back its candles reach.
+type resolution struct {
+	timeframe string
+	aggregate int
+	interval  time.Duration
+}
+
+// resolutions go from finest to coarsest. Each request returns up to 1000
+// candles, so a resolution only covers 1000 intervals back from now.
+var resolutions = []resolution{
+	{"minute", 1, time.Minute},
+	{"minute", 15, 15 * time.Minute},
+	{"hour", 1, time.Hour},
+	{"hour", 4, 4 * time.Hour},
+	{"day", 1, 24 * time.Hour},
+}
+
+const candlesPerRequest = 1000
+
+type seriesKey struct {
+	series types.PoolSeries
+	res    resolution
+}
+
+// Valuer prices mints in USD at past block times from pool OHLCV data. Recent
+// times use minute candles and older ones progressively coarser timeframes,
+// fetching every pool and timeframe at most once.
+type Valuer struct {
+	fetch CandleFetcher
+	find  PoolFinder
+	now   time.Time
+
+	mu     sync.Mutex
+	pools  map[string]types.PoolSeries
+	series map[seriesKey][]types.Candle
+}
+
+// NewValuer creates a Valuer. SOL is always priced from SolUSDCPool and
+// stablecoins at 1 USD.
+func NewValuer(fetch CandleFetcher, find PoolFinder) *Valuer {
+	return &Valuer{
+		fetch:  fetch,
+		find:   find,
+		now:    time.Now(),
+		pools:  map[string]types.PoolSeries{solana.NativeMint: {Pool: SolUSDCPool, Token: "base"}},
+		series: make(map[seriesKey][]types.Candle),
+	}
+}
+
+// AddPool registers the pool series of mint, saving a lookup.
+func (v *Valuer) AddPool(mint string, series types.PoolSeries) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.pools[mint] = series
+}
+
+// AddCandles registers candles that were already fetched for series.
+func (v *Valuer) AddCandles(series types.PoolSeries, timeframe string, aggregate int, candles []types.Candle) {
+	for _, res := range resolutions {
+		if res.timeframe == timeframe && res.aggregate == aggregate {
+			v.mu.Lock()
+			v.series[seriesKey{series, res}] = sortCandles(candles)
+			v.mu.Unlock()
+			return
+		}
+	}
+}
+
+// Price returns the USD price of mint at blockTime. It is a PriceLookup.
+func (v *Valuer) Price(mint string, blockTime int64) (float64, bool) {
+	if solana.IsStablecoin(mint) {
+		return 1, true
+	}
+	series, ok := v.pool(mint)
+	if !ok {
+		return 0, false
+	}
+	age := v.now.Sub(time.Unix(blockTime, 0))
+	for _, res := range resolutions {
+		if age > res.interval*candlesPerRequest {
+			continue
+		}
+		if price, ok := interpolate(v.candles(series, res), blockTime, res.interval); ok {
+			return price, true
+		}
+		// Fall through to a coarser timeframe, which may have data where a
+		// thinly traded pool has none at minute resolution.
+	}
+	return 0, false
+}
+
+func (v *Valuer) pool(mint string) (types.PoolSeries, bool) {
+	v.mu.Lock()
+	series, ok := v.pools[mint]
+	v.mu.Unlock()
+	if ok {
+		return series, series.Pool != ""
+	}
+	series, err := v.find(mint)
+	if err != nil {
+		series = types.PoolSeries{}
+	}
+	// A pool without a single daily candle has never traded, as with most
+	// spam tokens; remembering that spares fetching the finer timeframes.
+	if series.Pool != "" && len(v.candles(series, resolutions[len(resolutions)-1])) == 0 {
+		series = types.PoolSeries{}
+	}
+	v.AddPool(mint, series)
+	return series, series.Pool != ""
+}
+
+func (v *Valuer) candles(series types.PoolSeries, res resolution) []types.Candle {
+	key := seriesKey{series, res}
+	v.mu.Lock()
+	candles, ok := v.series[key]
+	v.mu.Unlock()
+	if ok {
+		return candles
+	}
+	candles, _ = v.fetch(series, res.timeframe, res.aggregate)
+	candles = sortCandles(candles)
+	v.mu.Lock()
+	v.series[key] = candles
+	v.mu.Unlock()
+	return candles
+}
+
+// sortCandles returns a copy of candles in ascending time order.
+func sortCandles(candles []types.Candle) []types.Candle {
+	sorted := append([]types.Candle(nil), candles...)
+	sort.Slice(sorted, func(a, b int) bool { return sorted[a].Timestamp < sorted[b].Timestamp })
+	return sorted
+}
+
+// interpolate estimates the price at t from ascending candles. Inside a
+// candle the price moves linearly from open to close; in a gap between
+// candles it moves from the previous close to the next open. After the last
+// candle the last close holds, since the pool hasn't traded since.
+func interpolate(candles []types.Candle, t int64, interval time.Duration) (float64, bool) {
+	i := sort.Search(len(candles), func(i int) bool { return candles[i].Timestamp > t })
+	if i == 0 {
+		return 0, false
+	}
+	prev := candles[i-1]
+	length := int64(interval.Seconds())
+	end := prev.Timestamp + length
+	if t < end {
+		return prev.Open + (prev.Close-prev.Open)*float64(t-prev.Timestamp)/float64(length), true
+	}
+	if i == len(candles) {
+		return prev.Close, true
+	}
+	next := candles[i]
+	return prev.Close + (next.Open-prev.Close)*float64(t-end)/float64(next.Timestamp-end), true
+}
+
+// ValueActivity sets the USD value of every fee and of every transfer into or
+// out of the wallet at its block time. Transfers between other accounts, such
+// as the legs of a routed swap, aren't valued, as pricing their mints costs
+// lookups for no change in the wallet's worth.
+func ValueActivity(activity []types.DecodedTransaction, prices PriceLookup) {
+	for i := range activity {
+		tx := &activity[i]
+		if solPrice, ok := prices(solana.NativeMint, tx.BlockTime); ok {
+			tx.FeeUSD = tx.Fee * solPrice
+		}
+		for j := range tx.Transfers {
+			transfer := &tx.Transfers[j]
+			if transfer.Direction != types.DirectionIn && transfer.Direction != types.DirectionOut {
+				continue
+			}
+			if price, ok := prices(transfer.Mint, tx.BlockTime); ok {
+				transfer.ValueUSD = transfer.Amount * price
+			}
+		}
+	}
+}
+
+// ValueSwaps sets the USD value and fee of every swap at its block time. The
+// value comes from its SOL or stablecoin side when that side can be priced,
+// since that's what was actually paid, and from the token side otherwise.
+func ValueSwaps(swaps []types.Swap, prices PriceLookup) {
+	for i := range swaps {
+		swap := &swaps[i]
+		swap.ValueUSD, _ = swapValue(*swap, prices)
+		if solPrice, ok := prices(solana.NativeMint, swap.BlockTime); ok {
+			swap.FeeUSD = swap.Fee * solPrice
+		}
+	}
+}
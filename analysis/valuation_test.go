@@ -0,0 +1,67 @@
+package analysis
+
+import (
+	"sol_test/solana"
+	"sol_test/types"
+	"testing"
+	"time"
+)
+
+func TestValueActivityValuesOnlyTheWalletsTransfers(t *testing.T) {
+	activity := []types.DecodedTransaction{{
+		BlockTime: 1700000000,
+		Fee:       0.000005,
+		Transfers: []types.TransferEvent{
+			{Mint: usdcMint, Amount: 100, Direction: types.DirectionOut},
+			{Mint: jupMint, Amount: 250, Direction: types.DirectionNone},
+			{Mint: bonkMint, Amount: 3500000, Direction: types.DirectionIn},
+			{Mint: solana.NativeMint, Amount: 1, Direction: types.DirectionSelf},
+		},
+	}}
+	looked := make(map[string]int)
+	ValueActivity(activity, func(mint string, blockTime int64) (float64, bool) {
+		looked[mint]++
+		switch mint {
+		case usdcMint:
+			return 1, true
+		case bonkMint:
+			return 0.00003, true
+		case solana.NativeMint:
+			return 100, true
+		}
+		return 0, false
+	})
+	if looked[jupMint] != 0 {
+		t.Error("priced a transfer between other accounts")
+	}
+	if looked[solana.NativeMint] != 1 {
+		t.Errorf("SOL looked up %d times, want once for the fee", looked[solana.NativeMint])
+	}
+	transfers := activity[0].Transfers
+	if transfers[0].ValueUSD != 100 || !closeTo(transfers[2].ValueUSD, 105) {
+		t.Errorf("values = %v and %v, want 100 and 105", transfers[0].ValueUSD, transfers[2].ValueUSD)
+	}
+	if !closeTo(activity[0].FeeUSD, 0.0005) {
+		t.Errorf("fee = %v, want 0.0005", activity[0].FeeUSD)
+	}
+}
+
+func TestValuerSkipsPoolsThatNeverTraded(t *testing.T) {
+	spam := types.PoolSeries{Pool: "SpamPool", Token: "base"}
+	fetches := 0
+	valuer := NewValuer(func(series types.PoolSeries, timeframe string, aggregate int) ([]types.Candle, error) {
+		fetches++
+		return nil, nil
+	}, func(mint string) (types.PoolSeries, error) {
+		return spam, nil
+	})
+	now := time.Now().Unix()
+	for i := range 3 {
+		if _, ok := valuer.Price(pumpMint, now-int64(i)*60); ok {
+			t.Fatal("priced a pool without candles")
+		}
+	}
+	if fetches != 1 {
+		t.Errorf("fetched %d series, want only the daily one", fetches)
+	}
+}
@@ -0,0 +1,149 @@
+// Package cache keeps the results of slow upstream lookups for a limited
+// time. Values are stored as JSON so the same entries can live in memory and
+// on disk.
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"golang.org/x/sync/singleflight"
+)
+
+// Backend stores encoded values until they expire.
+type Backend interface {
+	// Get returns the value stored under key and its expiry. Expired values
+	// are reported as missing.
+	Get(key string) (value []byte, expires time.Time, ok bool)
+	// Set stores value under key until expires.
+	Set(key string, value []byte, expires time.Time)
+}
+
+// Stats counts lookups for one kind of data.
+type Stats struct {
+	Hits   int64 `json:"hits"`
+	Misses int64 `json:"misses"`
+	// Shared counts misses that waited for an identical lookup already in
+	// flight instead of fetching again.
+	Shared int64 `json:"shared"`
+}
+
+// Cache looks keys up in its backends in order and fetches missing values
+// once, however many goroutines ask for them concurrently.
+type Cache struct {
+	backends []Backend
+	group    singleflight.Group
+
+	mu    sync.Mutex
+	stats map[string]*Stats
+}
+
+// New returns a cache over backends, fastest first. Values found in a slower
+// backend are copied into the faster ones.
+func New(backends ...Backend) *Cache {
+	return &Cache{backends: backends, stats: make(map[string]*Stats)}
+}
+
+// Stats returns a snapshot of the lookup counts per kind.
+func (c *Cache) Stats() map[string]Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	stats := make(map[string]Stats, len(c.stats))
+	for kind, s := range c.stats {
+		stats[kind] = *s
+	}
+	return stats
+}
+
+func (c *Cache) count(kind string, update func(*Stats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.stats[kind]
+	if !ok {
+		s = &Stats{}
+		c.stats[kind] = s
+	}
+	update(s)
+}
+
+// get returns the encoded value of key from the first backend that has it.
+func (c *Cache) get(key string) ([]byte, bool) {
+	for i, backend := range c.backends {
+		value, expires, ok := backend.Get(key)
+		if !ok {
+			continue
+		}
+		for _, faster := range c.backends[:i] {
+			faster.Set(key, value, expires)
+		}
+		return value, true
+	}
+	return nil, false
+}
+
+func (c *Cache) set(key string, value []byte, ttl time.Duration) {
+	expires := time.Now().Add(ttl)
+	for _, backend := range c.backends {
+		backend.Set(key, value, expires)
+	}
+}
+
+// fetchTimeout bounds a fetch shared by every caller waiting on its key.
+const fetchTimeout = 2 * time.Minute
+
+// Fetch returns the cached value of key, calling fetch and caching its
+// result for ttl on a miss. kind groups keys for statistics and namespaces
+// them, so the same key can be used for different kinds of data. Errors are
+// returned without being cached. A nil cache always calls fetch.
+//
+// Concurrent misses share one fetch, which runs on a context detached from
+// the callers' cancellation, bounded by fetchTimeout, so a caller going away
+// doesn't fail the others. Each caller stops waiting when its ctx is done.
+func Fetch[T any](ctx context.Context, c *Cache, kind, key string, ttl time.Duration, fetch func(ctx context.Context) (T, error)) (T, error) {
+	if c == nil {
+		return fetch(ctx)
+	}
+	key = kind + ":" + key
+	if encoded, ok := c.get(key); ok {
+		var value T
+		if err := json.Unmarshal(encoded, &value); err == nil {
+			c.count(kind, func(s *Stats) { s.Hits++ })
+			return value, nil
+		}
+	}
+
+	leader := false
+	results := c.group.DoChan(key, func() (interface{}, error) {
+		leader = true
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), fetchTimeout)
+		defer cancel()
+		value, err := fetch(fetchCtx)
+		if err != nil {
+			return value, err
+		}
+		encoded, err := json.Marshal(value)
+		if err != nil {
+			log.Warn("Failed to encode cache entry", "key", key, "error", err)
+		} else {
+			c.set(key, encoded, ttl)
+		}
+		return value, nil
+	})
+	select {
+	case result := <-results:
+		c.count(kind, func(s *Stats) {
+			s.Misses++
+			if !leader {
+				s.Shared++
+			}
+		})
+		return result.Val.(T), result.Err
+	case <-ctx.Done():
+		c.count(kind, func(s *Stats) { s.Misses++ })
+		var zero T
+		return zero, ctx.Err()
+	}
+}
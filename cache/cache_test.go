@@ -0,0 +1,93 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFetchSurvivesLeaderCancellation(t *testing.T) {
+	c := New(NewMemory(16))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) (int, error) {
+		close(started)
+		select {
+		case <-release:
+			return 42, nil
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		}
+	}
+
+	leaderCtx, cancelLeader := context.WithCancel(context.Background())
+	leaderErr := make(chan error, 1)
+	go func() {
+		_, err := Fetch(leaderCtx, c, "test", "key", time.Minute, fetch)
+		leaderErr <- err
+	}()
+	<-started
+
+	follower := make(chan int, 1)
+	go func() {
+		value, err := Fetch(context.Background(), c, "test", "key", time.Minute, fetch)
+		if err != nil {
+			t.Errorf("follower failed: %v", err)
+		}
+		follower <- value
+	}()
+
+	cancelLeader()
+	if err := <-leaderErr; !errors.Is(err, context.Canceled) {
+		t.Errorf("leader = %v, want context.Canceled", err)
+	}
+	close(release)
+	if value := <-follower; value != 42 {
+		t.Errorf("follower got %d, want 42", value)
+	}
+	// The shared fetch completed and was cached despite the leader leaving.
+	value, err := Fetch(context.Background(), c, "test", "key", time.Minute, func(context.Context) (int, error) {
+		return 0, errors.New("fetched again")
+	})
+	if err != nil || value != 42 {
+		t.Errorf("cached value = %d, %v, want 42", value, err)
+	}
+}
+
+func TestDiskPruneRemovesExpiredEntries(t *testing.T) {
+	dir := t.TempDir()
+	disk, err := NewDisk(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	disk.Set("fresh", []byte("1"), time.Now().Add(time.Hour))
+	disk.Set("expired", []byte("2"), time.Now().Add(-time.Second))
+	abandoned := filepath.Join(dir, "tmp-abandoned")
+	if err := os.WriteFile(abandoned, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-2 * tmpMaxAge)
+	if err := os.Chtimes(abandoned, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tmp-writing"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if removed := disk.Prune(); removed != 2 {
+		t.Errorf("removed %d files, want the expired entry and the abandoned temporary file", removed)
+	}
+	if _, _, ok := disk.Get("fresh"); !ok {
+		t.Error("fresh entry pruned")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("%d files left, want the fresh entry and the write in progress", len(entries))
+	}
+}
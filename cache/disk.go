@@ -0,0 +1,138 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Disk is a Backend that keeps one file per entry in a directory, so cached
+// values survive restarts. Each file holds the expiry as unix nanoseconds
+// followed by the value. Expired entries are removed when read and by Run.
+type Disk struct {
+	dir string
+}
+
+// NewDisk returns a Disk backend storing entries in dir, creating it if needed.
+func NewDisk(dir string) (*Disk, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Disk{dir: dir}, nil
+}
+
+// path maps key to a file name that is safe on every file system.
+func (d *Disk) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(d.dir, hex.EncodeToString(sum[:]))
+}
+
+func (d *Disk) Get(key string) ([]byte, time.Time, bool) {
+	data, err := os.ReadFile(d.path(key))
+	if err != nil || len(data) < 8 {
+		return nil, time.Time{}, false
+	}
+	expires := time.Unix(0, int64(binary.LittleEndian.Uint64(data)))
+	if time.Now().After(expires) {
+		os.Remove(d.path(key))
+		return nil, time.Time{}, false
+	}
+	return data[8:], expires, true
+}
+
+func (d *Disk) Set(key string, value []byte, expires time.Time) {
+	data := make([]byte, 8+len(value))
+	binary.LittleEndian.PutUint64(data, uint64(expires.UnixNano()))
+	copy(data[8:], value)
+
+	// Write to a temporary file first so readers never see a partial entry.
+	tmp, err := os.CreateTemp(d.dir, "tmp-*")
+	if err != nil {
+		log.Warn("Failed to write cache entry", "error", err)
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), d.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		log.Warn("Failed to write cache entry", "error", err)
+	}
+}
+
+// tmpMaxAge is how old a temporary file must be before Prune treats it as
+// left behind by a crash rather than a write in progress.
+const tmpMaxAge = time.Hour
+
+// Run prunes the directory every interval until ctx is done.
+func (d *Disk) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if removed := d.Prune(); removed > 0 {
+			log.Info("Pruned disk cache", "dir", d.dir, "removed", removed)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Prune removes expired entries and abandoned temporary files, and returns
+// how many files it removed.
+func (d *Disk) Prune() int {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		log.Warn("Failed to list cache directory", "dir", d.dir, "error", err)
+		return 0
+	}
+	now := time.Now()
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		path := filepath.Join(d.dir, entry.Name())
+		var stale bool
+		if strings.HasPrefix(entry.Name(), "tmp-") {
+			info, err := entry.Info()
+			stale = err == nil && now.Sub(info.ModTime()) > tmpMaxAge
+		} else {
+			expires, ok := readExpiry(path)
+			stale = !ok || now.After(expires)
+		}
+		if stale && os.Remove(path) == nil {
+			removed++
+		}
+	}
+	return removed
+}
+
+// readExpiry reads the expiry at the start of an entry file. ok is false
+// for files too short to be entries.
+func readExpiry(path string) (expires time.Time, ok bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false
+	}
+	defer f.Close()
+	var header [8]byte
+	if _, err := io.ReadFull(f, header[:]); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, int64(binary.LittleEndian.Uint64(header[:]))), true
+}
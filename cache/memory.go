@@ -0,0 +1,61 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Memory is an in-memory Backend that evicts the least recently used entry
+// once it holds its maximum number of entries.
+type Memory struct {
+	mu      sync.Mutex
+	size    int
+	order   *list.List // Most recently used first.
+	entries map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+// NewMemory returns a Memory backend holding up to size entries.
+func NewMemory(size int) *Memory {
+	return &Memory{size: size, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+func (m *Memory) Get(key string) ([]byte, time.Time, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	element, ok := m.entries[key]
+	if !ok {
+		return nil, time.Time{}, false
+	}
+	entry := element.Value.(*memoryEntry)
+	if time.Now().After(entry.expires) {
+		m.order.Remove(element)
+		delete(m.entries, key)
+		return nil, time.Time{}, false
+	}
+	m.order.MoveToFront(element)
+	return entry.value, entry.expires, true
+}
+
+func (m *Memory) Set(key string, value []byte, expires time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if element, ok := m.entries[key]; ok {
+		entry := element.Value.(*memoryEntry)
+		entry.value, entry.expires = value, expires
+		m.order.MoveToFront(element)
+		return
+	}
+	m.entries[key] = m.order.PushFront(&memoryEntry{key: key, value: value, expires: expires})
+	for m.size > 0 && m.order.Len() > m.size {
+		oldest := m.order.Back()
+		m.order.Remove(oldest)
+		delete(m.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
@@ -5,6 +5,9 @@ go 1.23
 require (
 	github.com/charmbracelet/log v0.4.0
 	github.com/go-chi/chi/v5 v5.2.1
+	github.com/gorilla/websocket v1.5.3
+	go.etcd.io/bbolt v1.3.11
+	golang.org/x/sync v0.10.0
 )
 
 require (
@@ -19,5 +22,4 @@ require (
 	github.com/rivo/uniseg v0.4.7 // indirect
 	golang.org/x/exp v0.0.0-20240525044651-4c93da0ed11d // indirect
 	golang.org/x/sys v0.13.0 // indirect
-
 )
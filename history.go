@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sol_test/analysis"
+	"sol_test/requests"
+	"sol_test/solana"
+	"sol_test/types"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+)
+
+// historyIntervals are the intervals accepted by getHistoryHandler.
+var historyIntervals = map[string]time.Duration{
+	"1h": time.Hour,
+	"4h": 4 * time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// Defaults and limits for getHistoryHandler.
+const (
+	defaultHistoryPoints = 30
+	maxHistoryPoints     = 2000
+)
+
+// getHistoryHandler serves GET /{address}/history?interval=1d&from=&to=,
+// the wallet's value at every interval between from and to (unix seconds).
+// to defaults to now and from to 30 intervals earlier.
+func getHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	intervalName := query.Get("interval")
+	if intervalName == "" {
+		intervalName = "1d"
+	}
+	interval, ok := historyIntervals[intervalName]
+	if !ok {
+		http.Error(w, fmt.Sprintf("invalid interval %q", intervalName), http.StatusBadRequest)
+		return
+	}
+	from, err := queryTime(query, "from")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	to, err := queryTime(query, "to")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if to.IsZero() || to.After(time.Now()) {
+		to = time.Now()
+	}
+	if from.IsZero() {
+		from = to.Add(-defaultHistoryPoints * interval)
+	}
+	if from.After(to) {
+		http.Error(w, "from is after to", http.StatusBadRequest)
+		return
+	}
+	if to.Sub(from)/interval > maxHistoryPoints {
+		http.Error(w, fmt.Sprintf("more than %d points requested", maxHistoryPoints), http.StatusBadRequest)
+		return
+	}
+
+	history, err := getPortfolioHistory(r.Context(), chi.URLParam(r, "address"), from, to, interval)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	history.Interval = intervalName
+	b, err := json.Marshal(history)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// getPortfolioHistory rolls the wallet's current balances back through its
+// transactions and values them with pool OHLCV data. Staked SOL and NFTs
+// aren't included.
+func getPortfolioHistory(ctx context.Context, address string, from, to time.Time, interval time.Duration) (types.PortfolioHistory, error) {
+	logger := log.NewWithOptions(os.Stderr, log.Options{
+		ReportCaller:    true,
+		ReportTimestamp: true,
+		Prefix:          "GetHistory ",
+	})
+
+	wallet, err := requests.RequestAccountInfo(ctx, address)
+	if err != nil {
+		return types.PortfolioHistory{}, err
+	}
+	accounts, err := requests.RequestTokenAccounts(ctx, address)
+	if err != nil {
+		return types.PortfolioHistory{}, err
+	}
+	holdings := map[string]float64{solana.NativeMint: wallet.SolAmount}
+	for _, account := range accounts.Result.Value {
+		if analysis.IsNFTCandidate(account.Account.Data.Parsed.Info.TokenAmount) {
+			continue
+		}
+		holdings[account.Account.Data.Parsed.Info.Mint] += account.Account.Data.Parsed.Info.TokenAmount.UIAmount
+	}
+
+	// Only transactions after from are needed. Once the wallet's transactions
+	// are stored a full scan only fetches the new ones, which is cheaper than a
+	// windowed one; until then the windowed scan avoids fetching the whole
+	// history.
+	var opts requests.TransactionOptions
+	if store == nil {
+		opts.From = from
+	} else if _, err := store.LatestSignature(address); err != nil {
+		opts.From = from
+	}
+	transactions, failed, err := scanTransactions(ctx, logger, address, opts)
+	if err != nil {
+		return types.PortfolioHistory{}, err
+	}
+	if len(failed) > 0 {
+		logger.Warn("History misses transactions", "failed", len(failed))
+	}
+
+	valuer := analysis.NewValuer(func(series types.PoolSeries, timeframe string, aggregate int) ([]types.Candle, error) {
+		return requests.GetCoinGeckoOHLCVS(ctx, series, timeframe, aggregate, 0, 0)
+	}, func(mint string) (types.PoolSeries, error) {
+		return requests.GetTokenPools(ctx, mint)
+	})
+	return types.PortfolioHistory{
+		Address: address,
+		Points:  analysis.PortfolioHistory(address, holdings, transactions, from, to, interval, valuer.Price),
+	}, nil
+}
@@ -0,0 +1,437 @@
+// Package live keeps tracked wallets up to date between scans using Solana
+// WebSocket subscriptions.
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sol_test/requests"
+	"sol_test/solana"
+	"sol_test/types"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Commitment levels of the subscriptions. Balances and signatures are
+// reported once confirmed; ingestion follows a second logs subscription at
+// finalized, the level getSignaturesForAddress lists by default.
+const (
+	liveCommitment   = "confirmed"
+	ingestCommitment = "finalized"
+)
+
+// ingestDebounce is how long ingestion waits after a finalized transaction
+// for more to arrive, so a burst of transactions costs a single run.
+var ingestDebounce = 2 * time.Second
+
+// maxSignatures is the number of recent signatures kept per wallet.
+const maxSignatures = 50
+
+// unsubscribeTimeout bounds unsubscribing when a wallet is unwatched.
+const unsubscribeTimeout = 5 * time.Second
+
+// IngestFunc fetches and stores the wallet's transactions newer than the
+// stored ones.
+type IngestFunc func(ctx context.Context, address string) error
+
+// Watcher subscribes to each watched wallet's account, its token accounts
+// and the transactions mentioning it. Balances are updated from account
+// notifications, and new transactions are ingested once finalized.
+type Watcher struct {
+	client *requests.WSClient
+	budget *requests.RateLimiter
+	ingest IngestFunc
+	logger *log.Logger
+
+	mu      sync.Mutex
+	wallets map[string]*watched
+}
+
+// watched is the state and subscriptions of one wallet.
+type watched struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// refreshMu serializes refreshes so token subscriptions aren't doubled.
+	refreshMu sync.Mutex
+
+	// Guarded by Watcher.mu.
+	state         types.LiveWallet
+	subs          []*requests.Subscription
+	tokens        map[string]*requests.Subscription
+	ingesting     bool
+	ingestPending bool
+	ingestTimer   *time.Timer
+}
+
+// NewWatcher creates a watcher on client. RPC requests it makes over HTTP,
+// including ingestion, take tokens from budget. Every wallet is refreshed
+// and ingested after each reconnect, to catch up on missed notifications.
+func NewWatcher(client *requests.WSClient, budget *requests.RateLimiter, ingest IngestFunc) *Watcher {
+	w := &Watcher{
+		client: client,
+		budget: budget,
+		ingest: ingest,
+		logger: log.NewWithOptions(os.Stderr, log.Options{
+			ReportTimestamp: true,
+			Prefix:          "Live ",
+		}),
+		wallets: make(map[string]*watched),
+	}
+	client.OnConnect(w.resync)
+	return w
+}
+
+// Watch subscribes to the wallet until ctx is done or Unwatch is called.
+// Watching a watched wallet does nothing.
+func (w *Watcher) Watch(ctx context.Context, address string) error {
+	w.mu.Lock()
+	if _, ok := w.wallets[address]; ok {
+		w.mu.Unlock()
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	e := &watched{
+		ctx:    ctx,
+		cancel: cancel,
+		state: types.LiveWallet{
+			Address:       address,
+			TokenAccounts: make(map[string]types.LiveTokenAccount),
+		},
+		tokens: make(map[string]*requests.Subscription),
+	}
+	w.wallets[address] = e
+	w.mu.Unlock()
+
+	account, err := w.client.AccountSubscribe(ctx, address, liveCommitment)
+	if err != nil {
+		w.Unwatch(address)
+		return err
+	}
+	logs, err := w.client.LogsSubscribe(ctx, address, liveCommitment)
+	if err != nil {
+		account.Unsubscribe(ctx)
+		w.Unwatch(address)
+		return err
+	}
+	finalized, err := w.client.LogsSubscribe(ctx, address, ingestCommitment)
+	if err != nil {
+		account.Unsubscribe(ctx)
+		logs.Unsubscribe(ctx)
+		w.Unwatch(address)
+		return err
+	}
+	w.mu.Lock()
+	e.subs = append(e.subs, account, logs, finalized)
+	w.mu.Unlock()
+	go w.watchBalance(e, account)
+	go w.watchLogs(e, logs)
+	go w.watchFinalized(e, finalized)
+	go func() {
+		<-ctx.Done()
+		w.unsubscribe(e)
+	}()
+
+	// Until connected, the next resync takes care of the first refresh.
+	if w.client.Connected() {
+		go w.refresh(e)
+	}
+	return nil
+}
+
+// Unwatch cancels the wallet's subscriptions and forgets its live state.
+func (w *Watcher) Unwatch(address string) {
+	w.mu.Lock()
+	e, ok := w.wallets[address]
+	delete(w.wallets, address)
+	w.mu.Unlock()
+	if ok {
+		e.cancel()
+	}
+}
+
+// Wallet returns the live state of a watched wallet.
+func (w *Watcher) Wallet(address string) (types.LiveWallet, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	e, ok := w.wallets[address]
+	if !ok {
+		return types.LiveWallet{}, false
+	}
+	wallet := e.state
+	wallet.TokenAccounts = make(map[string]types.LiveTokenAccount, len(e.state.TokenAccounts))
+	for account, token := range e.state.TokenAccounts {
+		wallet.TokenAccounts[account] = token
+	}
+	wallet.Signatures = append([]string(nil), e.state.Signatures...)
+	return wallet, true
+}
+
+// resync refreshes and ingests every watched wallet after a reconnect.
+func (w *Watcher) resync() {
+	w.mu.Lock()
+	wallets := make([]*watched, 0, len(w.wallets))
+	for _, e := range w.wallets {
+		wallets = append(wallets, e)
+	}
+	w.mu.Unlock()
+	for _, e := range wallets {
+		go func() {
+			w.refresh(e)
+			w.requestIngest(e)
+		}()
+	}
+}
+
+// refresh reads the wallet's balance and token accounts over HTTP and
+// subscribes to token accounts opened since the last refresh.
+func (w *Watcher) refresh(e *watched) {
+	e.refreshMu.Lock()
+	defer e.refreshMu.Unlock()
+	ctx := requests.WithBudget(e.ctx, w.budget)
+	address := e.state.Address
+
+	wallet, err := requests.RequestAccountInfo(ctx, address)
+	if err != nil {
+		if e.ctx.Err() == nil {
+			w.logger.Warn("Failed to refresh balance", "address", address, "error", err)
+		}
+		return
+	}
+	accounts, err := requests.RequestTokenAccounts(ctx, address)
+	if err != nil {
+		if e.ctx.Err() == nil {
+			w.logger.Warn("Failed to refresh token accounts", "address", address, "error", err)
+		}
+		return
+	}
+
+	w.mu.Lock()
+	if slot := wallet.AccountInfo.Result.Context.Slot; slot >= e.state.Slot {
+		e.state.SolBalance = wallet.SolAmount
+		e.state.Slot = slot
+		e.state.UpdatedAt = time.Now()
+	}
+	listed := make(map[string]bool, len(accounts.Result.Value))
+	var opened []string
+	for _, account := range accounts.Result.Value {
+		listed[account.Pubkey] = true
+		w.updateToken(e, account.Pubkey, account.Account, accounts.Result.Context.Slot)
+		if _, ok := e.tokens[account.Pubkey]; !ok {
+			opened = append(opened, account.Pubkey)
+		}
+	}
+	var closed []*requests.Subscription
+	for account, sub := range e.tokens {
+		if !listed[account] {
+			delete(e.tokens, account)
+			delete(e.state.TokenAccounts, account)
+			closed = append(closed, sub)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, sub := range closed {
+		sub.Unsubscribe(e.ctx)
+	}
+	for _, account := range opened {
+		sub, err := w.client.AccountSubscribe(e.ctx, account, liveCommitment)
+		if err != nil {
+			if e.ctx.Err() == nil {
+				w.logger.Warn("Failed to subscribe to token account", "account", account, "error", err)
+			}
+			continue
+		}
+		w.mu.Lock()
+		// Unwatched meanwhile: unsubscribe has already collected e.tokens.
+		if e.ctx.Err() != nil {
+			w.mu.Unlock()
+			sub.Unsubscribe(context.Background())
+			return
+		}
+		e.tokens[account] = sub
+		w.mu.Unlock()
+		go w.watchToken(e, account, sub)
+	}
+}
+
+// updateToken records a token account's balance read at slot, unless a
+// newer one is known. w.mu must be held.
+func (w *Watcher) updateToken(e *watched, address string, account types.Account, slot int64) {
+	if current, ok := e.state.TokenAccounts[address]; ok && current.Slot > slot {
+		return
+	}
+	info := account.Data.Parsed.Info
+	e.state.TokenAccounts[address] = types.LiveTokenAccount{
+		Mint:     info.Mint,
+		Program:  account.Owner,
+		Amount:   info.TokenAmount.UIAmount,
+		Decimals: info.TokenAmount.Decimals,
+		Slot:     slot,
+	}
+	e.state.UpdatedAt = time.Now()
+}
+
+// watchBalance applies the wallet account's notifications to its SOL balance.
+func (w *Watcher) watchBalance(e *watched, sub *requests.Subscription) {
+	for result := range sub.C() {
+		var notification types.AccountNotification
+		if err := json.Unmarshal(result, &notification); err != nil {
+			w.logger.Warn("Invalid account notification", "error", err)
+			continue
+		}
+		// A wallet drained to zero lamports is closed and its value is null.
+		var account struct {
+			Lamports uint64 `json:"lamports"`
+		}
+		if string(notification.Value) != "null" {
+			if err := json.Unmarshal(notification.Value, &account); err != nil {
+				w.logger.Warn("Invalid account notification", "error", err)
+				continue
+			}
+		}
+		w.mu.Lock()
+		if notification.Context.Slot >= e.state.Slot {
+			e.state.SolBalance = float64(account.Lamports) / solana.LamportsPerSol
+			e.state.Slot = notification.Context.Slot
+			e.state.UpdatedAt = time.Now()
+		}
+		w.mu.Unlock()
+	}
+}
+
+// watchToken applies a token account's notifications to its balance.
+func (w *Watcher) watchToken(e *watched, address string, sub *requests.Subscription) {
+	for result := range sub.C() {
+		var notification types.AccountNotification
+		if err := json.Unmarshal(result, &notification); err != nil {
+			w.logger.Warn("Invalid account notification", "error", err)
+			continue
+		}
+		w.mu.Lock()
+		if e.tokens[address] != sub {
+			// Dropped by a refresh while the notification was queued.
+			w.mu.Unlock()
+			continue
+		}
+		if string(notification.Value) == "null" {
+			// Closed; the next refresh drops the subscription.
+			delete(e.state.TokenAccounts, address)
+			e.state.UpdatedAt = time.Now()
+		} else {
+			var account types.Account
+			if err := json.Unmarshal(notification.Value, &account); err != nil {
+				w.logger.Warn("Invalid token account notification", "account", address, "error", err)
+			} else {
+				w.updateToken(e, address, account, notification.Context.Slot)
+			}
+		}
+		w.mu.Unlock()
+	}
+}
+
+// watchLogs records the signatures of transactions mentioning the wallet.
+// Transactions that open or close token accounts also trigger a refresh, to
+// follow the accounts.
+func (w *Watcher) watchLogs(e *watched, sub *requests.Subscription) {
+	for result := range sub.C() {
+		var notification types.LogsNotification
+		if err := json.Unmarshal(result, &notification); err != nil {
+			w.logger.Warn("Invalid logs notification", "error", err)
+			continue
+		}
+		signature := notification.Value.Signature
+		w.mu.Lock()
+		e.state.Signatures = append([]string{signature}, e.state.Signatures...)
+		if len(e.state.Signatures) > maxSignatures {
+			e.state.Signatures = e.state.Signatures[:maxSignatures]
+		}
+		w.mu.Unlock()
+
+		if changesTokenAccounts(notification.Value.Logs) {
+			go w.refresh(e)
+		}
+	}
+}
+
+// watchFinalized ingests the wallet once finalized transactions stop
+// arriving for ingestDebounce.
+func (w *Watcher) watchFinalized(e *watched, sub *requests.Subscription) {
+	for range sub.C() {
+		w.mu.Lock()
+		if e.ingestTimer == nil {
+			e.ingestTimer = time.AfterFunc(ingestDebounce, func() {
+				if e.ctx.Err() == nil {
+					w.requestIngest(e)
+				}
+			})
+		} else {
+			e.ingestTimer.Reset(ingestDebounce)
+		}
+		w.mu.Unlock()
+	}
+}
+
+// changesTokenAccounts reports whether a transaction's logs show token
+// accounts being opened or closed.
+func changesTokenAccounts(logs []string) bool {
+	for _, line := range logs {
+		if strings.Contains(line, "Instruction: InitializeAccount") || strings.Contains(line, "Instruction: CloseAccount") {
+			return true
+		}
+	}
+	return false
+}
+
+// requestIngest runs the ingestion of the wallet, or queues one more run if
+// it is already running, so bursts of signatures cost at most two runs.
+func (w *Watcher) requestIngest(e *watched) {
+	w.mu.Lock()
+	if e.ingesting {
+		e.ingestPending = true
+		w.mu.Unlock()
+		return
+	}
+	e.ingesting = true
+	w.mu.Unlock()
+
+	go func() {
+		for {
+			err := w.ingest(requests.WithBudget(e.ctx, w.budget), e.state.Address)
+			w.mu.Lock()
+			if err == nil {
+				e.state.LastIngest = time.Now()
+			} else if e.ctx.Err() == nil {
+				w.logger.Warn("Ingestion failed", "address", e.state.Address, "error", err)
+			}
+			if !e.ingestPending || e.ctx.Err() != nil {
+				e.ingesting = false
+				w.mu.Unlock()
+				return
+			}
+			e.ingestPending = false
+			w.mu.Unlock()
+		}
+	}()
+}
+
+// unsubscribe cancels every subscription of an unwatched wallet.
+func (w *Watcher) unsubscribe(e *watched) {
+	w.mu.Lock()
+	subs := append([]*requests.Subscription(nil), e.subs...)
+	for _, sub := range e.tokens {
+		subs = append(subs, sub)
+	}
+	if e.ingestTimer != nil {
+		e.ingestTimer.Stop()
+	}
+	w.mu.Unlock()
+	ctx, cancel := context.WithTimeout(context.Background(), unsubscribeTimeout)
+	defer cancel()
+	for _, sub := range subs {
+		sub.Unsubscribe(ctx)
+	}
+}
@@ -0,0 +1,245 @@
+package live
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sol_test/requests"
+	"sol_test/types"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// testTimeout bounds every wait in the tests.
+const testTimeout = 5 * time.Second
+
+const (
+	testWallet    = "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU"
+	testSignature = "5h6xBEauJ3PK6SWCZ1PGjBvj8vDdWG3KpwATGy1ARAXFSDwt8GFXM7W5Ncn16wmqokgpiKRLuS83KUxyZyv2sUYv"
+)
+
+// rpcStandIn answers the HTTP requests of a refresh with a wallet holding
+// 1.5 SOL and no token accounts at slot 5.
+type rpcStandIn struct{}
+
+var rpcStandInResults = map[string]string{
+	"getAccountInfo":          `{"context":{"slot":5},"value":null}`,
+	"getBalance":              `{"context":{"slot":5},"value":1500000000}`,
+	"getTokenAccountsByOwner": `{"context":{"slot":5},"value":[]}`,
+}
+
+func (rpcStandIn) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	return json.Unmarshal([]byte(rpcStandInResults[method]), result)
+}
+
+func (s rpcStandIn) Batch(ctx context.Context, calls []requests.BatchCall) error {
+	for i := range calls {
+		calls[i].Err = s.Call(ctx, calls[i].Method, calls[i].Params, calls[i].Result)
+	}
+	return nil
+}
+
+// pubsubRequest is a request the stand-in node received, with the
+// subscription ID it was answered with.
+type pubsubRequest struct {
+	conn    *websocket.Conn
+	request types.RPCRequest
+	sub     int64
+}
+
+// pubsubStandIn is a local pubsub endpoint answering subscribe requests with
+// increasing subscription IDs and unsubscribe requests with true.
+type pubsubStandIn struct {
+	*httptest.Server
+	requests chan pubsubRequest
+
+	writeMu sync.Mutex
+	nextSub atomic.Int64
+}
+
+func newPubsubStandIn(t *testing.T) *pubsubStandIn {
+	t.Helper()
+	s := &pubsubStandIn{requests: make(chan pubsubRequest, 64)}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var request types.RPCRequest
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+			received := pubsubRequest{conn: conn, request: request}
+			var result interface{} = true
+			if !strings.HasSuffix(request.Method, "Unsubscribe") {
+				received.sub = s.nextSub.Add(1)
+				result = received.sub
+			}
+			s.write(conn, map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result})
+			s.requests <- received
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pubsubStandIn) write(conn *websocket.Conn, value interface{}) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	conn.WriteJSON(value)
+}
+
+// notify sends a notification with result to subscription sub.
+func (s *pubsubStandIn) notify(received pubsubRequest, method string, result string) {
+	s.write(received.conn, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  map[string]interface{}{"result": json.RawMessage(result), "subscription": received.sub},
+	})
+}
+
+// next returns the next request for method, skipping others.
+func (s *pubsubStandIn) next(t *testing.T, method string) pubsubRequest {
+	t.Helper()
+	timeout := time.After(testTimeout)
+	for {
+		select {
+		case received := <-s.requests:
+			if received.request.Method == method {
+				return received
+			}
+		case <-timeout:
+			t.Fatalf("no %s request", method)
+		}
+	}
+}
+
+// eventually waits until the watched wallet satisfies cond.
+func eventually(t *testing.T, w *Watcher, cond func(types.LiveWallet) bool) types.LiveWallet {
+	t.Helper()
+	deadline := time.Now().Add(testTimeout)
+	for {
+		wallet, ok := w.Wallet(testWallet)
+		if !ok {
+			t.Fatal("wallet isn't watched")
+		}
+		if cond(wallet) {
+			return wallet
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("wallet never reached the expected state: %+v", wallet)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWatcherFollowsNotifications(t *testing.T) {
+	defer func(debounce time.Duration) { ingestDebounce = debounce }(ingestDebounce)
+	ingestDebounce = 50 * time.Millisecond
+	requests.UseRPC(rpcStandIn{})
+	node := newPubsubStandIn(t)
+	ingested := make(chan string, 8)
+	client := requests.NewWSClient(requests.WebSocketURL(node.URL), nil)
+	watcher := NewWatcher(client, nil, func(ctx context.Context, address string) error {
+		ingested <- address
+		return nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	waitIngest := func() {
+		t.Helper()
+		select {
+		case address := <-ingested:
+			if address != testWallet {
+				t.Errorf("ingested %s, want %s", address, testWallet)
+			}
+		case <-time.After(testTimeout):
+			t.Fatal("wallet wasn't ingested")
+		}
+	}
+
+	if err := watcher.Watch(ctx, testWallet); err != nil {
+		t.Fatal(err)
+	}
+	// Subscriptions made before connecting are restored in no set order.
+	var account, logs, finalized pubsubRequest
+	for range 3 {
+		var received pubsubRequest
+		select {
+		case received = <-node.requests:
+		case <-time.After(testTimeout):
+			t.Fatal("missing subscribe requests")
+		}
+		params, _ := json.Marshal(received.request.Params)
+		switch {
+		case received.request.Method == "accountSubscribe":
+			account = received
+		case strings.Contains(string(params), ingestCommitment):
+			finalized = received
+		default:
+			logs = received
+		}
+	}
+	if account.sub == 0 || logs.sub == 0 || finalized.sub == 0 {
+		t.Fatal("want an account subscription and logs subscriptions at confirmed and finalized")
+	}
+	// Connecting refreshes the balance over HTTP and catches up on transactions.
+	eventually(t, watcher, func(wallet types.LiveWallet) bool { return wallet.SolBalance == 1.5 })
+	waitIngest()
+
+	node.notify(account, "accountNotification", `{"context":{"slot":10},"value":{"lamports":2000000000,"owner":"11111111111111111111111111111111"}}`)
+	wallet := eventually(t, watcher, func(wallet types.LiveWallet) bool { return wallet.Slot == 10 })
+	if wallet.SolBalance != 2 {
+		t.Errorf("balance = %v, want 2", wallet.SolBalance)
+	}
+
+	node.notify(logs, "logsNotification", `{"context":{"slot":11},"value":{"signature":"`+testSignature+`","err":null,"logs":[]}}`)
+	wallet = eventually(t, watcher, func(wallet types.LiveWallet) bool { return len(wallet.Signatures) == 1 })
+	if wallet.Signatures[0] != testSignature {
+		t.Errorf("signatures = %v", wallet.Signatures)
+	}
+	select {
+	case <-ingested:
+		t.Fatal("ingested before the transaction was finalized")
+	case <-time.After(2 * ingestDebounce):
+	}
+
+	// A burst of finalized transactions is ingested once.
+	for range 3 {
+		node.notify(finalized, "logsNotification", `{"context":{"slot":40},"value":{"signature":"`+testSignature+`","err":null,"logs":[]}}`)
+	}
+	waitIngest()
+	select {
+	case <-ingested:
+		t.Fatal("ingested more than once for a burst")
+	case <-time.After(2 * ingestDebounce):
+	}
+	if pending := len(node.requests); pending != 0 {
+		t.Errorf("%d unexpected requests to the node", pending)
+	}
+
+	watcher.Unwatch(testWallet)
+	node.next(t, "accountUnsubscribe")
+	node.next(t, "logsUnsubscribe")
+	node.next(t, "logsUnsubscribe")
+	if _, ok := watcher.Wallet(testWallet); ok {
+		t.Error("wallet still watched after Unwatch")
+	}
+}
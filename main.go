@@ -1,12 +1,25 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"os"
+	"sol_test/analysis"
+	"sol_test/cache"
+	"sol_test/live"
 	"sol_test/requests"
+	"sol_test/scheduler"
+	"sol_test/solana"
+	"sol_test/storage"
 	"sol_test/types"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -14,19 +27,144 @@ import (
 	"github.com/go-chi/chi/v5/middleware"
 )
 
+// store persists wallet scans, see storage.Repository. It is nil when
+// storage is disabled.
+var store storage.Repository
+
+// priceProvider produces every spot price served by the API.
+var priceProvider = requests.NewDefaultPriceProvider()
+
+// diskCachePruneInterval is how often expired entries are removed from the
+// CACHE_DIR cache.
+const diskCachePruneInterval = time.Hour
+
+// onChainPriceProvider reads pool reserves before asking any indexer, for
+// tokens too new to be listed anywhere.
+var onChainPriceProvider = requests.NewOnChainPriceProvider()
+
 func main() {
+	config, err := requests.LoadPoolConfig(os.Getenv("SOLANA_RPC_CONFIG"))
+	if err != nil {
+		log.Fatal("Failed to load RPC config", "error", err)
+	}
+	pool, err := requests.NewRPCPool(config)
+	if err != nil {
+		log.Fatal("Failed to create RPC pool", "error", err)
+	}
+	go pool.Run(context.Background())
+	requests.UseRPC(requests.WithRateLimit(pool, requests.NewRateLimiter(config.RequestsPerSecond, config.Burst)))
+	log.Info("RPC pool ready", "endpoints", len(config.Endpoints))
+
+	backends := []cache.Backend{cache.NewMemory(requests.DefaultCacheSize)}
+	if dir := os.Getenv("CACHE_DIR"); dir != "" {
+		disk, err := cache.NewDisk(dir)
+		if err != nil {
+			log.Fatal("Failed to open cache directory", "dir", dir, "error", err)
+		}
+		go disk.Run(context.Background(), diskCachePruneInterval)
+		backends = append(backends, disk)
+	}
+	requests.UseCache(cache.New(backends...))
+
+	// STORAGE_PATH=off disables persistence.
+	if path := os.Getenv("STORAGE_PATH"); path != "off" {
+		if path == "" {
+			path = "wallets.db"
+		}
+		repository, err := storage.OpenBolt(path)
+		if err != nil {
+			log.Fatal("Failed to open storage", "path", path, "error", err)
+		}
+		defer repository.Close()
+		store = repository
+
+		syncConfig, err := scheduler.LoadConfig()
+		if err != nil {
+			log.Fatal("Failed to load sync config", "error", err)
+		}
+		if tracker, err = scheduler.New(syncConfig, store, scanTrackedWallet); err != nil {
+			log.Fatal("Failed to load tracked wallets", "error", err)
+		}
+		go tracker.Run(context.Background())
+
+		// SOLANA_WS_URL=off disables live updates. By default the pubsub
+		// endpoint of the first RPC endpoint is used.
+		if wsURL := os.Getenv("SOLANA_WS_URL"); wsURL != "off" {
+			headers := config.Endpoints[0].Headers
+			if wsURL == "" {
+				wsURL = requests.WebSocketURL(config.Endpoints[0].URL)
+			}
+			client := requests.NewWSClient(wsURL, headers)
+			watcher = live.NewWatcher(client, tracker.Budget(), ingestTransactions)
+			for _, wallet := range tracker.Wallets() {
+				if err := watcher.Watch(context.Background(), wallet.Address); err != nil {
+					log.Warn("Failed to watch wallet", "address", wallet.Address, "error", err)
+				}
+			}
+			go client.Run(context.Background())
+		}
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
 	// Instead of writing "welcome", we now call our getWalletHandler.
+	r.Get("/debug/cache", cacheStatsHandler)
+	if tracker != nil {
+		r.Get("/wallets", listTrackedWalletsHandler)
+		r.Post("/wallets", trackWalletHandler)
+		r.Delete("/wallets/{address}", untrackWalletHandler)
+	}
 	r.Get("/{address}", getWalletHandler)
+	r.Get("/{address}/history", getHistoryHandler)
+	if watcher != nil {
+		r.Get("/{address}/live", getLiveWalletHandler)
+	}
 	log.Info("Server running on port", "port", 3000)
 	http.ListenAndServe(":3000", r)
 }
 
-// getWalletHandler wraps getWallet so it works as a chi handler.
+// cacheStatsHandler reports the cache hit and miss counts per kind of data.
+func cacheStatsHandler(w http.ResponseWriter, r *http.Request) {
+	b, err := json.Marshal(requests.CacheStats())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// getWalletHandler wraps getWallet so it works as a chi handler. Tracked
+// wallets requested with the default options are served from their latest
+// snapshot, with its staleness, unless fresh=true asks for a live scan.
 func getWalletHandler(w http.ResponseWriter, r *http.Request) {
-	wallet := getWallet(w, r)
+	address := chi.URLParam(r, "address")
+	query := r.URL.Query()
+	if len(query) == 0 {
+		snapshot, ok, err := trackedSnapshot(address)
+		if err != nil {
+			log.Warn("Failed to load snapshot", "address", address, "error", err)
+		}
+		if ok {
+			writeJSON(w, http.StatusOK, snapshot)
+			return
+		}
+	}
+	query.Del("fresh")
+	opts, err := parseWalletOptions(query)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	wallet, err := getWallet(r.Context(), address, opts)
+	if errors.Is(err, requests.ErrUnknownCurrency) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
 	b, err := json.Marshal(wallet)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -36,10 +174,9 @@ func getWalletHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(b)
 }
 
-// getWallet scans the wallet and populates price histories.
-// (No other changes have been made to your original logic.)
-func getWallet(w http.ResponseWriter, r *http.Request) types.MyWallet {
-	address := chi.URLParam(r, "address")
+// getWallet scans the wallet and attaches each token's price history.
+// RPC failures are returned instead of producing an empty wallet.
+func getWallet(ctx context.Context, address string, opts walletOptions) (types.MyWallet, error) {
 	logger := log.NewWithOptions(os.Stderr, log.Options{
 		ReportCaller:    true,
 		ReportTimestamp: true,
@@ -47,60 +184,679 @@ func getWallet(w http.ResponseWriter, r *http.Request) types.MyWallet {
 	})
 	logger.Info("Scanning wallet", "address", address)
 
-	wallet := requests.RequestAccountInfo(address)
-	solPrice, _ := requests.GetSolPrice()
-	accounts := requests.RequestTokenAccounts(address)
-	var addresses []string
+	wallet, err := requests.RequestAccountInfo(ctx, address)
+	if err != nil {
+		return types.MyWallet{}, err
+	}
+	accounts, err := requests.RequestTokenAccounts(ctx, address)
+	if err != nil {
+		return types.MyWallet{}, err
+	}
+	var addresses, token2022Mints []string
+	var fungible []types.TokenAccount
+	var nfts []types.MyNFT
 
 	for _, account := range accounts.Result.Value {
+		if analysis.IsNFTCandidate(account.Account.Data.Parsed.Info.TokenAmount) {
+			asset, err := requests.GetTokenMetadata(ctx, account.Account.Data.Parsed.Info.Mint)
+			if err != nil {
+				logger.Warn("NFT metadata unavailable", "address", account.Account.Data.Parsed.Info.Mint, "error", err)
+			}
+			if analysis.IsNFT(asset.Result) {
+				nfts = append(nfts, analysis.NFTFromAsset(account.Account.Data.Parsed.Info.Mint, asset.Result))
+				continue
+			}
+		}
+		fungible = append(fungible, account)
 		addresses = append(addresses, account.Account.Data.Parsed.Info.Mint)
+		if account.Account.Owner == solana.Token2022ProgramID {
+			token2022Mints = append(token2022Mints, account.Account.Data.Parsed.Info.Mint)
+		}
 	}
-	curr_prices := requests.GetCoinGeckoTokenPrices(addresses)
-	priceHistories := make(map[string][][]float64)
-	var tokens []types.MyToken
+	extensions, err := requests.GetMintExtensions(ctx, token2022Mints)
+	if err != nil {
+		logger.Warn("Token-2022 extensions unavailable", "error", err)
+	}
+	provider := priceProvider
+	if opts.OnChainPricing {
+		provider = onChainPriceProvider
+	}
+	prices, err := provider.Quotes(ctx, append(addresses, solana.NativeMint))
+	if err != nil {
+		logger.Warn("Prices unavailable", "error", err)
+	}
+	solPrice := prices[solana.NativeMint].Price
+	// Everything is computed in USD and converted to the requested currency at the end.
+	rate, err := requests.ExchangeRate(ctx, opts.Currency, solPrice)
+	if err != nil {
+		return types.MyWallet{}, err
+	}
+	valuer := analysis.NewValuer(func(series types.PoolSeries, timeframe string, aggregate int) ([]types.Candle, error) {
+		return requests.GetCoinGeckoOHLCVS(ctx, series, timeframe, aggregate, 0, 0)
+	}, func(mint string) (types.PoolSeries, error) {
+		return requests.GetTokenPools(ctx, mint)
+	})
+	var (
+		tokens   []types.MyToken
+		unpriced []string
+	)
 	walletValue := wallet.SolAmount * solPrice
-	for _, account := range accounts.Result.Value {
-		data := requests.GetTokenMetadata(account.Account.Data.Parsed.Info.Mint)
-		pool, _ := requests.GetTokenPools(account.Account.Data.Parsed.Info.Mint)
+	stakes, err := requests.GetStakeAccounts(ctx, address, opts.StakeRewardEpochs)
+	if err != nil {
+		logger.Warn("Stake accounts unavailable", "error", err)
+	}
+	var stakedSol float64
+	for i := range stakes {
+		stakedSol += stakes[i].Balance
+		stakes[i].Value = stakes[i].Balance * solPrice * rate
+	}
+	walletValue += stakedSol * solPrice
+	for _, account := range fungible {
+		data, err := requests.GetTokenMetadata(ctx, account.Account.Data.Parsed.Info.Mint)
+		if err != nil {
+			logger.Warn("Token metadata unavailable", "address", account.Account.Data.Parsed.Info.Mint, "error", err)
+		}
+		var series types.PoolSeries
+		pools, err := requests.GetRankedPools(ctx, account.Account.Data.Parsed.Info.Mint)
+		noMarket := errors.Is(err, requests.ErrNoMarket)
+		if err != nil && !noMarket {
+			logger.Warn("Pools unavailable", "address", account.Account.Data.Parsed.Info.Mint, "error", err)
+		}
+		if len(pools) > 0 {
+			series = requests.TokenSeries(pools[0], account.Account.Data.Parsed.Info.Mint)
+		}
 		logger.Info("Found Token", "token",
 			data.Result.Content.Metadata.Name,
 			"price",
 			account.Account.Data.Parsed.Info.TokenAmount.UIAmount,
 			"address",
 			account.Account.Data.Parsed.Info.Mint)
-		// TODO: make this for every transaction as we need smaller timeframes to get the prices.
-		prices, _ := requests.GetCoinGeckoOHLCVS(pool, "hour", 0, 0)
-		priceHistories[account.Account.Data.Parsed.Info.Mint] = prices
-		f, err := strconv.ParseFloat(curr_prices[account.Account.Data.Parsed.Info.Mint], 64)
-		if err != nil {
-			log.Error("Error occured", "Stack", err)
-			continue
+		var history []types.Candle
+		if series.Pool != "" {
+			valuer.AddPool(account.Account.Data.Parsed.Info.Mint, series)
+			if opts.Currency == "sol" && pools[0].BaseMint == account.Account.Data.Parsed.Info.Mint && pools[0].QuoteMint == solana.NativeMint {
+				// The pool trades against SOL, so GeckoTerminal has the series in SOL already.
+				history, err = requests.GetCoinGeckoTokenOHLCVS(ctx, series, opts.History.Timeframe, opts.History.Aggregate, opts.History.From, opts.History.To)
+			} else {
+				history, err = requests.GetCoinGeckoOHLCVS(ctx, series, opts.History.Timeframe, opts.History.Aggregate, opts.History.From, opts.History.To)
+				if err == nil && opts.History.From == 0 && opts.History.To == 0 {
+					// The latest page doubles as the valuer's data for that timeframe.
+					valuer.AddCandles(series, opts.History.Timeframe, opts.History.Aggregate, history)
+				}
+				history = convertCandles(history, rate)
+			}
+			if err != nil {
+				logger.Warn("Price history unavailable", "address", account.Account.Data.Parsed.Info.Mint, "error", err)
+			}
+		}
+		quote, ok := prices[account.Account.Data.Parsed.Info.Mint]
+		var (
+			isLST                          bool
+			solEquivalent, dexPrice, depeg float64
+		)
+		if requests.IsLST(account.Account.Data.Parsed.Info.Mint) && solPrice > 0 {
+			// LSTs are worth the SOL they redeem for, whatever thin DEX pools say.
+			solPerToken, err := requests.GetLSTRate(ctx, account.Account.Data.Parsed.Info.Mint)
+			if err != nil {
+				logger.Warn("Stake pool rate unavailable", "address", account.Account.Data.Parsed.Info.Mint, "error", err)
+			} else {
+				isLST = true
+				solEquivalent = account.Account.Data.Parsed.Info.TokenAmount.UIAmount * solPerToken
+				fair := solPerToken * solPrice
+				if ok {
+					dexPrice = quote.Price
+					depeg = quote.Price/fair - 1
+				}
+				quote, ok = types.PriceQuote{Price: fair, Source: "stake-pool"}, true
+			}
 		}
-		walletValue += account.Account.Data.Parsed.Info.TokenAmount.UIAmount * f
+		if !ok {
+			// Kept with a zero price and value rather than dropped.
+			logger.Warn("No price for token", "address", account.Account.Data.Parsed.Info.Mint)
+			unpriced = append(unpriced, account.Account.Data.Parsed.Info.Mint)
+		}
+		amount := account.Account.Data.Parsed.Info.TokenAmount.UIAmount
+		// What the holding would fetch when sold, after Token-2022 extensions.
+		saleAmount := amount
+		var ext *types.TokenExtensions
+		if e, ok := extensions[account.Account.Data.Parsed.Info.Mint]; ok {
+			ext = &e
+			decimals := account.Account.Data.Parsed.Info.TokenAmount.Decimals
+			raw, _ := strconv.ParseUint(account.Account.Data.Parsed.Info.TokenAmount.Amount, 10, 64)
+			amount = requests.Token2022Amount(e, raw, decimals, time.Now())
+			saleAmount = requests.Token2022Amount(e, raw-requests.TransferFee(e, raw), decimals, time.Now())
+			if e.NonTransferable {
+				saleAmount = 0
+			}
+			if data.Result.Content.Metadata.Name == "" && e.Metadata != nil {
+				data.Result.Content.Metadata.Name = e.Metadata.Name
+			}
+		}
+		walletValue += saleAmount * quote.Price
 		token := types.MyToken{
 			Name:           data.Result.Content.Metadata.Name,
 			Address:        account.Account.Data.Parsed.Info.Mint,
-			Pool:           pool,
+			Pool:           series.Pool,
 			Description:    data.Result.Content.Metadata.Description,
 			Image:          data.Result.Content.Links.Image,
-			Amount:         account.Account.Data.Parsed.Info.TokenAmount.UIAmount,
-			Price:          f,
-			History_prices: nil,
-			PnL:            0,
-			Invested:       0,
-			Value:          account.Account.Data.Parsed.Info.TokenAmount.UIAmount * f,
+			Amount:         amount,
+			Price:          quote.Price,
+			PriceMissing:   !ok,
+			PriceSource:    quote.Source,
+			PriceDisputed:  quote.Disputed,
+			History_prices: history,
+			Value:          saleAmount * quote.Price,
+			Pools:          pools,
+			NoMarket:       noMarket,
+			Program:        account.Account.Owner,
+			Extensions:     ext,
+			LST:            isLST,
+			SolEquivalent:  solEquivalent,
+			DexPrice:       dexPrice,
+			Depeg:          depeg,
 		}
 		tokens = append(tokens, token)
 	}
 
-	transactions := requests.GetTransactions(address)
-	return types.MyWallet{
-		Address:      address,
-		Value:        walletValue,
-		SolValue:     wallet.SolAmount * solPrice,
-		SolBalance:   wallet.SolAmount,
-		LastUpdated:  time.Now(),
-		Tokens:       tokens,
-		Transactions: transactions,
+	// Compressed NFTs have no token account and are only known to DAS.
+	assets, err := requests.GetAssetsByOwner(ctx, address)
+	if err != nil {
+		logger.Warn("Compressed NFTs unavailable", "error", err)
+	}
+	for _, asset := range assets {
+		if asset.Compression.Compressed && !asset.Burnt {
+			nfts = append(nfts, analysis.NFTFromAsset(asset.ID, asset))
+		}
+	}
+	collections := analysis.GroupNFTs(nfts)
+	for i := range collections {
+		if collections[i].Collection == "" {
+			continue
+		}
+		if collection, err := requests.GetTokenMetadata(ctx, collections[i].Collection); err == nil {
+			collections[i].Name = collection.Result.Content.Metadata.Name
+		}
+		if !opts.NFTFloorPrices {
+			continue
+		}
+		floor, err := requests.GetFloorPrice(ctx, collections[i].NFTs[0].Address)
+		if err != nil {
+			logger.Warn("Floor price unavailable", "collection", collections[i].Collection, "error", err)
+			continue
+		}
+		collections[i].FloorPrice = floor * solPrice * rate
+		collections[i].Value = collections[i].FloorPrice * float64(len(collections[i].NFTs))
+		walletValue += floor * solPrice * float64(len(collections[i].NFTs))
+	}
+
+	transactions, failedTransactions, err := scanTransactions(ctx, logger, address, opts.Transactions)
+	if err != nil {
+		return types.MyWallet{}, err
+	}
+	// Value every transfer, fee and swap at its block time; PnL builds on the swap values.
+	activity := analysis.DecodeTransactions(address, transactions)
+	analysis.ValueActivity(activity, valuer.Price)
+	swaps := analysis.DetectSwaps(address, transactions)
+	analysis.ValueSwaps(swaps, valuer.Price)
+	trades := analysis.TradesFromSwaps(swaps)
+	for i := range tokens {
+		position := analysis.ComputePosition(tokens[i].Address, trades, opts.CostBasis, tokens[i].Price)
+		if tokens[i].PriceMissing {
+			// Without a price the holding's worth is unknown, not zero.
+			position.UnrealizedPnL = 0
+			position.ROI = 0
+			if position.Invested > 0 {
+				position.ROI = position.PnL() / position.Invested
+			}
+		}
+		tokens[i].PnL = position.PnL()
+		tokens[i].Invested = position.Invested
+		tokens[i].AverageEntryPrice = position.AverageEntryPrice
+		tokens[i].RealizedPnL = position.RealizedPnL
+		tokens[i].UnrealizedPnL = position.UnrealizedPnL
+		tokens[i].ROI = position.ROI
+		tokens[i].Price *= rate
+		tokens[i].Value *= rate
+		tokens[i].PnL *= rate
+		tokens[i].Invested *= rate
+		tokens[i].AverageEntryPrice *= rate
+		tokens[i].RealizedPnL *= rate
+		tokens[i].UnrealizedPnL *= rate
+		tokens[i].DexPrice *= rate
+	}
+
+	result := types.MyWallet{
+		Address:            address,
+		Slot:               accounts.Result.Context.Slot,
+		Value:              walletValue * rate,
+		SolValue:           wallet.SolAmount * solPrice * rate,
+		Currency:           opts.Currency,
+		SolBalance:         wallet.SolAmount,
+		LastUpdated:        time.Now(),
+		Tokens:             tokens,
+		UnpricedTokens:     unpriced,
+		NFTs:               collections,
+		StakedSol:          stakedSol,
+		StakeAccounts:      stakes,
+		Transactions:       transactions,
+		Activity:           activity,
+		Swaps:              swaps,
+		FailedTransactions: failedTransactions,
+	}
+	if store != nil {
+		if err := store.SavePrices(result.LastUpdated, prices); err != nil {
+			logger.Warn("Failed to store prices", "error", err)
+		}
+		// Snapshots are kept for default scans only, so they can be compared
+		// and served to requests without parameters.
+		if opts.isDefault() {
+			if err := store.SaveWallet(result); err != nil {
+				logger.Warn("Failed to store wallet", "error", err)
+			}
+		}
+	}
+	return result, nil
+}
+
+// isDefault reports whether opts are those of a request without query
+// parameters.
+func (opts walletOptions) isDefault() bool {
+	defaults, err := parseWalletOptions(url.Values{})
+	return err == nil && opts == defaults
+}
+
+// isFullScan reports whether opts cover the wallet's whole history.
+func isFullScan(opts requests.TransactionOptions) bool {
+	return opts.SignatureOptions == (requests.SignatureOptions{})
+}
+
+// scanTransactions fetches the wallet's transactions. Full scans are
+// incremental when storage is enabled: syncTransactions stores what is new
+// and the result is read back from the store, including transactions RPC
+// nodes have pruned since.
+func scanTransactions(ctx context.Context, logger *log.Logger, address string, opts requests.TransactionOptions) ([]types.TransactionResponse, []types.FailedSignature, error) {
+	if store == nil || !isFullScan(opts) {
+		return requests.GetTransactions(ctx, address, opts)
+	}
+
+	transactions, failed, err := syncTransactions(ctx, logger, address, opts)
+	if err != nil {
+		return nil, nil, err
+	}
+	// Read after the sync, so transactions a concurrent sync stored are included.
+	stored, err := store.Transactions(address)
+	if err != nil {
+		return nil, nil, fmt.Errorf("load stored transactions: %w", err)
+	}
+	logger.Info("Scanned transactions", "new", len(transactions), "stored", len(stored), "failed", len(failed))
+	return mergeTransactions(transactions, stored), failed, nil
+}
+
+// Limits of syncTransactions.
+const (
+	// syncChunkSize is the number of signatures fetched between saves, so an
+	// interrupted sync keeps what it fetched.
+	syncChunkSize = 100
+	// maxFailedAttempts is how often a signature is requested, across syncs,
+	// before it is given up.
+	maxFailedAttempts = 20
+)
+
+// syncLocks keeps syncs of the same wallet, by scans, the scheduler and live
+// ingestion, from running at the same time.
+var syncLocks = newAddressLocks()
+
+// syncTransactions stores the wallet's transactions and returns those it
+// fetched. Signatures are fetched newest first in chunks of syncChunkSize,
+// each saved before the next, and a sync cursor records how far an
+// unfinished sync got so the next one continues there. An interrupted sync
+// is finished first, then only signatures newer than the stored ones are
+// fetched, and finally those earlier syncs failed to fetch are retried.
+// Signatures that fail are stored for later syncs to retry, as the cursor
+// has moved past them, until maxFailedAttempts or a permanent failure.
+func syncTransactions(ctx context.Context, logger *log.Logger, address string, opts requests.TransactionOptions) ([]types.TransactionResponse, []types.FailedSignature, error) {
+	unlock, err := syncLocks.lock(ctx, address)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer unlock()
+
+	run := &transactionSync{logger: logger, address: address, opts: opts}
+	pending, err := store.FailedSignatures(address)
+	if err != nil {
+		logger.Warn("Failed to load failed signatures", "error", err)
+	}
+	if cursor, err := store.SyncCursor(address); err == nil {
+		if err := run.fetchRange(ctx, cursor.Before, cursor.Until); err != nil {
+			return run.transactions, run.failed, err
+		}
+	}
+	var until string
+	if latest, err := store.LatestSignature(address); err == nil {
+		until = latest
+	}
+	if err := run.fetchRange(ctx, "", until); err != nil {
+		return run.transactions, run.failed, err
+	}
+	if err := store.SaveSyncCursor(address, storage.SyncCursor{}); err != nil {
+		logger.Warn("Failed to clear sync cursor", "error", err)
+	}
+
+	if len(pending) > 0 {
+		signatures := make([]string, len(pending))
+		for i, failed := range pending {
+			signatures[i] = failed.Signature
+		}
+		transactions, failed, err := requests.FetchTransactions(ctx, signatures, opts)
+		if saveErr := run.save(transactions, failed); saveErr != nil {
+			return run.transactions, run.failed, saveErr
+		}
+		if err != nil {
+			return run.transactions, run.failed, err
+		}
+	}
+	return run.transactions, run.failed, nil
+}
+
+// transactionSync is the progress of one syncTransactions call.
+type transactionSync struct {
+	logger  *log.Logger
+	address string
+	opts    requests.TransactionOptions
+
+	// transactions and failed are everything fetched and failed so far.
+	transactions []types.TransactionResponse
+	failed       []types.FailedSignature
+}
+
+// fetchRange fetches the signatures older than before and newer than until,
+// newest first, saving each chunk and moving the sync cursor past it.
+func (s *transactionSync) fetchRange(ctx context.Context, before, until string) error {
+	opts := requests.SignatureOptions{Before: before, Until: until}
+	return requests.EachSignaturePage(ctx, s.address, opts, func(page []types.WalletTransactionHashResponse) error {
+		for start := 0; start < len(page); start += syncChunkSize {
+			chunk := page[start:min(start+syncChunkSize, len(page))]
+			signatures := make([]string, len(chunk))
+			for i, sig := range chunk {
+				signatures[i] = sig.Signature
+			}
+			transactions, failed, err := requests.FetchTransactions(ctx, signatures, s.opts)
+			if saveErr := s.save(transactions, failed); saveErr != nil {
+				return saveErr
+			}
+			// An interrupted chunk is fetched again; stored transactions are replaced.
+			if err != nil {
+				return err
+			}
+			cursor := storage.SyncCursor{Before: signatures[len(signatures)-1], Until: until}
+			if err := store.SaveSyncCursor(s.address, cursor); err != nil {
+				return fmt.Errorf("store sync cursor: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// save stores fetched transactions and records failed signatures, logging
+// those given up on.
+func (s *transactionSync) save(transactions []types.TransactionResponse, failed []types.FailedSignature) error {
+	if err := store.SaveTransactions(s.address, transactions); err != nil {
+		return fmt.Errorf("store transactions: %w", err)
+	}
+	fetched := make([]string, 0, len(transactions))
+	for _, transaction := range transactions {
+		if signature := transactionSignature(transaction); signature != "" {
+			fetched = append(fetched, signature)
+		}
+	}
+	dropped, err := store.UpdateFailedSignatures(s.address, fetched, failed, maxFailedAttempts)
+	if err != nil {
+		return fmt.Errorf("store failed signatures: %w", err)
+	}
+	for _, failed := range dropped {
+		s.logger.Warn("Giving up on transaction", "signature", failed.Signature, "attempts", failed.Attempts, "permanent", failed.Permanent, "error", failed.Error)
+	}
+	s.transactions = append(s.transactions, transactions...)
+	s.failed = append(s.failed, failed...)
+	return nil
+}
+
+// addressLocks hands out one lock per wallet address.
+type addressLocks struct {
+	mu    sync.Mutex
+	locks map[string]*addressLock
+}
+
+// addressLock is held by whoever sent to ch. refs counts holders and
+// waiters, so the lock is forgotten once nobody needs it.
+type addressLock struct {
+	ch   chan struct{}
+	refs int
+}
+
+func newAddressLocks() *addressLocks {
+	return &addressLocks{locks: make(map[string]*addressLock)}
+}
+
+// lock waits until the address's lock is free or ctx is done, and returns
+// the function releasing it.
+func (l *addressLocks) lock(ctx context.Context, address string) (func(), error) {
+	l.mu.Lock()
+	lock, ok := l.locks[address]
+	if !ok {
+		lock = &addressLock{ch: make(chan struct{}, 1)}
+		l.locks[address] = lock
+	}
+	lock.refs++
+	l.mu.Unlock()
+	release := func() {
+		l.mu.Lock()
+		if lock.refs--; lock.refs == 0 {
+			delete(l.locks, address)
+		}
+		l.mu.Unlock()
+	}
+
+	select {
+	case lock.ch <- struct{}{}:
+		return func() {
+			<-lock.ch
+			release()
+		}, nil
+	case <-ctx.Done():
+		release()
+		return nil, ctx.Err()
+	}
+}
+
+// mergeTransactions appends the stored transactions to the fetched ones,
+// skipping those fetched again: Until excludes only the cursor itself, so
+// other transactions of the cursor's slot come back on every scan. The result
+// is newest first, retried older transactions included.
+func mergeTransactions(fetched, stored []types.TransactionResponse) []types.TransactionResponse {
+	seen := make(map[string]bool, len(fetched))
+	for _, transaction := range fetched {
+		if signature := transactionSignature(transaction); signature != "" {
+			seen[signature] = true
+		}
+	}
+	merged := fetched
+	for _, transaction := range stored {
+		if signature := transactionSignature(transaction); signature == "" || !seen[signature] {
+			merged = append(merged, transaction)
+		}
+	}
+	sort.SliceStable(merged, func(a, b int) bool {
+		return transactionSlot(merged[a]) > transactionSlot(merged[b])
+	})
+	return merged
+}
+
+func transactionSlot(transaction types.TransactionResponse) int {
+	if transaction.Result == nil {
+		return 0
+	}
+	return transaction.Result.Slot
+}
+
+// transactionSignature returns the transaction's first signature, its id.
+func transactionSignature(transaction types.TransactionResponse) string {
+	if transaction.Result == nil || len(transaction.Result.Transaction.Signatures) == 0 {
+		return ""
+	}
+	return transaction.Result.Transaction.Signatures[0]
+}
+
+// walletOptions holds the query parameters accepted by getWalletHandler.
+type walletOptions struct {
+	Transactions requests.TransactionOptions
+	CostBasis    analysis.CostBasisMethod
+	History      historyOptions
+	// OnChainPricing prices tokens from their pool reserves first.
+	OnChainPricing bool
+	// Currency is the lowercase code values are reported in, "usd" by default.
+	Currency string
+	// NFTFloorPrices values NFT collections at their floor price.
+	NFTFloorPrices bool
+	// StakeRewardEpochs is the number of past epochs of staking rewards to report.
+	StakeRewardEpochs int
+}
+
+// convertCandles returns USD candles in a currency worth rate units per USD.
+// Every candle uses the current rate.
+func convertCandles(candles []types.Candle, rate float64) []types.Candle {
+	if rate == 1 {
+		return candles
+	}
+	converted := make([]types.Candle, len(candles))
+	for i, candle := range candles {
+		converted[i] = types.Candle{
+			Timestamp: candle.Timestamp,
+			Open:      candle.Open * rate,
+			High:      candle.High * rate,
+			Low:       candle.Low * rate,
+			Close:     candle.Close * rate,
+			Volume:    candle.Volume * rate,
+		}
+	}
+	return converted
+}
+
+// historyOptions selects the candles attached to each token.
+type historyOptions struct {
+	Timeframe string
+	Aggregate int
+	From      int64
+	To        int64
+}
+
+// parseWalletOptions reads the transaction window from the query string:
+// before/until (signatures), limit, minSlot/maxSlot and from/to (unix seconds),
+// the PnL lot matching method (method=fifo|lifo|average) and the token price
+// history (timeframe=minute|hour|day, aggregate, historyFrom/historyTo in unix seconds).
+func parseWalletOptions(query url.Values) (walletOptions, error) {
+	opts := walletOptions{
+		History: historyOptions{Timeframe: "hour"},
+	}
+	var err error
+	switch timeframe := query.Get("timeframe"); timeframe {
+	case "":
+	case "minute", "hour", "day":
+		opts.History.Timeframe = timeframe
+	default:
+		return opts, fmt.Errorf("invalid timeframe %q", timeframe)
+	}
+	if opts.History.Aggregate, err = queryInt(query, "aggregate"); err != nil {
+		return opts, err
+	}
+	if opts.History.Aggregate <= 0 {
+		opts.History.Aggregate = 1
+	}
+	if opts.History.From, err = queryInt64(query, "historyFrom"); err != nil {
+		return opts, err
+	}
+	if opts.History.To, err = queryInt64(query, "historyTo"); err != nil {
+		return opts, err
+	}
+	opts.Currency = strings.ToLower(query.Get("currency"))
+	if opts.Currency == "" {
+		opts.Currency = "usd"
+	}
+	for _, c := range opts.Currency {
+		if c < 'a' || c > 'z' {
+			return opts, fmt.Errorf("invalid currency %q", query.Get("currency"))
+		}
+	}
+	opts.StakeRewardEpochs = requests.DefaultStakeRewardEpochs
+	if query.Has("rewardEpochs") {
+		if opts.StakeRewardEpochs, err = queryInt(query, "rewardEpochs"); err != nil {
+			return opts, err
+		}
+		if opts.StakeRewardEpochs < 0 {
+			return opts, fmt.Errorf("invalid rewardEpochs %d", opts.StakeRewardEpochs)
+		}
+		// Each epoch costs a getInflationReward call.
+		opts.StakeRewardEpochs = min(opts.StakeRewardEpochs, requests.MaxStakeRewardEpochs)
+	}
+	if nftFloor := query.Get("nftFloor"); nftFloor != "" {
+		if opts.NFTFloorPrices, err = strconv.ParseBool(nftFloor); err != nil {
+			return opts, fmt.Errorf("invalid nftFloor %q", nftFloor)
+		}
+	}
+	switch pricing := query.Get("pricing"); pricing {
+	case "", "default":
+	case "onchain":
+		opts.OnChainPricing = true
+	default:
+		return opts, fmt.Errorf("invalid pricing %q", pricing)
+	}
+	if opts.CostBasis, err = analysis.ParseCostBasisMethod(query.Get("method")); err != nil {
+		return opts, err
+	}
+	signatures := &opts.Transactions.SignatureOptions
+	signatures.Before = query.Get("before")
+	signatures.Until = query.Get("until")
+
+	if signatures.Limit, err = queryInt(query, "limit"); err != nil {
+		return opts, err
+	}
+	if signatures.MinSlot, err = queryInt64(query, "minSlot"); err != nil {
+		return opts, err
+	}
+	if signatures.MaxSlot, err = queryInt64(query, "maxSlot"); err != nil {
+		return opts, err
+	}
+	if signatures.From, err = queryTime(query, "from"); err != nil {
+		return opts, err
+	}
+	if signatures.To, err = queryTime(query, "to"); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+func queryInt(query url.Values, key string) (int, error) {
+	value, err := queryInt64(query, key)
+	return int(value), err
+}
+
+func queryInt64(query url.Values, key string) (int64, error) {
+	raw := query.Get(key)
+	if raw == "" {
+		return 0, nil
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s: %w", key, err)
+	}
+	return value, nil
+}
+
+// queryTime parses a unix timestamp in seconds.
+func queryTime(query url.Values, key string) (time.Time, error) {
+	seconds, err := queryInt64(query, key)
+	if err != nil || seconds == 0 {
+		return time.Time{}, err
 	}
+	return time.Unix(seconds, 0), nil
 }
@@ -0,0 +1,104 @@
+package requests
+
+import (
+	"context"
+	"fmt"
+	"sol_test/types"
+	"strings"
+	"time"
+)
+
+// birdeyeMaxAddresses is the maximum number of mints per Birdeye multi price request.
+const birdeyeMaxAddresses = 100
+
+// BirdeyeProvider prices tokens with the Birdeye API, which requires an API key.
+type BirdeyeProvider struct {
+	APIKey string
+}
+
+func (BirdeyeProvider) Name() string {
+	return "birdeye"
+}
+
+func (p BirdeyeProvider) headers() map[string]string {
+	return map[string]string{
+		"X-API-KEY": p.APIKey,
+		"x-chain":   "solana",
+	}
+}
+
+func (p BirdeyeProvider) Price(ctx context.Context, mint string) (float64, error) {
+	return batchPrice(ctx, p, mint)
+}
+
+func (p BirdeyeProvider) Prices(ctx context.Context, mints []string) (map[string]float64, error) {
+	prices := make(map[string]float64, len(mints))
+	for start := 0; start < len(mints); start += birdeyeMaxAddresses {
+		chunk := mints[start:min(start+birdeyeMaxAddresses, len(mints))]
+		request_url := fmt.Sprintf("https://public-api.birdeye.so/defi/multi_price?list_address=%s", strings.Join(chunk, ","))
+		var response struct {
+			Success bool `json:"success"`
+			Data    map[string]*struct {
+				Value float64 `json:"value"`
+			} `json:"data"`
+		}
+		if err := getJSON(ctx, request_url, p.headers(), &response); err != nil {
+			return prices, err
+		}
+		for mint, price := range response.Data {
+			if price != nil {
+				prices[mint] = price.Value
+			}
+		}
+	}
+	return prices, nil
+}
+
+// birdeyeIntervals maps timeframe and aggregate to Birdeye's interval names.
+var birdeyeIntervals = map[string]map[int]string{
+	"minute": {1: "1m", 3: "3m", 5: "5m", 15: "15m", 30: "30m"},
+	"hour":   {1: "1H", 2: "2H", 4: "4H", 6: "6H", 8: "8H", 12: "12H"},
+	"day":    {1: "1D", 3: "3D"},
+}
+
+func (p BirdeyeProvider) History(ctx context.Context, mint string, opts HistoryOptions) ([]types.Candle, error) {
+	interval, ok := birdeyeIntervals[opts.Timeframe][max(opts.Aggregate, 1)]
+	if !ok {
+		return nil, fmt.Errorf("%s: %s x%d candles: %w", p.Name(), opts.Timeframe, opts.Aggregate, ErrUnsupported)
+	}
+	to := opts.To
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+	request_url := fmt.Sprintf("https://public-api.birdeye.so/defi/ohlcv?address=%s&type=%s&time_from=%d&time_to=%d",
+		mint, interval, opts.From, to)
+	var response struct {
+		Data struct {
+			Items []struct {
+				UnixTime int64   `json:"unixTime"`
+				O        float64 `json:"o"`
+				H        float64 `json:"h"`
+				L        float64 `json:"l"`
+				C        float64 `json:"c"`
+				V        float64 `json:"v"`
+			} `json:"items"`
+		} `json:"data"`
+	}
+	if err := getJSON(ctx, request_url, p.headers(), &response); err != nil {
+		return nil, err
+	}
+	items := response.Data.Items
+	candles := make([]types.Candle, 0, len(items))
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		candles = append(candles, types.Candle{
+			Timestamp: item.UnixTime,
+			Open:      item.O,
+			High:      item.H,
+			Low:       item.L,
+			Close:     item.C,
+			Volume:    item.V,
+		})
+	}
+	return candles, nil
+}
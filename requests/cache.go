@@ -0,0 +1,35 @@
+package requests
+
+import (
+	"sol_test/cache"
+	"time"
+)
+
+// How long each kind of data is cached. Metadata almost never changes, pool
+// rankings drift slowly and prices move constantly.
+const (
+	metadataCacheTTL = 24 * time.Hour
+	poolsCacheTTL    = 15 * time.Minute
+	ohlcvCacheTTL    = 5 * time.Minute
+	solPriceCacheTTL = 30 * time.Second
+)
+
+// DefaultCacheSize is the number of entries kept by the default in-memory cache.
+const DefaultCacheSize = 10000
+
+// responseCache holds the results of metadata, pool, OHLCV and SOL price
+// lookups, see UseCache.
+var responseCache = cache.New(cache.NewMemory(DefaultCacheSize))
+
+// UseCache replaces the cache used by this package. A nil cache disables caching.
+func UseCache(c *cache.Cache) {
+	responseCache = c
+}
+
+// CacheStats returns the hit and miss counts of the package's cache per kind of data.
+func CacheStats() map[string]cache.Stats {
+	if responseCache == nil {
+		return nil
+	}
+	return responseCache.Stats()
+}
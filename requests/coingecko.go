@@ -1,79 +1,299 @@
 package requests
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math"
 	"net/http"
+	"sol_test/cache"
+	"sol_test/solana"
 	"sol_test/types"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/charmbracelet/log"
 )
 
-func GetCoinGeckoTokenPrices(addresses []string) map[string]string {
-	tokens := strings.Join(addresses, ",")
-	request_url := fmt.Sprintf("https://api.geckoterminal.com/api/v2/simple/networks/solana/token_price/%s", tokens)
-	resp, err := http.Get(request_url)
-	if err != nil {
-		log.Error("Error occured", "Stack", err)
-		return nil
+// GeckoTerminal allows this many addresses per token price request.
+const geckoTerminalMaxAddresses = 30
+
+// geckoTerminalConcurrency is the number of token price requests in flight at once.
+const geckoTerminalConcurrency = 4
+
+// geckoTerminalLimiter keeps requests to GeckoTerminal under its
+// public limit of 30 calls per minute.
+var geckoTerminalLimiter = NewRateLimiter(0.5, 10)
+
+// TokenPrices holds the USD prices returned by GetCoinGeckoTokenPrices.
+type TokenPrices struct {
+	Prices map[string]float64
+	// Missing lists the requested addresses without a price, either because
+	// GeckoTerminal doesn't know them or because their chunk failed.
+	Missing []string
+}
+
+// GetCoinGeckoTokenPrices prices addresses with GeckoTerminal. The addresses
+// are split into chunks that are fetched concurrently; a failed chunk doesn't
+// discard the others, its addresses are reported as missing and its error is
+// returned alongside the prices that were found.
+func GetCoinGeckoTokenPrices(ctx context.Context, addresses []string) (TokenPrices, error) {
+	var chunks [][]string
+	for start := 0; start < len(addresses); start += geckoTerminalMaxAddresses {
+		chunks = append(chunks, addresses[start:min(start+geckoTerminalMaxAddresses, len(addresses))])
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		log.Error("Error occured", "Stack", err)
-		return nil
+
+	results := make([]map[string]string, len(chunks))
+	errs := make([]error, len(chunks))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(geckoTerminalConcurrency, len(chunks)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				results[i], errs[i] = getTokenPriceChunk(ctx, chunks[i])
+			}
+		}()
+	}
+	for i := range chunks {
+		indexes <- i
 	}
+	close(indexes)
+	wg.Wait()
+
+	prices := TokenPrices{Prices: make(map[string]float64, len(addresses))}
+	for i, chunk := range chunks {
+		for _, address := range chunk {
+			price, err := strconv.ParseFloat(results[i][address], 64)
+			if err != nil || price <= 0 {
+				prices.Missing = append(prices.Missing, address)
+				continue
+			}
+			prices.Prices[address] = price
+		}
+	}
+	return prices, errors.Join(errs...)
+}
+
+// getTokenPriceChunk fetches the raw prices of at most
+// geckoTerminalMaxAddresses addresses. Unknown tokens are absent or empty.
+func getTokenPriceChunk(ctx context.Context, addresses []string) (map[string]string, error) {
+	if err := geckoTerminalLimiter.Wait(ctx, 1); err != nil {
+		return nil, err
+	}
+	request_url := fmt.Sprintf("https://api.geckoterminal.com/api/v2/simple/networks/solana/token_price/%s", strings.Join(addresses, ","))
 	var response types.CoinGeckoPriceResponse
-	err = json.Unmarshal([]byte(body), &response)
+	if err := getJSON(ctx, request_url, nil, &response); err != nil {
+		return nil, fmt.Errorf("token prices: %w", err)
+	}
+	return response.Data.Attributes.TokenPrices, nil
+}
+
+// ErrNoMarket is returned for tokens that aren't traded in any pool.
+var ErrNoMarket = errors.New("no market")
+
+// preferredQuoteBonus multiplies the score of pools quoted in SOL or a
+// stablecoin, whose prices convert to USD without another hop.
+const preferredQuoteBonus = 2
+
+// GetTokenPools returns the token's series in its most canonical pool.
+func GetTokenPools(ctx context.Context, address string) (types.PoolSeries, error) {
+	pools, err := GetRankedPools(ctx, address)
 	if err != nil {
+		return types.PoolSeries{}, err
+	}
+	return TokenSeries(pools[0], address), nil
+}
 
-		log.Error("Error occured", "Stack", err)
-		return nil
+// TokenSeries returns the series of mint in pool, whichever side it is on.
+func TokenSeries(pool types.MarketPool, mint string) types.PoolSeries {
+	token := "base"
+	if pool.QuoteMint == mint && pool.BaseMint != mint {
+		token = "quote"
 	}
-	return response.Data.Attributes.TokenPrices
+	return types.PoolSeries{Pool: pool.Address, Token: token}
 }
 
-func GetTokenPools(address string) (string, error) {
-	request_url := fmt.Sprintf("https://api.geckoterminal.com/api/v2/networks/solana/tokens/%s/pools?page=1", address)
-	resp, err := http.Get(request_url)
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
+// GetRankedPools returns every pool GeckoTerminal lists for the token,
+// ranked by liquidity, 24h volume and trade count, with pools against SOL or
+// a stablecoin preferred. Tokens without pools fail with ErrNoMarket.
+func GetRankedPools(ctx context.Context, address string) ([]types.MarketPool, error) {
+	return cache.Fetch(ctx, responseCache, "pools", address, poolsCacheTTL, func(ctx context.Context) ([]types.MarketPool, error) {
+		return getRankedPools(ctx, address)
+	})
+}
+
+func getRankedPools(ctx context.Context, address string) ([]types.MarketPool, error) {
+	if err := geckoTerminalLimiter.Wait(ctx, 1); err != nil {
+		return nil, err
 	}
+	request_url := fmt.Sprintf("https://api.geckoterminal.com/api/v2/networks/solana/tokens/%s/pools?page=1", address)
 	var response types.CoinGeckoPoolResponse
-	err = json.Unmarshal([]byte(body), &response)
-	if err != nil {
-		return "", err
+	if err := getJSON(ctx, request_url, nil, &response); err != nil {
+		return nil, fmt.Errorf("pools for %s: %w", address, err)
 	}
-	return response.Data[0].Attributes.Address, nil
+	if len(response.Data) == 0 {
+		return nil, fmt.Errorf("token %s: %w", address, ErrNoMarket)
+	}
+
+	pools := make([]types.MarketPool, 0, len(response.Data))
+	for _, data := range response.Data {
+		trades := data.Attributes.Transactions.H24
+		pool := types.MarketPool{
+			Address:         data.Attributes.Address,
+			Name:            data.Attributes.Name,
+			Dex:             data.Relationships.Dex.Data.ID,
+			BaseMint:        strings.TrimPrefix(data.Relationships.BaseToken.Data.ID, "solana_"),
+			QuoteMint:       strings.TrimPrefix(data.Relationships.QuoteToken.Data.ID, "solana_"),
+			ReserveUSD:      parseDecimal(data.Attributes.ReserveInUSD),
+			VolumeUSD24h:    parseDecimal(data.Attributes.VolumeUSD.H24),
+			Transactions24h: trades.Buys + trades.Sells,
+		}
+		pool.Score = poolScore(pool, address)
+		pools = append(pools, pool)
+	}
+	sort.SliceStable(pools, func(i, j int) bool {
+		return pools[i].Score > pools[j].Score
+	})
+	return pools, nil
 }
 
-func GetCoinGeckoOHLCVS(address string, timeframe string, start int64, end int64) ([][]float64, error) {
-	request_url := fmt.Sprintf("https://api.geckoterminal.com/api/v2/networks/solana/pools/%s/ohlcv/%s?currency=usd", address, timeframe)
-	resp, err := http.Get(request_url)
-	if err != nil {
-		return nil, err
+// poolScore rates how well a pool represents the token's market. Liquidity
+// and volume are what make a price hard to move; the trade count damps pools
+// whose volume comes from a handful of wash trades.
+func poolScore(pool types.MarketPool, mint string) float64 {
+	score := (pool.ReserveUSD + pool.VolumeUSD24h) * (1 + math.Log1p(float64(pool.Transactions24h))/10)
+	counter := pool.QuoteMint
+	if counter == mint {
+		counter = pool.BaseMint
 	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+	if counter == solana.NativeMint || solana.IsStablecoin(counter) {
+		score *= preferredQuoteBonus
+	}
+	return score
+}
+
+// parseDecimal parses GeckoTerminal's decimal strings, treating missing
+// values as zero.
+func parseDecimal(value string) float64 {
+	f, err := strconv.ParseFloat(value, 64)
 	if err != nil {
+		return 0
+	}
+	return f
+}
+
+// ohlcvLimit is the maximum number of candles GeckoTerminal returns per request.
+const ohlcvLimit = 1000
+
+// ohlcvMaxPages bounds how many pages a single history request stitches together.
+const ohlcvMaxPages = 50
+
+// GetCoinGeckoOHLCVS returns the candles of a token in a pool between start
+// and end (unix seconds), newest first. timeframe is "minute", "hour" or "day"; aggregate
+// groups that many timeframe units per candle (minute: 1, 5, 15; hour: 1, 4,
+// 12; day: 1). A zero end means now and a zero start returns only the latest
+// page of ohlcvLimit candles; otherwise pages are fetched backwards with
+// before_timestamp and stitched together until start is reached.
+func GetCoinGeckoOHLCVS(ctx context.Context, series types.PoolSeries, timeframe string, aggregate int, start int64, end int64) ([]types.Candle, error) {
+	return getCoinGeckoOHLCVS(ctx, series, timeframe, aggregate, start, end, "usd")
+}
+
+// GetCoinGeckoTokenOHLCVS is GetCoinGeckoOHLCVS with prices in the pool's
+// quote token instead of USD, such as SOL for a token/SOL pool.
+func GetCoinGeckoTokenOHLCVS(ctx context.Context, series types.PoolSeries, timeframe string, aggregate int, start int64, end int64) ([]types.Candle, error) {
+	return getCoinGeckoOHLCVS(ctx, series, timeframe, aggregate, start, end, "token")
+}
 
+// errNoCandles keeps an empty series out of the cache, since GeckoTerminal
+// may not have indexed a new pool yet.
+var errNoCandles = errors.New("no candles")
+
+// getCoinGeckoOHLCVS pages candles in currency, "usd" or "token".
+func getCoinGeckoOHLCVS(ctx context.Context, series types.PoolSeries, timeframe string, aggregate int, start int64, end int64, currency string) ([]types.Candle, error) {
+	if series.Token == "" {
+		series.Token = "base"
+	}
+	key := fmt.Sprintf("%s/%s/%s/%d/%d/%d/%s", series.Pool, series.Token, timeframe, aggregate, start, end, currency)
+	candles, err := cache.Fetch(ctx, responseCache, "ohlcv", key, ohlcvCacheTTL, func(ctx context.Context) ([]types.Candle, error) {
+		candles, err := fetchCoinGeckoOHLCVS(ctx, series, timeframe, aggregate, start, end, currency)
+		if err == nil && len(candles) == 0 {
+			return nil, errNoCandles
+		}
+		return candles, err
+	})
+	if errors.Is(err, errNoCandles) {
+		return nil, nil
+	}
+	return candles, err
+}
+
+func fetchCoinGeckoOHLCVS(ctx context.Context, series types.PoolSeries, timeframe string, aggregate int, start int64, end int64, currency string) ([]types.Candle, error) {
+	if aggregate <= 0 {
+		aggregate = 1
+	}
+	var (
+		candles []types.Candle
+		seen    = make(map[int64]bool)
+		before  = end
+	)
+	for page := 0; page < ohlcvMaxPages; page++ {
+		request_url := fmt.Sprintf("https://api.geckoterminal.com/api/v2/networks/solana/pools/%s/ohlcv/%s?aggregate=%d&limit=%d&currency=%s&token=%s", series.Pool, timeframe, aggregate, ohlcvLimit, currency, series.Token)
+		if before > 0 {
+			request_url += fmt.Sprintf("&before_timestamp=%d", before)
+		}
+		list, err := getOHLCVPage(ctx, request_url)
+		if err != nil {
+			return candles, err
+		}
+
+		oldest := before
+		for _, candle := range list {
+			if oldest == 0 || candle.Timestamp < oldest {
+				oldest = candle.Timestamp
+			}
+			if seen[candle.Timestamp] || candle.Timestamp < start || (end > 0 && candle.Timestamp > end) {
+				continue
+			}
+			seen[candle.Timestamp] = true
+			candles = append(candles, candle)
+		}
+
+		if start == 0 || len(list) < ohlcvLimit || oldest <= start || oldest == before {
+			break
+		}
+		before = oldest
+	}
+	sort.Slice(candles, func(a, b int) bool { return candles[a].Timestamp > candles[b].Timestamp })
+	return candles, nil
+}
+
+// getOHLCVPage fetches one page of candles within GeckoTerminal's rate limit.
+func getOHLCVPage(ctx context.Context, request_url string) ([]types.Candle, error) {
+	if err := geckoTerminalLimiter.Wait(ctx, 1); err != nil {
 		return nil, err
 	}
 	var response types.CoinGeckoOHLCVSResponse
-	err = json.Unmarshal([]byte(body), &response)
-	if err != nil {
-		log.Error("Error occured", "Stack", err)
-		return nil, err
+	if err := getJSON(ctx, request_url, nil, &response); err != nil {
+		return nil, fmt.Errorf("ohlcv: %w", err)
 	}
 	return response.Data.Attributes.OHLCVList, nil
 }
 
 // GetSolPrice retrieves the current USD price for SOL from CoinGecko.
 func GetSolPrice() (float64, error) {
+	return cache.Fetch(context.Background(), responseCache, "solPrice", "usd", solPriceCacheTTL, func(context.Context) (float64, error) {
+		return getSolPrice()
+	})
+}
+
+func getSolPrice() (float64, error) {
 	url := "https://api.coingecko.com/api/v3/simple/price?ids=solana&vs_currencies=usd"
 	resp, err := http.Get(url)
 	if err != nil {
@@ -99,3 +319,131 @@ func GetSolPrice() (float64, error) {
 
 	return price, nil
 }
+
+// GeckoTerminalProvider prices tokens with GeckoTerminal's simple token price
+// endpoint and serves history from the token's pool.
+type GeckoTerminalProvider struct{}
+
+func (GeckoTerminalProvider) Name() string {
+	return "geckoterminal"
+}
+
+func (p GeckoTerminalProvider) Price(ctx context.Context, mint string) (float64, error) {
+	return batchPrice(ctx, p, mint)
+}
+
+func (p GeckoTerminalProvider) Prices(ctx context.Context, mints []string) (map[string]float64, error) {
+	prices, err := GetCoinGeckoTokenPrices(ctx, mints)
+	if len(prices.Missing) > 0 {
+		// Later providers may still price them; what none prices ends up
+		// in MyWallet.UnpricedTokens.
+		log.Info("Tokens without a price", "provider", p.Name(), "missing", prices.Missing)
+	}
+	return prices.Prices, err
+}
+
+func (GeckoTerminalProvider) History(ctx context.Context, mint string, opts HistoryOptions) ([]types.Candle, error) {
+	pool, err := GetTokenPools(ctx, mint)
+	if err != nil {
+		return nil, err
+	}
+	return GetCoinGeckoOHLCVS(ctx, pool, opts.Timeframe, opts.Aggregate, opts.From, opts.To)
+}
+
+// CoinGeckoProvider prices tokens by contract address with the CoinGecko API.
+// APIKey is optional and sent as a demo API key.
+type CoinGeckoProvider struct {
+	APIKey string
+}
+
+func (CoinGeckoProvider) Name() string {
+	return "coingecko"
+}
+
+func (p CoinGeckoProvider) headers() map[string]string {
+	if p.APIKey == "" {
+		return nil
+	}
+	return map[string]string{"x-cg-demo-api-key": p.APIKey}
+}
+
+func (p CoinGeckoProvider) Price(ctx context.Context, mint string) (float64, error) {
+	return batchPrice(ctx, p, mint)
+}
+
+func (p CoinGeckoProvider) Prices(ctx context.Context, mints []string) (map[string]float64, error) {
+	prices := make(map[string]float64, len(mints))
+	var contracts []string
+	for _, mint := range mints {
+		if mint == solana.NativeMint {
+			// Wrapped SOL isn't listed by contract address.
+			if price, err := GetSolPrice(); err == nil {
+				prices[mint] = price
+			}
+			continue
+		}
+		contracts = append(contracts, mint)
+	}
+	if len(contracts) == 0 {
+		return prices, nil
+	}
+
+	request_url := fmt.Sprintf("https://api.coingecko.com/api/v3/simple/token_price/solana?contract_addresses=%s&vs_currencies=usd",
+		strings.Join(contracts, ","))
+	var response map[string]map[string]float64
+	if err := getJSON(ctx, request_url, p.headers(), &response); err != nil {
+		return prices, err
+	}
+	// CoinGecko may change the case of the addresses in its response.
+	for _, mint := range contracts {
+		for address, price := range response {
+			if strings.EqualFold(address, mint) {
+				prices[mint] = price["usd"]
+			}
+		}
+	}
+	return prices, nil
+}
+
+// History builds candles from CoinGecko's market chart, which only has one
+// price per point, so open, high, low and close are equal. CoinGecko picks
+// the granularity from the range: 5 minutes up to a day, hourly up to 90
+// days, daily beyond.
+func (p CoinGeckoProvider) History(ctx context.Context, mint string, opts HistoryOptions) ([]types.Candle, error) {
+	to := opts.To
+	if to == 0 {
+		to = time.Now().Unix()
+	}
+	from := opts.From
+	if from == 0 {
+		from = to - 90*24*60*60
+	}
+	request_url := fmt.Sprintf("https://api.coingecko.com/api/v3/coins/solana/contract/%s/market_chart/range?vs_currency=usd&from=%d&to=%d",
+		mint, from, to)
+	var response struct {
+		Prices       [][]float64 `json:"prices"`
+		TotalVolumes [][]float64 `json:"total_volumes"`
+	}
+	if err := getJSON(ctx, request_url, p.headers(), &response); err != nil {
+		return nil, err
+	}
+	candles := make([]types.Candle, 0, len(response.Prices))
+	for i := len(response.Prices) - 1; i >= 0; i-- {
+		point := response.Prices[i]
+		if len(point) < 2 {
+			continue
+		}
+		candle := types.Candle{
+			Timestamp: int64(point[0] / 1000),
+			Open:      point[1],
+			High:      point[1],
+			Low:       point[1],
+			Close:     point[1],
+		}
+		if i < len(response.TotalVolumes) && len(response.TotalVolumes[i]) >= 2 {
+			candle.Volume = response.TotalVolumes[i][1]
+		}
+		candles = append(candles, candle)
+	}
+	return candles, nil
+}
@@ -0,0 +1,102 @@
+package requests
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const solanaDevnetRPC = "https://api.devnet.solana.com"
+
+// EndpointConfig describes a single RPC provider in the pool.
+type EndpointConfig struct {
+	Name string `json:"name"`
+	// URL may reference environment variables, e.g.
+	// "https://mainnet.helius-rpc.com/?api-key=${HELIUS_API_KEY}", so API keys
+	// don't have to live in the config file.
+	URL string `json:"url"`
+	// Headers are sent with every request, for providers that expect the API key in a header.
+	Headers map[string]string `json:"headers"`
+	// Priority orders healthy endpoints; lower values are tried first.
+	Priority int `json:"priority"`
+}
+
+// PoolConfig configures the RPC endpoint pool.
+type PoolConfig struct {
+	Endpoints []EndpointConfig `json:"endpoints"`
+	// MaxSlotLag is how many slots an endpoint may fall behind the highest
+	// observed slot before it is considered unhealthy.
+	MaxSlotLag uint64 `json:"maxSlotLag"`
+	// MaxErrorRate is the recent error rate (0-1) above which an endpoint is considered unhealthy.
+	MaxErrorRate float64 `json:"maxErrorRate"`
+	// HealthCheckIntervalSeconds is how often every endpoint is probed with getSlot.
+	HealthCheckIntervalSeconds int `json:"healthCheckIntervalSeconds"`
+	// RequestsPerSecond and Burst configure the rate limiter shared by all RPC calls.
+	RequestsPerSecond float64 `json:"requestsPerSecond"`
+	Burst             int     `json:"burst"`
+}
+
+// HealthCheckInterval returns the configured probe interval.
+func (c PoolConfig) HealthCheckInterval() time.Duration {
+	return time.Duration(c.HealthCheckIntervalSeconds) * time.Second
+}
+
+// LoadPoolConfig reads the pool configuration from the JSON file at path (if
+// path is not empty) and applies environment overrides:
+//
+//   - SOLANA_RPC_URLS: comma separated endpoint URLs, appended after the file's endpoints.
+//   - SOLANA_NETWORK: "mainnet" or "devnet", used when no endpoint is configured at all.
+func LoadPoolConfig(path string) (PoolConfig, error) {
+	config := PoolConfig{
+		MaxSlotLag:                 50,
+		MaxErrorRate:               0.5,
+		HealthCheckIntervalSeconds: 30,
+		RequestsPerSecond:          10,
+		Burst:                      100,
+	}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return config, fmt.Errorf("failed to read RPC config: %w", err)
+		}
+		if err := json.Unmarshal(data, &config); err != nil {
+			return config, fmt.Errorf("failed to parse RPC config: %w", err)
+		}
+	}
+
+	if urls := os.Getenv("SOLANA_RPC_URLS"); urls != "" {
+		for i, url := range strings.Split(urls, ",") {
+			url = strings.TrimSpace(url)
+			if url == "" {
+				continue
+			}
+			config.Endpoints = append(config.Endpoints, EndpointConfig{
+				Name:     fmt.Sprintf("env-%d", i),
+				URL:      url,
+				Priority: len(config.Endpoints),
+			})
+		}
+	}
+
+	if len(config.Endpoints) == 0 {
+		switch os.Getenv("SOLANA_NETWORK") {
+		case "devnet":
+			config.Endpoints = []EndpointConfig{{Name: "devnet", URL: solanaDevnetRPC}}
+		default:
+			config.Endpoints = []EndpointConfig{{Name: "mainnet", URL: solanaRPC}}
+		}
+	}
+
+	for i := range config.Endpoints {
+		config.Endpoints[i].URL = os.ExpandEnv(config.Endpoints[i].URL)
+		for key, value := range config.Endpoints[i].Headers {
+			config.Endpoints[i].Headers[key] = os.ExpandEnv(value)
+		}
+		if config.Endpoints[i].Name == "" {
+			config.Endpoints[i].Name = fmt.Sprintf("endpoint-%d", i)
+		}
+	}
+	return config, nil
+}
@@ -0,0 +1,95 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrUnknownCurrency is returned for currencies without an exchange rate.
+var ErrUnknownCurrency = errors.New("unknown currency")
+
+// fxCacheTTL is how long exchange rates are reused before being refetched.
+const fxCacheTTL = 10 * time.Minute
+
+// exchangeRates caches CoinGecko's exchange rates, in units per USD.
+var exchangeRates struct {
+	mu      sync.Mutex
+	rates   map[string]float64
+	fetched time.Time
+}
+
+// ExchangeRate returns how many units of currency one US dollar buys.
+// currency is a code such as "usd", "eur", "jpy", "btc" or "sol", in any case.
+// Fiat and BTC rates come from CoinGecko and are cached for fxCacheTTL. The
+// SOL rate is the inverse of solPrice, the USD price the caller values SOL
+// at, so that SOL converts to itself; a zero solPrice uses GetSolPrice.
+func ExchangeRate(ctx context.Context, currency string, solPrice float64) (float64, error) {
+	currency = strings.ToLower(currency)
+	switch currency {
+	case "", "usd":
+		return 1, nil
+	case "sol":
+		price := solPrice
+		if price == 0 {
+			var err error
+			if price, err = GetSolPrice(); err != nil {
+				return 0, err
+			}
+		}
+		if price <= 0 {
+			return 0, fmt.Errorf("invalid SOL price %v", price)
+		}
+		return 1 / price, nil
+	}
+
+	rates, err := getExchangeRates(ctx)
+	if err != nil {
+		return 0, err
+	}
+	rate, ok := rates[currency]
+	if !ok {
+		return 0, fmt.Errorf("%w %q", ErrUnknownCurrency, currency)
+	}
+	return rate, nil
+}
+
+// getExchangeRates returns the cached rates, refreshing them when stale.
+func getExchangeRates(ctx context.Context) (map[string]float64, error) {
+	exchangeRates.mu.Lock()
+	defer exchangeRates.mu.Unlock()
+	if exchangeRates.rates != nil && time.Since(exchangeRates.fetched) < fxCacheTTL {
+		return exchangeRates.rates, nil
+	}
+
+	// CoinGecko quotes every currency against BTC.
+	var response struct {
+		Rates map[string]struct {
+			Value float64 `json:"value"`
+		} `json:"rates"`
+	}
+	err := getJSON(ctx, "https://api.coingecko.com/api/v3/exchange_rates",
+		CoinGeckoProvider{APIKey: os.Getenv("COINGECKO_API_KEY")}.headers(), &response)
+	if err != nil {
+		if exchangeRates.rates != nil {
+			// Stale rates beat no rates.
+			return exchangeRates.rates, nil
+		}
+		return nil, fmt.Errorf("exchange rates: %w", err)
+	}
+	usd := response.Rates["usd"].Value
+	if usd <= 0 {
+		return nil, fmt.Errorf("exchange rates: no USD rate")
+	}
+	rates := make(map[string]float64, len(response.Rates))
+	for currency, rate := range response.Rates {
+		rates[currency] = rate.Value / usd
+	}
+	exchangeRates.rates = rates
+	exchangeRates.fetched = time.Now()
+	return rates, nil
+}
@@ -0,0 +1,46 @@
+package requests
+
+import (
+	"context"
+	"fmt"
+	"sol_test/types"
+	"strings"
+)
+
+// jupiterMaxIDs is the maximum number of mints per Jupiter price request.
+const jupiterMaxIDs = 50
+
+// JupiterProvider prices tokens with the Jupiter Price API, which derives
+// prices from routable liquidity and therefore knows most traded tokens.
+type JupiterProvider struct{}
+
+func (JupiterProvider) Name() string {
+	return "jupiter"
+}
+
+func (p JupiterProvider) Price(ctx context.Context, mint string) (float64, error) {
+	return batchPrice(ctx, p, mint)
+}
+
+func (JupiterProvider) Prices(ctx context.Context, mints []string) (map[string]float64, error) {
+	prices := make(map[string]float64, len(mints))
+	for start := 0; start < len(mints); start += jupiterMaxIDs {
+		chunk := mints[start:min(start+jupiterMaxIDs, len(mints))]
+		request_url := fmt.Sprintf("https://lite-api.jup.ag/price/v3?ids=%s", strings.Join(chunk, ","))
+		var response map[string]struct {
+			USDPrice float64 `json:"usdPrice"`
+		}
+		if err := getJSON(ctx, request_url, nil, &response); err != nil {
+			return prices, err
+		}
+		for mint, price := range response {
+			prices[mint] = price.USDPrice
+		}
+	}
+	return prices, nil
+}
+
+// History is not offered by the Jupiter Price API.
+func (p JupiterProvider) History(ctx context.Context, mint string, opts HistoryOptions) ([]types.Candle, error) {
+	return nil, fmt.Errorf("%s: history: %w", p.Name(), ErrUnsupported)
+}
@@ -0,0 +1,37 @@
+package requests
+
+import (
+	"context"
+	"encoding/binary"
+	"sol_test/solana"
+	"strings"
+	"testing"
+)
+
+func TestGetLSTRateChecksStakePoolOwner(t *testing.T) {
+	const jitoSOL = "J1toso1uCk3RLmjorhTtrVwY9HJ7X8V9yYac6Y7kGCPn"
+	mint, err := solana.DecodeBase58(jitoSOL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, stakePoolPoolTokenSupplyOffset+8)
+	data[0] = stakePoolAccountType
+	copy(data[stakePoolPoolMintOffset:], mint)
+	binary.LittleEndian.PutUint64(data[stakePoolTotalLamportsOffset:], 1_150_000_000)
+	binary.LittleEndian.PutUint64(data[stakePoolPoolTokenSupplyOffset:], 1_000_000_000)
+
+	// The same bytes in an account of another program aren't trusted.
+	UseRPC(accountStandIn{data: data, owner: solana.SystemProgramID})
+	if _, err := GetLSTRate(context.Background(), jitoSOL); err == nil || !strings.Contains(err.Error(), "owned by") {
+		t.Fatalf("rate of a pool owned by another program: %v", err)
+	}
+
+	UseRPC(accountStandIn{data: data, owner: StakePoolProgramID})
+	rate, err := GetLSTRate(context.Background(), jitoSOL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate != 1.15 {
+		t.Errorf("rate = %v, want 1.15", rate)
+	}
+}
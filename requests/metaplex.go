@@ -0,0 +1,90 @@
+package requests
+
+import (
+	"context"
+	"fmt"
+	"sol_test/solana"
+	"sol_test/types"
+	"strings"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// ResolveOffChainMetadata controls whether GetMetaplexMetadata fetches the
+// JSON at the metadata URI for the description and image.
+var ResolveOffChainMetadata = true
+
+// offChainMetadataTimeout bounds the request for the JSON at a metadata URI,
+// which is often served by slow IPFS or Arweave gateways.
+const offChainMetadataTimeout = 10 * time.Second
+
+// GetMetaplexMetadata reads the mint's Metaplex metadata account over RPC and
+// returns it in the shape of a DAS getAsset response, so it can stand in for
+// GetTokenMetadata on RPCs without the DAS API.
+func GetMetaplexMetadata(ctx context.Context, mint string) (types.GetTokenMetaDataResponse, error) {
+	var response types.GetTokenMetaDataResponse
+	address, err := solana.MetadataAddress(mint)
+	if err != nil {
+		return response, err
+	}
+	data, owner, err := GetAccountData(ctx, address)
+	if err != nil {
+		return response, err
+	}
+	if owner != solana.TokenMetadataProgramID {
+		return response, fmt.Errorf("metadata account %s is owned by %s", address, owner)
+	}
+	metadata, err := solana.DecodeMetadata(data)
+	if err != nil {
+		return response, fmt.Errorf("metadata account %s: %w", address, err)
+	}
+
+	result := &response.Result
+	result.ID = mint
+	result.Content.JSONURI = metadata.URI
+	result.Content.Metadata.Name = metadata.Name
+	result.Content.Metadata.Symbol = metadata.Symbol
+	result.Content.Metadata.TokenStandard = metadata.TokenStandard
+	if metadata.Collection != "" {
+		verified := true
+		result.Grouping = []types.Grouping{{GroupKey: "collection", GroupValue: metadata.Collection, Verified: &verified}}
+	}
+	result.Authorities = []types.Authority{{Address: metadata.UpdateAuthority, Scopes: []string{"full"}}}
+	result.Royalty.BasisPoints = metadata.SellerFeeBasisPoints
+	result.Royalty.Percent = float64(metadata.SellerFeeBasisPoints) / 10000
+	for _, creator := range metadata.Creators {
+		result.Creators = append(result.Creators, map[string]interface{}{
+			"address":  creator.Address,
+			"share":    creator.Share,
+			"verified": creator.Verified,
+		})
+	}
+
+	if ResolveOffChainMetadata && strings.HasPrefix(metadata.URI, "http") {
+		if err := resolveOffChainMetadata(ctx, metadata.URI, &result.Content); err != nil {
+			log.Warn("Off-chain metadata unavailable", "mint", mint, "uri", metadata.URI, "error", err)
+		}
+	}
+	return response, nil
+}
+
+// resolveOffChainMetadata fills the description and image from the JSON
+// document at uri.
+func resolveOffChainMetadata(ctx context.Context, uri string, content *types.Content) error {
+	ctx, cancel := context.WithTimeout(ctx, offChainMetadataTimeout)
+	defer cancel()
+	var document struct {
+		Description string `json:"description"`
+		Image       string `json:"image"`
+	}
+	if err := getJSON(ctx, uri, nil, &document); err != nil {
+		return err
+	}
+	content.Metadata.Description = document.Description
+	content.Links.Image = document.Image
+	if document.Image != "" {
+		content.Files = append(content.Files, types.File{URI: document.Image})
+	}
+	return nil
+}
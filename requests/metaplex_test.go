@@ -0,0 +1,64 @@
+package requests
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sol_test/solana"
+	"strings"
+	"testing"
+)
+
+// accountStandIn answers getAccountInfo with data owned by owner.
+type accountStandIn struct {
+	data  []byte
+	owner string
+}
+
+func (s accountStandIn) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	value, _ := json.Marshal(map[string]interface{}{
+		"context": map[string]interface{}{"slot": 1},
+		"value": map[string]interface{}{
+			"data":  []string{base64.StdEncoding.EncodeToString(s.data), "base64"},
+			"owner": s.owner,
+		},
+	})
+	return json.Unmarshal(value, result)
+}
+
+func (s accountStandIn) Batch(ctx context.Context, calls []BatchCall) error {
+	for i := range calls {
+		calls[i].Err = s.Call(ctx, calls[i].Method, calls[i].Params, calls[i].Result)
+	}
+	return nil
+}
+
+func TestGetMetaplexMetadataGroupsVerifiedCollection(t *testing.T) {
+	defer func(resolve bool) { ResolveOffChainMetadata = resolve }(ResolveOffChainMetadata)
+	ResolveOffChainMetadata = false
+	encoded, err := os.ReadFile(filepath.Join("..", "solana", "testdata", "metadata_nft.b64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	UseRPC(accountStandIn{data: data, owner: solana.TokenMetadataProgramID})
+
+	response, err := GetMetaplexMetadata(context.Background(), "3SnGEvwDf8zfdasKa185pLYiR9z67oy25oKLrd99zoMn")
+	if err != nil {
+		t.Fatal(err)
+	}
+	asset := response.Result
+	if asset.Content.Metadata.TokenStandard != "ProgrammableNonFungible" {
+		t.Errorf("token standard %q", asset.Content.Metadata.TokenStandard)
+	}
+	if len(asset.Grouping) != 1 || asset.Grouping[0].GroupKey != "collection" ||
+		asset.Grouping[0].GroupValue != "HHunKySupezBgYLixnB5mPdu4Xw4MayciAp7bgReyUNL" ||
+		asset.Grouping[0].Verified == nil || !*asset.Grouping[0].Verified {
+		t.Errorf("grouping = %+v, want the verified collection", asset.Grouping)
+	}
+}
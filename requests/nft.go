@@ -0,0 +1,66 @@
+package requests
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sol_test/cache"
+	"sol_test/solana"
+	"sol_test/types"
+	"time"
+)
+
+// assetsPageSize is the maximum number of assets getAssetsByOwner returns per page.
+const assetsPageSize = 1000
+
+// floorPriceCacheTTL is how long collection floor prices are cached.
+const floorPriceCacheTTL = 10 * time.Minute
+
+// GetAssetsByOwner pages through the DAS getAssetsByOwner method and returns
+// every asset the wallet owns, including compressed NFTs, which have no
+// token account.
+func GetAssetsByOwner(ctx context.Context, owner string) ([]types.TokenMetaData, error) {
+	var assets []types.TokenMetaData
+	for page := 1; ; page++ {
+		var result types.GetAssetsByOwnerResult
+		err := rpc.Call(ctx, "getAssetsByOwner", []interface{}{
+			map[string]interface{}{
+				"ownerAddress": owner,
+				"page":         page,
+				"limit":        assetsPageSize,
+			},
+		}, &result)
+		if err != nil {
+			return assets, err
+		}
+		assets = append(assets, result.Items...)
+		if len(result.Items) < assetsPageSize {
+			return assets, nil
+		}
+	}
+}
+
+// GetFloorPrice returns the floor price in SOL of the collection the NFT
+// belongs to, as listed on Magic Eden.
+func GetFloorPrice(ctx context.Context, mint string) (float64, error) {
+	return cache.Fetch(ctx, responseCache, "floor", mint, floorPriceCacheTTL, func(ctx context.Context) (float64, error) {
+		var token struct {
+			Collection string `json:"collection"`
+		}
+		err := getJSON(ctx, fmt.Sprintf("https://api-mainnet.magiceden.dev/v2/tokens/%s", url.PathEscape(mint)), nil, &token)
+		if err != nil {
+			return 0, fmt.Errorf("magic eden token %s: %w", mint, err)
+		}
+		if token.Collection == "" {
+			return 0, fmt.Errorf("%w for %s: not in a listed collection", ErrNoPrice, mint)
+		}
+		var stats struct {
+			FloorPrice float64 `json:"floorPrice"` // In lamports.
+		}
+		err = getJSON(ctx, fmt.Sprintf("https://api-mainnet.magiceden.dev/v2/collections/%s/stats", url.PathEscape(token.Collection)), nil, &stats)
+		if err != nil {
+			return 0, fmt.Errorf("magic eden collection %s: %w", token.Collection, err)
+		}
+		return stats.FloorPrice / solana.LamportsPerSol, nil
+	})
+}
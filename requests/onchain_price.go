@@ -0,0 +1,477 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sol_test/cache"
+	"sol_test/solana"
+	"sol_test/types"
+
+	"github.com/charmbracelet/log"
+)
+
+// Programs whose pool state GetPoolPrice can read.
+const (
+	RaydiumAMMProgramID    = "675kPX9MHTjS2zt1qfr1NYHuzeLXfQM9H24wFSUt1Mp8"
+	OrcaWhirlpoolProgramID = "whirLbMiicVdio4qvUfM5KAg6Ct8VwpYzGff3uctyCc"
+	PumpFunProgramID       = "6EF8rrecthR5Dkzon8Nwu78hRvfCKubJ14M5uBEwF6P"
+)
+
+// Raydium AMM v4 pool account layout.
+const (
+	raydiumBaseDecimalOffset      = 32
+	raydiumQuoteDecimalOffset     = 40
+	raydiumBaseNeedTakePnlOffset  = 192
+	raydiumQuoteNeedTakePnlOffset = 200
+	raydiumBaseVaultOffset        = 336
+	raydiumQuoteVaultOffset       = 368
+	raydiumBaseMintOffset         = 400
+	raydiumQuoteMintOffset        = 432
+)
+
+// Orca Whirlpool account layout.
+const (
+	whirlpoolLiquidityOffset  = 49
+	whirlpoolSqrtPriceOffset  = 65
+	whirlpoolTokenMintAOffset = 101
+	whirlpoolTokenMintBOffset = 181
+)
+
+// Pump.fun bonding curve account layout. Pump.fun mints always have 6 decimals.
+const (
+	pumpFunVirtualTokenReservesOffset = 8
+	pumpFunVirtualSolReservesOffset   = 16
+	pumpFunCompleteOffset             = 48
+	pumpFunTokenDecimals              = 6
+)
+
+// Account sizes of the pools GetPoolPrice reads, which narrow the
+// getProgramAccounts scans of DiscoverPools.
+const (
+	raydiumAMMAccountSize = 752
+	whirlpoolAccountSize  = 653
+)
+
+// maxDiscoveredPools bounds how many discovered pools are read to find the
+// deepest one.
+const maxDiscoveredPools = 10
+
+// onChainDexes are the GeckoTerminal dex ids of pools GetPoolPrice may read.
+var onChainDexes = map[string]bool{"raydium": true, "orca": true}
+
+// tokenAccountAmountOffset is where an SPL token account stores its amount.
+const tokenAccountAmountOffset = 64
+
+// mintDecimalsOffset is where an SPL mint account stores its decimals.
+const mintDecimalsOffset = 44
+
+// errBondingCurveComplete is returned for Pump.fun tokens that have migrated
+// to an AMM and must be priced from their pool instead.
+var errBondingCurveComplete = errors.New("bonding curve complete")
+
+// PoolPrice is a spot price read from a pool account.
+type PoolPrice struct {
+	Pool      string
+	Program   string
+	QuoteMint string
+	// Price is the price of the token in QuoteMint units.
+	Price float64
+	// QuoteReserve is the pool's depth on the quote side in QuoteMint units,
+	// the virtual reserve at the current price for a Whirlpool.
+	QuoteReserve float64
+}
+
+// OnChainProvider prices tokens from the reserves of their pool, read
+// directly over RPC, and converts the quote side to USD. Tokens still on a
+// Pump.fun bonding curve are priced from the curve, so they get a price
+// before any indexer has seen their first trade. Other tokens are priced from
+// the best ranked GeckoTerminal pool it can read, or else from the deepest
+// pool found on chain.
+type OnChainProvider struct{}
+
+func (OnChainProvider) Name() string {
+	return "onchain"
+}
+
+func (p OnChainProvider) Price(ctx context.Context, mint string) (float64, error) {
+	if solana.IsStablecoin(mint) {
+		return 1, nil
+	}
+	poolPrice, err := PumpFunPrice(ctx, mint)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, ctx.Err()
+		}
+		poolPrice, err = rankedPoolPrice(ctx, mint)
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ctx.Err()
+			}
+			poolPrice, err = discoveredPoolPrice(ctx, mint)
+			if err != nil {
+				return 0, err
+			}
+		}
+	}
+	quoteUSD, err := quoteMintUSD(poolPrice.QuoteMint)
+	if err != nil {
+		return 0, err
+	}
+	return poolPrice.Price * quoteUSD, nil
+}
+
+// rankedPoolPrice prices mint from the first of its GeckoTerminal pools, in
+// rank order, that is a Raydium AMM v4 pool or a Whirlpool against SOL or a
+// stablecoin.
+func rankedPoolPrice(ctx context.Context, mint string) (PoolPrice, error) {
+	pools, err := GetRankedPools(ctx, mint)
+	if err != nil {
+		return PoolPrice{}, err
+	}
+	var errs []error
+	for _, pool := range pools {
+		counter := pool.QuoteMint
+		if counter == mint {
+			counter = pool.BaseMint
+		}
+		if !onChainDexes[pool.Dex] || !isUSDQuote(counter) {
+			continue
+		}
+		// The dex id doesn't tell Raydium's AMM v4 from its other programs,
+		// which GetPoolPrice rejects.
+		poolPrice, err := GetPoolPrice(ctx, pool.Address, mint)
+		if err == nil {
+			return poolPrice, nil
+		}
+		if ctx.Err() != nil {
+			return PoolPrice{}, ctx.Err()
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return PoolPrice{}, fmt.Errorf("no readable pool for %s", mint)
+	}
+	return PoolPrice{}, errors.Join(errs...)
+}
+
+// discoveredPoolPrice prices mint from the deepest, in USD, of the pools
+// DiscoverPools finds against SOL or a stablecoin.
+func discoveredPoolPrice(ctx context.Context, mint string) (PoolPrice, error) {
+	pools, err := DiscoverPools(ctx, mint)
+	if err != nil {
+		return PoolPrice{}, err
+	}
+	var (
+		best      PoolPrice
+		bestDepth float64
+		errs      []error
+	)
+	for _, pool := range pools[:min(len(pools), maxDiscoveredPools)] {
+		poolPrice, err := GetPoolPrice(ctx, pool, mint)
+		if err != nil {
+			if ctx.Err() != nil {
+				return PoolPrice{}, ctx.Err()
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if !isUSDQuote(poolPrice.QuoteMint) {
+			continue
+		}
+		quoteUSD, err := quoteMintUSD(poolPrice.QuoteMint)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if depth := poolPrice.QuoteReserve * quoteUSD; depth > bestDepth {
+			best, bestDepth = poolPrice, depth
+		}
+	}
+	if bestDepth == 0 {
+		errs = append(errs, fmt.Errorf("token %s: %w", mint, ErrNoMarket))
+		return PoolPrice{}, errors.Join(errs...)
+	}
+	return best, nil
+}
+
+// DiscoverPools returns the Raydium AMM v4 pools and Whirlpools that trade
+// mint on either side, found by scanning the programs' accounts for the mint.
+// It doesn't depend on an indexer, so it finds pools GeckoTerminal doesn't
+// list yet. Results are cached, including mints without pools.
+func DiscoverPools(ctx context.Context, mint string) ([]string, error) {
+	return cache.Fetch(ctx, responseCache, "onchainPools", mint, poolsCacheTTL, func(ctx context.Context) ([]string, error) {
+		return discoverPools(ctx, mint)
+	})
+}
+
+func discoverPools(ctx context.Context, mint string) ([]string, error) {
+	scans := []struct {
+		program string
+		size    int
+		offset  int
+	}{
+		{RaydiumAMMProgramID, raydiumAMMAccountSize, raydiumBaseMintOffset},
+		{RaydiumAMMProgramID, raydiumAMMAccountSize, raydiumQuoteMintOffset},
+		{OrcaWhirlpoolProgramID, whirlpoolAccountSize, whirlpoolTokenMintAOffset},
+		{OrcaWhirlpoolProgramID, whirlpoolAccountSize, whirlpoolTokenMintBOffset},
+	}
+	results := make([][]struct {
+		Pubkey string `json:"pubkey"`
+	}, len(scans))
+	calls := make([]BatchCall, len(scans))
+	for i, scan := range scans {
+		calls[i] = BatchCall{
+			Method: "getProgramAccounts",
+			Params: []interface{}{
+				scan.program,
+				map[string]interface{}{
+					"encoding": "base64",
+					// Only the addresses are needed; GetPoolPrice reads the pools.
+					"dataSlice": map[string]interface{}{"offset": 0, "length": 0},
+					"filters": []interface{}{
+						map[string]interface{}{"dataSize": scan.size},
+						map[string]interface{}{
+							"memcmp": map[string]interface{}{"offset": scan.offset, "bytes": mint},
+						},
+					},
+				},
+			},
+			Result: &results[i],
+		}
+	}
+	if err := rpc.Batch(ctx, calls); err != nil {
+		return nil, err
+	}
+	var pools []string
+	for i, call := range calls {
+		// Many public RPCs refuse program scans. A refusal is cached along
+		// with the other scans' pools instead of being asked again.
+		var rpcErr *types.SolanaError
+		if errors.As(call.Err, &rpcErr) {
+			log.Warn("Pool discovery refused", "program", scans[i].program, "error", call.Err)
+			continue
+		}
+		if call.Err != nil {
+			return nil, fmt.Errorf("pools of %s: %w", scans[i].program, call.Err)
+		}
+		for _, account := range results[i] {
+			pools = append(pools, account.Pubkey)
+		}
+	}
+	return pools, nil
+}
+
+// isUSDQuote reports whether quoteMintUSD can price mint.
+func isUSDQuote(mint string) bool {
+	return mint == solana.NativeMint || solana.IsStablecoin(mint)
+}
+
+func (p OnChainProvider) Prices(ctx context.Context, mints []string) (map[string]float64, error) {
+	prices := make(map[string]float64, len(mints))
+	for _, mint := range mints {
+		price, err := p.Price(ctx, mint)
+		if err != nil {
+			if ctx.Err() != nil {
+				return prices, ctx.Err()
+			}
+			continue
+		}
+		prices[mint] = price
+	}
+	return prices, nil
+}
+
+// History is not available from a single account read.
+func (p OnChainProvider) History(ctx context.Context, mint string, opts HistoryOptions) ([]types.Candle, error) {
+	return nil, fmt.Errorf("%s: history: %w", p.Name(), ErrUnsupported)
+}
+
+// quoteMintUSD returns the USD price of a pool's quote mint.
+func quoteMintUSD(mint string) (float64, error) {
+	switch {
+	case solana.IsStablecoin(mint):
+		return 1, nil
+	case mint == solana.NativeMint:
+		return GetSolPrice()
+	}
+	return 0, fmt.Errorf("unsupported quote mint %s", mint)
+}
+
+// GetPoolPrice reads the pool account and returns the spot price of mint in
+// the pool's other token.
+func GetPoolPrice(ctx context.Context, pool, mint string) (PoolPrice, error) {
+	data, owner, err := GetAccountData(ctx, pool)
+	if err != nil {
+		return PoolPrice{}, err
+	}
+	switch owner {
+	case RaydiumAMMProgramID:
+		return raydiumAMMPrice(ctx, pool, data, mint)
+	case OrcaWhirlpoolProgramID:
+		return whirlpoolPrice(ctx, pool, data, mint)
+	}
+	return PoolPrice{}, fmt.Errorf("pool %s: unsupported program %s", pool, owner)
+}
+
+// raydiumAMMPrice prices mint from the pool's vault balances, less the
+// fees the pool hasn't taken out yet.
+func raydiumAMMPrice(ctx context.Context, pool string, data []byte, mint string) (PoolPrice, error) {
+	var fields [4]uint64
+	for i, offset := range []int{raydiumBaseDecimalOffset, raydiumQuoteDecimalOffset, raydiumBaseNeedTakePnlOffset, raydiumQuoteNeedTakePnlOffset} {
+		value, err := solana.ReadUint64(data, offset)
+		if err != nil {
+			return PoolPrice{}, fmt.Errorf("pool %s: %w", pool, err)
+		}
+		fields[i] = value
+	}
+	var keys [4]string
+	for i, offset := range []int{raydiumBaseVaultOffset, raydiumQuoteVaultOffset, raydiumBaseMintOffset, raydiumQuoteMintOffset} {
+		key, err := solana.ReadPubkey(data, offset)
+		if err != nil {
+			return PoolPrice{}, fmt.Errorf("pool %s: %w", pool, err)
+		}
+		keys[i] = key
+	}
+	baseDecimals, quoteDecimals, baseFees, quoteFees := fields[0], fields[1], fields[2], fields[3]
+	baseVault, quoteVault, baseMint, quoteMint := keys[0], keys[1], keys[2], keys[3]
+
+	amounts, err := tokenAccountAmounts(ctx, baseVault, quoteVault)
+	if err != nil {
+		return PoolPrice{}, fmt.Errorf("pool %s: %w", pool, err)
+	}
+	base := float64(amounts[0]-min(baseFees, amounts[0])) / math.Pow10(int(baseDecimals))
+	quote := float64(amounts[1]-min(quoteFees, amounts[1])) / math.Pow10(int(quoteDecimals))
+	if base == 0 || quote == 0 {
+		return PoolPrice{}, fmt.Errorf("pool %s has no liquidity", pool)
+	}
+
+	switch mint {
+	case baseMint:
+		return PoolPrice{Pool: pool, Program: RaydiumAMMProgramID, QuoteMint: quoteMint, Price: quote / base, QuoteReserve: quote}, nil
+	case quoteMint:
+		return PoolPrice{Pool: pool, Program: RaydiumAMMProgramID, QuoteMint: baseMint, Price: base / quote, QuoteReserve: base}, nil
+	}
+	return PoolPrice{}, fmt.Errorf("pool %s doesn't trade %s", pool, mint)
+}
+
+// whirlpoolPrice prices mint from the pool's sqrt price, a Q64.64 fixed point
+// number giving the price of token A in token B raw units. The virtual
+// reserves at that price are liquidity / sqrt price of A and liquidity *
+// sqrt price of B.
+func whirlpoolPrice(ctx context.Context, pool string, data []byte, mint string) (PoolPrice, error) {
+	sqrtPrice, err := solana.ReadUint128(data, whirlpoolSqrtPriceOffset)
+	if err != nil {
+		return PoolPrice{}, fmt.Errorf("pool %s: %w", pool, err)
+	}
+	liquidity, err := solana.ReadUint128(data, whirlpoolLiquidityOffset)
+	if err != nil {
+		return PoolPrice{}, fmt.Errorf("pool %s: %w", pool, err)
+	}
+	mintA, err := solana.ReadPubkey(data, whirlpoolTokenMintAOffset)
+	if err != nil {
+		return PoolPrice{}, fmt.Errorf("pool %s: %w", pool, err)
+	}
+	mintB, err := solana.ReadPubkey(data, whirlpoolTokenMintBOffset)
+	if err != nil {
+		return PoolPrice{}, fmt.Errorf("pool %s: %w", pool, err)
+	}
+	if sqrtPrice.Sign() == 0 {
+		return PoolPrice{}, fmt.Errorf("pool %s has no liquidity", pool)
+	}
+	decimals, err := mintDecimals(ctx, mintA, mintB)
+	if err != nil {
+		return PoolPrice{}, fmt.Errorf("pool %s: %w", pool, err)
+	}
+
+	sqrt, _ := new(big.Float).Quo(new(big.Float).SetInt(sqrtPrice), new(big.Float).SetMantExp(big.NewFloat(1), 64)).Float64()
+	price := sqrt * sqrt * math.Pow10(decimals[0]-decimals[1])
+	l, _ := new(big.Float).SetInt(liquidity).Float64()
+
+	switch mint {
+	case mintA:
+		reserveB := l * sqrt / math.Pow10(decimals[1])
+		return PoolPrice{Pool: pool, Program: OrcaWhirlpoolProgramID, QuoteMint: mintB, Price: price, QuoteReserve: reserveB}, nil
+	case mintB:
+		reserveA := l / sqrt / math.Pow10(decimals[0])
+		return PoolPrice{Pool: pool, Program: OrcaWhirlpoolProgramID, QuoteMint: mintA, Price: 1 / price, QuoteReserve: reserveA}, nil
+	}
+	return PoolPrice{}, fmt.Errorf("pool %s doesn't trade %s", pool, mint)
+}
+
+// PumpFunPrice prices mint in SOL from the virtual reserves of its Pump.fun
+// bonding curve. It fails with errBondingCurveComplete once the token has
+// migrated to an AMM.
+func PumpFunPrice(ctx context.Context, mint string) (PoolPrice, error) {
+	mintKey, err := solana.DecodeBase58(mint)
+	if err != nil {
+		return PoolPrice{}, err
+	}
+	curve, _, err := solana.FindProgramAddress([][]byte{[]byte("bonding-curve"), mintKey}, PumpFunProgramID)
+	if err != nil {
+		return PoolPrice{}, err
+	}
+	data, owner, err := GetAccountData(ctx, curve)
+	if err != nil {
+		return PoolPrice{}, err
+	}
+	if owner != PumpFunProgramID {
+		return PoolPrice{}, fmt.Errorf("bonding curve %s is owned by %s", curve, owner)
+	}
+	if len(data) <= pumpFunCompleteOffset {
+		return PoolPrice{}, fmt.Errorf("bonding curve %s: account too short (%d bytes)", curve, len(data))
+	}
+	if data[pumpFunCompleteOffset] != 0 {
+		return PoolPrice{}, fmt.Errorf("bonding curve %s: %w", curve, errBondingCurveComplete)
+	}
+	tokenReserves, err := solana.ReadUint64(data, pumpFunVirtualTokenReservesOffset)
+	if err != nil {
+		return PoolPrice{}, fmt.Errorf("bonding curve %s: %w", curve, err)
+	}
+	solReserves, err := solana.ReadUint64(data, pumpFunVirtualSolReservesOffset)
+	if err != nil {
+		return PoolPrice{}, fmt.Errorf("bonding curve %s: %w", curve, err)
+	}
+	if tokenReserves == 0 {
+		return PoolPrice{}, fmt.Errorf("bonding curve %s has no liquidity", curve)
+	}
+
+	tokens := float64(tokenReserves) / math.Pow10(pumpFunTokenDecimals)
+	sol := float64(solReserves) / solana.LamportsPerSol
+	return PoolPrice{Pool: curve, Program: PumpFunProgramID, QuoteMint: solana.NativeMint, Price: sol / tokens}, nil
+}
+
+// mintDecimals returns the decimals of mint accounts.
+func mintDecimals(ctx context.Context, mints ...string) ([]int, error) {
+	data, err := GetMultipleAccountsData(ctx, mints)
+	if err != nil {
+		return nil, err
+	}
+	decimals := make([]int, len(mints))
+	for i, account := range data {
+		if len(account) <= mintDecimalsOffset {
+			return nil, fmt.Errorf("mint %s: account too short (%d bytes)", mints[i], len(account))
+		}
+		decimals[i] = int(account[mintDecimalsOffset])
+	}
+	return decimals, nil
+}
+
+// tokenAccountAmounts returns the raw amounts held by token accounts.
+func tokenAccountAmounts(ctx context.Context, accounts ...string) ([]uint64, error) {
+	data, err := GetMultipleAccountsData(ctx, accounts)
+	if err != nil {
+		return nil, err
+	}
+	amounts := make([]uint64, len(accounts))
+	for i, account := range data {
+		amount, err := solana.ReadUint64(account, tokenAccountAmountOffset)
+		if err != nil {
+			return nil, fmt.Errorf("token account %s: %w", accounts[i], err)
+		}
+		amounts[i] = amount
+	}
+	return amounts, nil
+}
@@ -0,0 +1,309 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sol_test/types"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// Caller is implemented by anything that can perform a JSON-RPC call, such as
+// a single RPCClient or an RPCPool.
+type Caller interface {
+	Call(ctx context.Context, method string, params []interface{}, result interface{}) error
+	Batch(ctx context.Context, calls []BatchCall) error
+}
+
+// UseRPC replaces the Caller used by the package level request functions.
+// It is meant to be called once during startup, before any request is made.
+func UseRPC(c Caller) {
+	rpc = c
+}
+
+// errorRateWeight is the weight of the latest outcome in an endpoint's error rate.
+const errorRateWeight = 0.1
+
+// defaultCooldown is how long an endpoint is skipped after a failover error
+// when the node didn't send a Retry-After header.
+const defaultCooldown = 5 * time.Second
+
+// EndpointStatus is a snapshot of an endpoint's health.
+type EndpointStatus struct {
+	Name      string    `json:"name"`
+	Slot      uint64    `json:"slot"`
+	SlotLag   uint64    `json:"slotLag"`
+	ErrorRate float64   `json:"errorRate"`
+	Healthy   bool      `json:"healthy"`
+	Cooldown  time.Time `json:"cooldownUntil"`
+}
+
+type endpoint struct {
+	config EndpointConfig
+	client *RPCClient
+
+	mu            sync.Mutex
+	slot          uint64
+	errorRate     float64
+	cooldownUntil time.Time
+	// unsupported holds the methods the endpoint answered MethodNotFound to.
+	unsupported map[string]bool
+}
+
+// record updates the error rate with the outcome of a call to method. Errors
+// caused by the request itself don't count against the endpoint, and neither
+// do methods it doesn't implement, such as DAS methods on a plain node; those
+// are remembered so other endpoints are tried first.
+func (e *endpoint) record(method string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if isMethodNotFound(err) {
+		if e.unsupported == nil {
+			e.unsupported = make(map[string]bool)
+		}
+		e.unsupported[method] = true
+		return
+	}
+	failed := 0.0
+	if err != nil && shouldFailover(err) {
+		failed = 1
+	}
+	e.errorRate = e.errorRate*(1-errorRateWeight) + failed*errorRateWeight
+
+	var httpErr *HTTPError
+	if failed == 1 && errors.As(err, &httpErr) {
+		cooldown := httpErr.RetryAfter
+		if cooldown <= 0 {
+			cooldown = defaultCooldown
+		}
+		e.cooldownUntil = time.Now().Add(cooldown)
+	}
+}
+
+// RPCPool spreads calls over several RPC endpoints and fails over to the next
+// one when an endpoint is rate limited, erroring or lagging behind.
+type RPCPool struct {
+	endpoints     []*endpoint
+	maxSlotLag    uint64
+	maxErrorRate  float64
+	checkInterval time.Duration
+}
+
+// NewRPCPool creates a pool from config. Endpoints are considered healthy
+// until the first health check or failed call says otherwise.
+func NewRPCPool(config PoolConfig) (*RPCPool, error) {
+	if len(config.Endpoints) == 0 {
+		return nil, fmt.Errorf("no RPC endpoints configured")
+	}
+	pool := &RPCPool{
+		maxSlotLag:    config.MaxSlotLag,
+		maxErrorRate:  config.MaxErrorRate,
+		checkInterval: config.HealthCheckInterval(),
+	}
+	for _, endpointConfig := range config.Endpoints {
+		client := NewRPCClient(endpointConfig.URL)
+		client.headers = endpointConfig.Headers
+		pool.endpoints = append(pool.endpoints, &endpoint{config: endpointConfig, client: client})
+	}
+	return pool, nil
+}
+
+// Call tries the endpoints from healthiest to least healthy and returns the
+// first successful result. Errors that are specific to the request, such as
+// invalid params, are returned immediately without failing over.
+func (p *RPCPool) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	return p.do(ctx, method, func(client *RPCClient) error {
+		return client.Call(ctx, method, params, result)
+	})
+}
+
+// Batch sends the whole batch to the healthiest endpoint, failing over to the
+// next one when the batch as a whole fails. Per-item errors don't trigger a
+// failover; callers retry those items themselves.
+func (p *RPCPool) Batch(ctx context.Context, calls []BatchCall) error {
+	return p.do(ctx, "batch", func(client *RPCClient) error {
+		return client.Batch(ctx, calls)
+	})
+}
+
+// do runs call against the endpoints in health order, those known not to
+// implement method last, until one succeeds or fails with an error that
+// another endpoint wouldn't fix.
+func (p *RPCPool) do(ctx context.Context, method string, call func(*RPCClient) error) error {
+	endpoints := p.ordered()
+	sort.SliceStable(endpoints, func(a, b int) bool {
+		return !endpoints[a].unsupports(method) && endpoints[b].unsupports(method)
+	})
+	var lastErr error
+	for _, e := range endpoints {
+		err := call(e.client)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		e.record(method, err)
+		if err == nil {
+			return nil
+		}
+		if !shouldFailover(err) {
+			return err
+		}
+		log.Warn("RPC endpoint failed, failing over", "endpoint", e.config.Name, "method", method, "error", err)
+		lastErr = err
+	}
+	return lastErr
+}
+
+// Run probes every endpoint each health check interval until ctx is done.
+func (p *RPCPool) Run(ctx context.Context) {
+	if p.checkInterval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(p.checkInterval)
+	defer ticker.Stop()
+	for {
+		p.CheckHealth(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// CheckHealth queries getSlot on every endpoint concurrently and records the
+// results, so lagging endpoints drop to the back of the queue.
+func (p *RPCPool) CheckHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, e := range p.endpoints {
+		wg.Add(1)
+		go func(e *endpoint) {
+			defer wg.Done()
+			var slot uint64
+			err := e.client.Call(ctx, "getSlot", nil, &slot)
+			if ctx.Err() != nil {
+				return
+			}
+			e.record("getSlot", err)
+			if err != nil {
+				log.Warn("RPC health check failed", "endpoint", e.config.Name, "error", err)
+				return
+			}
+			e.mu.Lock()
+			e.slot = slot
+			e.mu.Unlock()
+		}(e)
+	}
+	wg.Wait()
+}
+
+// Status returns a health snapshot of every endpoint in configuration order.
+func (p *RPCPool) Status() []EndpointStatus {
+	highest := p.highestSlot()
+	now := time.Now()
+	statuses := make([]EndpointStatus, 0, len(p.endpoints))
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		status := EndpointStatus{
+			Name:      e.config.Name,
+			Slot:      e.slot,
+			ErrorRate: e.errorRate,
+			Cooldown:  e.cooldownUntil,
+		}
+		e.mu.Unlock()
+		if status.Slot > 0 {
+			status.SlotLag = highest - status.Slot
+		}
+		status.Healthy = status.SlotLag <= p.maxSlotLag &&
+			status.ErrorRate <= p.maxErrorRate &&
+			now.After(status.Cooldown)
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// ordered returns healthy endpoints by priority followed by unhealthy ones,
+// which are still tried as a last resort.
+func (p *RPCPool) ordered() []*endpoint {
+	statuses := p.Status()
+	indexes := make([]int, len(p.endpoints))
+	for i := range indexes {
+		indexes[i] = i
+	}
+	sort.SliceStable(indexes, func(a, b int) bool {
+		sa, sb := statuses[indexes[a]], statuses[indexes[b]]
+		if sa.Healthy != sb.Healthy {
+			return sa.Healthy
+		}
+		if !sa.Healthy && sa.ErrorRate != sb.ErrorRate {
+			return sa.ErrorRate < sb.ErrorRate
+		}
+		return p.endpoints[indexes[a]].config.Priority < p.endpoints[indexes[b]].config.Priority
+	})
+	ordered := make([]*endpoint, len(indexes))
+	for i, index := range indexes {
+		ordered[i] = p.endpoints[index]
+	}
+	return ordered
+}
+
+// unsupports reports whether the endpoint answered MethodNotFound to method.
+func (e *endpoint) unsupports(method string) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.unsupported[method]
+}
+
+func (p *RPCPool) highestSlot() uint64 {
+	var highest uint64
+	for _, e := range p.endpoints {
+		e.mu.Lock()
+		if e.slot > highest {
+			highest = e.slot
+		}
+		e.mu.Unlock()
+	}
+	return highest
+}
+
+// shouldFailover reports whether err is a problem with the endpoint rather
+// than with the request, so another endpoint may succeed.
+func shouldFailover(err error) bool {
+	var httpErr *HTTPError
+	if errors.As(err, &httpErr) {
+		// Anything but a malformed request: rate limits, server errors and
+		// rejected API keys are all specific to the endpoint.
+		return httpErr.StatusCode != http.StatusBadRequest
+	}
+	var rpcErr *types.SolanaError
+	if errors.As(err, &rpcErr) {
+		switch rpcErr.Code {
+		case rpcMethodNotFound, rpcNodeUnhealthy:
+			return true
+		}
+		return false
+	}
+	// Network errors and malformed responses.
+	return true
+}
+
+// isMethodNotFound reports whether err says the endpoint doesn't implement
+// the method called.
+func isMethodNotFound(err error) bool {
+	var rpcErr *types.SolanaError
+	return errors.As(err, &rpcErr) && rpcErr.Code == rpcMethodNotFound
+}
+
+// JSON-RPC error codes returned by Solana nodes.
+const (
+	rpcMethodNotFound                 = -32601
+	rpcInvalidParams                  = -32602
+	rpcNodeUnhealthy                  = -32005
+	rpcSlotSkipped                    = -32007
+	rpcLongTermStorageSlotSkipped     = -32009
+	rpcTransactionHistoryNotAvailable = -32011
+)
@@ -0,0 +1,303 @@
+package requests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sol_test/types"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// standIn is a local RPC endpoint that counts the requests it serves.
+type standIn struct {
+	*httptest.Server
+	calls atomic.Int32
+}
+
+// newStandIn serves every JSON-RPC request with respond, which writes the
+// whole response for the request's method.
+func newStandIn(t *testing.T, respond func(w http.ResponseWriter, request types.RPCRequest)) *standIn {
+	t.Helper()
+	s := &standIn{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.calls.Add(1)
+		var request types.RPCRequest
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		respond(w, request)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// writeResult answers request with result.
+func writeResult(w http.ResponseWriter, request types.RPCRequest, result interface{}) {
+	json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": result})
+}
+
+// writeRPCError answers request with a JSON-RPC error object.
+func writeRPCError(w http.ResponseWriter, request types.RPCRequest, code int) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"id":      request.ID,
+		"error":   map[string]interface{}{"code": code, "message": "stand-in error"},
+	})
+}
+
+// slotServer reports slot for getSlot.
+func slotServer(t *testing.T, slot uint64) *standIn {
+	return newStandIn(t, func(w http.ResponseWriter, request types.RPCRequest) {
+		writeResult(w, request, slot)
+	})
+}
+
+func newTestPool(t *testing.T, servers ...*standIn) *RPCPool {
+	t.Helper()
+	config := PoolConfig{MaxSlotLag: 50, MaxErrorRate: 0.5}
+	for i, server := range servers {
+		config.Endpoints = append(config.Endpoints, EndpointConfig{
+			Name:     string(rune('a' + i)),
+			URL:      server.URL,
+			Priority: i,
+		})
+	}
+	pool, err := NewRPCPool(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pool
+}
+
+func orderedNames(pool *RPCPool) []string {
+	var names []string
+	for _, e := range pool.ordered() {
+		names = append(names, e.config.Name)
+	}
+	return names
+}
+
+func equalNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestPoolFailsOverOnRateLimit(t *testing.T) {
+	limited := newStandIn(t, func(w http.ResponseWriter, _ types.RPCRequest) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	})
+	backup := slotServer(t, 100)
+	pool := newTestPool(t, limited, backup)
+
+	var slot uint64
+	if err := pool.Call(context.Background(), "getSlot", nil, &slot); err != nil {
+		t.Fatal(err)
+	}
+	if slot != 100 {
+		t.Errorf("slot = %d, want 100 from the backup", slot)
+	}
+
+	status := pool.Status()[0]
+	if status.Healthy {
+		t.Error("rate limited endpoint is still healthy")
+	}
+	if until := time.Until(status.Cooldown); until < 25*time.Second || until > 30*time.Second {
+		t.Errorf("cooldown ends in %s, want the 30s Retry-After", until)
+	}
+	if got := orderedNames(pool); !equalNames(got, []string{"b", "a"}) {
+		t.Errorf("order = %v, want the cooling down endpoint last", got)
+	}
+
+	if err := pool.Call(context.Background(), "getSlot", nil, &slot); err != nil {
+		t.Fatal(err)
+	}
+	if calls := limited.calls.Load(); calls != 1 {
+		t.Errorf("rate limited endpoint called %d times, want 1", calls)
+	}
+}
+
+func TestPoolFailsOverOnServerError(t *testing.T) {
+	broken := newStandIn(t, func(w http.ResponseWriter, _ types.RPCRequest) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	backup := slotServer(t, 100)
+	pool := newTestPool(t, broken, backup)
+
+	var slot uint64
+	if err := pool.Call(context.Background(), "getSlot", nil, &slot); err != nil {
+		t.Fatal(err)
+	}
+	status := pool.Status()[0]
+	if status.Healthy {
+		t.Error("erroring endpoint is still healthy")
+	}
+	if until := time.Until(status.Cooldown); until <= 0 || until > defaultCooldown {
+		t.Errorf("cooldown ends in %s, want the default %s", until, defaultCooldown)
+	}
+
+	// Once the cooldown is over the endpoint is back in front, its single
+	// error being well under the maximum error rate.
+	pool.endpoints[0].mu.Lock()
+	pool.endpoints[0].cooldownUntil = time.Now().Add(-time.Second)
+	pool.endpoints[0].mu.Unlock()
+	if got := orderedNames(pool); !equalNames(got, []string{"a", "b"}) {
+		t.Errorf("order after cooldown = %v, want [a b]", got)
+	}
+}
+
+func TestPoolFailsOverOnMethodNotFound(t *testing.T) {
+	limited := newStandIn(t, func(w http.ResponseWriter, request types.RPCRequest) {
+		writeRPCError(w, request, rpcMethodNotFound)
+	})
+	backup := slotServer(t, 100)
+	pool := newTestPool(t, limited, backup)
+
+	var slot uint64
+	if err := pool.Call(context.Background(), "getSlot", nil, &slot); err != nil {
+		t.Fatal(err)
+	}
+	if slot != 100 || backup.calls.Load() != 1 {
+		t.Errorf("slot = %d after %d backup calls, want 100 after 1", slot, backup.calls.Load())
+	}
+	if status := pool.Status()[0]; !status.Healthy || status.ErrorRate != 0 {
+		t.Errorf("missing method counted against the endpoint: %+v", status)
+	}
+
+	// The endpoint is known not to serve the method, so the backup goes first.
+	if err := pool.Call(context.Background(), "getSlot", nil, &slot); err != nil {
+		t.Fatal(err)
+	}
+	if calls := limited.calls.Load(); calls != 1 {
+		t.Errorf("limited endpoint called %d times, want once", calls)
+	}
+}
+
+func TestPoolReturnsRequestErrors(t *testing.T) {
+	rejecting := newStandIn(t, func(w http.ResponseWriter, request types.RPCRequest) {
+		writeRPCError(w, request, rpcInvalidParams)
+	})
+	backup := slotServer(t, 100)
+	pool := newTestPool(t, rejecting, backup)
+
+	err := pool.Call(context.Background(), "getSlot", nil, nil)
+	var rpcErr *types.SolanaError
+	if !errors.As(err, &rpcErr) || rpcErr.Code != rpcInvalidParams {
+		t.Fatalf("err = %v, want the invalid params error", err)
+	}
+	if calls := backup.calls.Load(); calls != 0 {
+		t.Errorf("backup called %d times for a request error", calls)
+	}
+	if !pool.Status()[0].Healthy {
+		t.Error("request error marked the endpoint unhealthy")
+	}
+}
+
+func TestPoolHealthCheckDemotesLaggingEndpoint(t *testing.T) {
+	lagging := slotServer(t, 1000)
+	current := slotServer(t, 2000)
+	pool := newTestPool(t, lagging, current)
+
+	pool.CheckHealth(context.Background())
+	statuses := pool.Status()
+	if statuses[0].Healthy || statuses[0].SlotLag != 1000 {
+		t.Errorf("lagging endpoint: healthy %v, lag %d; want unhealthy with lag 1000", statuses[0].Healthy, statuses[0].SlotLag)
+	}
+	if !statuses[1].Healthy {
+		t.Error("current endpoint is unhealthy")
+	}
+	if got := orderedNames(pool); !equalNames(got, []string{"b", "a"}) {
+		t.Errorf("order = %v, want the lagging endpoint last", got)
+	}
+
+	// Catching up within MaxSlotLag makes it healthy again.
+	pool.endpoints[0].mu.Lock()
+	pool.endpoints[0].slot = 1990
+	pool.endpoints[0].mu.Unlock()
+	if got := orderedNames(pool); !equalNames(got, []string{"a", "b"}) {
+		t.Errorf("order after catching up = %v, want [a b]", got)
+	}
+}
+
+func TestPoolTriesUnhealthyEndpointsLast(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	flaky := newStandIn(t, func(w http.ResponseWriter, request types.RPCRequest) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		writeResult(w, request, uint64(100))
+	})
+	broken := newStandIn(t, func(w http.ResponseWriter, _ types.RPCRequest) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	pool := newTestPool(t, flaky, broken)
+
+	if err := pool.Call(context.Background(), "getSlot", nil, nil); err == nil {
+		t.Fatal("call succeeded with every endpoint failing")
+	}
+	// Both are cooling down; the one that failed more often goes last.
+	pool.endpoints[1].record("getSlot", &HTTPError{StatusCode: http.StatusInternalServerError})
+
+	failing.Store(false)
+	var slot uint64
+	if err := pool.Call(context.Background(), "getSlot", nil, &slot); err != nil {
+		t.Fatalf("unhealthy endpoints weren't tried as a last resort: %v", err)
+	}
+	if slot != 100 {
+		t.Errorf("slot = %d, want 100", slot)
+	}
+	if got := orderedNames(pool); got[0] != "a" {
+		t.Errorf("order = %v, want the endpoint with the lower error rate first", got)
+	}
+}
+
+func TestPoolErrorRateMarksEndpointUnhealthy(t *testing.T) {
+	var failing atomic.Bool
+	failing.Store(true)
+	flaky := newStandIn(t, func(w http.ResponseWriter, request types.RPCRequest) {
+		if failing.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeResult(w, request, uint64(100))
+	})
+	pool := newTestPool(t, flaky, slotServer(t, 100))
+	flakyEndpoint := pool.endpoints[0]
+	clearCooldown := func() {
+		flakyEndpoint.mu.Lock()
+		flakyEndpoint.cooldownUntil = time.Time{}
+		flakyEndpoint.mu.Unlock()
+	}
+
+	// Each failure weighs 0.1, so the seventh pushes the rate over 0.5.
+	for range 7 {
+		clearCooldown()
+		pool.CheckHealth(context.Background())
+	}
+	clearCooldown()
+	if status := pool.Status()[0]; status.Healthy || status.ErrorRate <= 0.5 {
+		t.Fatalf("after 7 failures: healthy %v, error rate %.2f", status.Healthy, status.ErrorRate)
+	}
+
+	failing.Store(false)
+	for range 2 {
+		pool.CheckHealth(context.Background())
+	}
+	if status := pool.Status()[0]; !status.Healthy {
+		t.Errorf("still unhealthy after recovering, error rate %.2f", status.ErrorRate)
+	}
+}
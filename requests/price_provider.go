@@ -0,0 +1,191 @@
+package requests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"sol_test/types"
+
+	"github.com/charmbracelet/log"
+)
+
+// ErrNoPrice is returned when a provider has no price for a token.
+var ErrNoPrice = errors.New("no price available")
+
+// ErrUnsupported is returned by providers that don't offer a capability, such as history.
+var ErrUnsupported = errors.New("not supported by provider")
+
+// HistoryOptions selects an OHLCV series. Timeframe is "minute", "hour" or
+// "day" and Aggregate groups that many units per candle. From and To are unix
+// seconds; zero values mean the latest page of candles.
+type HistoryOptions struct {
+	Timeframe string
+	Aggregate int
+	From      int64
+	To        int64
+}
+
+// PriceProvider is a source of USD token prices.
+type PriceProvider interface {
+	// Name identifies the provider in MyToken.PriceSource.
+	Name() string
+	// Price returns the current USD price of mint.
+	Price(ctx context.Context, mint string) (float64, error)
+	// Prices returns the current USD prices of mints. Mints without a price are left out of the map.
+	Prices(ctx context.Context, mints []string) (map[string]float64, error)
+	// History returns USD candles for mint, newest first.
+	History(ctx context.Context, mint string, opts HistoryOptions) ([]types.Candle, error)
+}
+
+// batchPrice implements PriceProvider.Price on top of PriceProvider.Prices.
+func batchPrice(ctx context.Context, p PriceProvider, mint string) (float64, error) {
+	prices, err := p.Prices(ctx, []string{mint})
+	if err != nil {
+		return 0, err
+	}
+	price, ok := prices[mint]
+	if !ok {
+		return 0, fmt.Errorf("%s: %w for %s", p.Name(), ErrNoPrice, mint)
+	}
+	return price, nil
+}
+
+// CompositeProvider asks its providers in priority order and cross-checks
+// each price against the provider after the one that priced it.
+type CompositeProvider struct {
+	providers []PriceProvider
+	// maxDeviation is the relative difference between two sources above which a price is flagged.
+	maxDeviation float64
+}
+
+// NewCompositeProvider combines providers, highest priority first.
+func NewCompositeProvider(maxDeviation float64, providers ...PriceProvider) *CompositeProvider {
+	return &CompositeProvider{providers: providers, maxDeviation: maxDeviation}
+}
+
+// NewDefaultPriceProvider builds the composite provider used by the API:
+// GeckoTerminal, Jupiter, CoinGecko and Birdeye (only when BIRDEYE_API_KEY is
+// set). On-chain pool reserves are left out, as reading them takes several
+// RPC calls per token; see NewOnChainPriceProvider.
+func NewDefaultPriceProvider() *CompositeProvider {
+	providers := []PriceProvider{
+		GeckoTerminalProvider{},
+		JupiterProvider{},
+		CoinGeckoProvider{APIKey: os.Getenv("COINGECKO_API_KEY")},
+	}
+	if key := os.Getenv("BIRDEYE_API_KEY"); key != "" {
+		providers = append(providers, BirdeyeProvider{APIKey: key})
+	}
+	return NewCompositeProvider(0.05, providers...)
+}
+
+// NewOnChainPriceProvider builds the provider of pricing=onchain: on-chain
+// pool reserves first, for tokens too new to be listed anywhere, then the
+// default providers.
+func NewOnChainPriceProvider() *CompositeProvider {
+	defaults := NewDefaultPriceProvider()
+	providers := append([]PriceProvider{OnChainProvider{}}, defaults.providers...)
+	return NewCompositeProvider(defaults.maxDeviation, providers...)
+}
+
+func (c *CompositeProvider) Name() string {
+	return "composite"
+}
+
+func (c *CompositeProvider) Price(ctx context.Context, mint string) (float64, error) {
+	quotes, err := c.Quotes(ctx, []string{mint})
+	if err != nil {
+		return 0, err
+	}
+	quote, ok := quotes[mint]
+	if !ok {
+		return 0, fmt.Errorf("%w for %s", ErrNoPrice, mint)
+	}
+	return quote.Price, nil
+}
+
+func (c *CompositeProvider) Prices(ctx context.Context, mints []string) (map[string]float64, error) {
+	quotes, err := c.Quotes(ctx, mints)
+	prices := make(map[string]float64, len(quotes))
+	for mint, quote := range quotes {
+		prices[mint] = quote.Price
+	}
+	return prices, err
+}
+
+// History returns the first non-empty series in provider order.
+func (c *CompositeProvider) History(ctx context.Context, mint string, opts HistoryOptions) ([]types.Candle, error) {
+	lastErr := fmt.Errorf("%w for %s", ErrNoPrice, mint)
+	for _, provider := range c.providers {
+		candles, err := provider.History(ctx, mint, opts)
+		if err == nil && len(candles) > 0 {
+			return candles, nil
+		}
+		if err != nil && !errors.Is(err, ErrUnsupported) {
+			lastErr = err
+		}
+	}
+	return nil, lastErr
+}
+
+// Quotes prices mints and records the source of every price. Each price is
+// taken from the highest priority provider that has one and checked against
+// the provider after it, if that one knows the token; prices that disagree by
+// more than the allowed deviation are flagged as disputed. Priced tokens
+// aren't sent further down just to be checked. An error is only returned
+// when every provider failed.
+func (c *CompositeProvider) Quotes(ctx context.Context, mints []string) (map[string]types.PriceQuote, error) {
+	quotes := make(map[string]types.PriceQuote, len(mints))
+	checked := make(map[string]bool, len(mints))
+	var errs []error
+	for _, provider := range c.providers {
+		var pending []string
+		for _, mint := range mints {
+			if _, ok := quotes[mint]; !ok || !checked[mint] {
+				pending = append(pending, mint)
+			}
+		}
+		// Whatever this provider answers, prices known before it are checked once.
+		for _, mint := range pending {
+			if _, ok := quotes[mint]; ok {
+				checked[mint] = true
+			}
+		}
+		if len(pending) == 0 {
+			break
+		}
+
+		prices, err := provider.Prices(ctx, pending)
+		if err != nil {
+			if ctx.Err() != nil {
+				return quotes, ctx.Err()
+			}
+			log.Warn("Price provider failed", "provider", provider.Name(), "error", err)
+			errs = append(errs, fmt.Errorf("%s: %w", provider.Name(), err))
+		}
+		for mint, price := range prices {
+			if price <= 0 || math.IsNaN(price) || math.IsInf(price, 0) {
+				continue
+			}
+			quote, ok := quotes[mint]
+			if !ok {
+				quotes[mint] = types.PriceQuote{Price: price, Source: provider.Name()}
+				continue
+			}
+			quote.CheckedBy = provider.Name()
+			quote.Deviation = math.Abs(price-quote.Price) / quote.Price
+			if quote.Deviation > c.maxDeviation {
+				quote.Disputed = true
+				log.Warn("Price sources disagree", "mint", mint,
+					quote.Source, quote.Price, provider.Name(), price)
+			}
+			quotes[mint] = quote
+		}
+	}
+	if len(quotes) == 0 && len(errs) == len(c.providers) && len(errs) > 0 {
+		return quotes, errors.Join(errs...)
+	}
+	return quotes, nil
+}
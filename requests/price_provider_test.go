@@ -0,0 +1,78 @@
+package requests
+
+import (
+	"context"
+	"reflect"
+	"sol_test/types"
+	"sort"
+	"testing"
+)
+
+// fixedProvider prices the mints in prices and records what it was asked.
+type fixedProvider struct {
+	name   string
+	prices map[string]float64
+	asked  *[]string
+}
+
+func (p fixedProvider) Name() string { return p.name }
+
+func (p fixedProvider) Price(ctx context.Context, mint string) (float64, error) {
+	return batchPrice(ctx, p, mint)
+}
+
+func (p fixedProvider) Prices(ctx context.Context, mints []string) (map[string]float64, error) {
+	*p.asked = append(*p.asked, mints...)
+	prices := make(map[string]float64)
+	for _, mint := range mints {
+		if price, ok := p.prices[mint]; ok {
+			prices[mint] = price
+		}
+	}
+	return prices, nil
+}
+
+func (p fixedProvider) History(ctx context.Context, mint string, opts HistoryOptions) ([]types.Candle, error) {
+	return nil, ErrUnsupported
+}
+
+func TestCompositeChecksEachPriceOnce(t *testing.T) {
+	var first, second, third []string
+	composite := NewCompositeProvider(0.05,
+		fixedProvider{"first", map[string]float64{"a": 1, "b": 2}, &first},
+		// Doesn't know b, so b stays unchecked rather than going to third.
+		fixedProvider{"second", map[string]float64{"a": 1.2, "c": 3}, &second},
+		fixedProvider{"third", map[string]float64{"a": 5, "b": 5, "c": 3.03, "d": 4}, &third},
+	)
+	quotes, err := composite.Quotes(context.Background(), []string{"a", "b", "c", "d"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sort.Strings(second)
+	sort.Strings(third)
+	if !reflect.DeepEqual(second, []string{"a", "b", "c", "d"}) {
+		t.Errorf("second asked for %v", second)
+	}
+	if !reflect.DeepEqual(third, []string{"c", "d"}) {
+		t.Errorf("third asked for %v, want only c to check and d to price", third)
+	}
+
+	want := map[string]types.PriceQuote{
+		"a": {Price: 1, Source: "first", CheckedBy: "second", Deviation: quotes["a"].Deviation, Disputed: true},
+		"b": {Price: 2, Source: "first"},
+		"c": {Price: 3, Source: "second", CheckedBy: "third", Deviation: quotes["c"].Deviation},
+		"d": {Price: 4, Source: "third"},
+	}
+	if !reflect.DeepEqual(quotes, want) {
+		t.Errorf("quotes = %+v, want %+v", quotes, want)
+	}
+	if !closeTo(quotes["a"].Deviation, 0.2) || !closeTo(quotes["c"].Deviation, 0.01) {
+		t.Errorf("deviations = %v and %v, want 0.2 and 0.01", quotes["a"].Deviation, quotes["c"].Deviation)
+	}
+}
+
+func closeTo(a, b float64) bool {
+	d := a - b
+	return d < 1e-9 && d > -1e-9
+}
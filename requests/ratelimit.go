@@ -0,0 +1,126 @@
+package requests
+
+import (
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RateLimiter is a token bucket shared by every caller that holds it.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // tokens added per second
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter allows ratePerSecond requests on average with bursts of up to burst requests.
+func NewRateLimiter(ratePerSecond float64, burst int) *RateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &RateLimiter{
+		rate:   ratePerSecond,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// Wait blocks until n tokens are available or ctx is done. Requests larger
+// than the burst size are clamped to it so they can't block forever. A
+// limiter with a non-positive rate never blocks.
+func (l *RateLimiter) Wait(ctx context.Context, n int) error {
+	if l.rate <= 0 {
+		return ctx.Err()
+	}
+	need := math.Min(float64(n), l.burst)
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+		l.last = now
+		if l.tokens >= need {
+			l.tokens -= need
+			l.mu.Unlock()
+			return nil
+		}
+		wait := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+
+		if err := sleepContext(ctx, wait); err != nil {
+			return err
+		}
+	}
+}
+
+type budgetKey struct{}
+
+// WithBudget returns a context whose RPC calls also take tokens from budget,
+// on top of the shared limiter. Background work uses it to stay within its
+// share of the node's rate limit and leave the rest to API requests.
+func WithBudget(ctx context.Context, budget *RateLimiter) context.Context {
+	return context.WithValue(ctx, budgetKey{}, budget)
+}
+
+// rateLimitedCaller takes tokens from a shared limiter before every call.
+type rateLimitedCaller struct {
+	Caller
+	limiter *RateLimiter
+}
+
+// WithRateLimit wraps c so every call first waits for limiter and for the
+// budget of its context, if any. A batch costs one token per item, since
+// providers bill batch items individually.
+func WithRateLimit(c Caller, limiter *RateLimiter) Caller {
+	return &rateLimitedCaller{Caller: c, limiter: limiter}
+}
+
+func (c *rateLimitedCaller) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	if err := c.wait(ctx, 1); err != nil {
+		return err
+	}
+	return c.Caller.Call(ctx, method, params, result)
+}
+
+func (c *rateLimitedCaller) Batch(ctx context.Context, calls []BatchCall) error {
+	if err := c.wait(ctx, len(calls)); err != nil {
+		return err
+	}
+	return c.Caller.Batch(ctx, calls)
+}
+
+// wait takes n tokens from the context's budget, then from the shared limiter.
+func (c *rateLimitedCaller) wait(ctx context.Context, n int) error {
+	if budget, ok := ctx.Value(budgetKey{}).(*RateLimiter); ok && budget != nil {
+		if err := budget.Wait(ctx, n); err != nil {
+			return err
+		}
+	}
+	return c.limiter.Wait(ctx, n)
+}
+
+// Backoff computes exponential retry delays with jitter.
+type Backoff struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+// Delay returns the wait before retry number attempt (starting at 1). The
+// delay doubles each attempt up to Max and is jittered into [d/2, d] so that
+// concurrent workers don't retry in lockstep. A longer Retry-After requested
+// by the node always wins.
+func (b Backoff) Delay(attempt int, retryAfter time.Duration) time.Duration {
+	d := b.Base << (attempt - 1)
+	if d <= 0 || d > b.Max {
+		d = b.Max
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+	if retryAfter > d {
+		return retryAfter
+	}
+	return d
+}
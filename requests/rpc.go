@@ -0,0 +1,218 @@
+package requests
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sol_test/types"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// HTTPError is returned when the RPC node answers with a non-200 status code.
+type HTTPError struct {
+	StatusCode int
+	// RetryAfter is the delay requested by the node's Retry-After header, if any.
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("received non-200 status: %d (retry after %s)", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("received non-200 status: %d", e.StatusCode)
+}
+
+// RPCClient is a JSON-RPC 2.0 client for a single Solana RPC endpoint.
+type RPCClient struct {
+	endpoint   string
+	headers    map[string]string
+	httpClient *http.Client
+	nextID     atomic.Int64
+}
+
+// NewRPCClient creates a client for the given endpoint URL.
+func NewRPCClient(endpoint string) *RPCClient {
+	return &RPCClient{
+		endpoint:   endpoint,
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Call invokes method with params and decodes the JSON-RPC result into result.
+// A JSON-RPC error object is returned as a *types.SolanaError, a non-200 status
+// as an *HTTPError. A nil result discards the response body.
+func (c *RPCClient) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	request := types.RPCRequest{
+		JsonRPC: "2.0",
+		ID:      c.nextID.Add(1),
+		Method:  method,
+		Params:  params,
+	}
+	requestBytes, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("%s: failed to marshal request: %w", method, err)
+	}
+
+	body, err := c.post(ctx, requestBytes)
+	if err != nil {
+		return fmt.Errorf("%s: %w", method, err)
+	}
+
+	var response types.RPCResponse
+	if err := json.Unmarshal(body, &response); err != nil {
+		return fmt.Errorf("%s: failed to unmarshal response: %w", method, err)
+	}
+	if response.Error != nil {
+		return fmt.Errorf("%s: %w", method, response.Error)
+	}
+	if result == nil || len(response.Result) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(response.Result, result); err != nil {
+		return fmt.Errorf("%s: failed to unmarshal result: %w", method, err)
+	}
+	return nil
+}
+
+// BatchCall is a single call within a JSON-RPC batch. Result is decoded in
+// place and Err is set when that item failed.
+type BatchCall struct {
+	Method string
+	Params []interface{}
+	Result interface{}
+	Err    error
+}
+
+// errMissingResponse is set on batch items the node didn't answer.
+var errMissingResponse = errors.New("no response for batch item")
+
+// Batch sends calls as a single JSON-RPC batch request. The returned error
+// covers the batch as a whole (transport failures, non-200 status, a batch
+// rejected outright); per-item errors are stored in each call's Err.
+func (c *RPCClient) Batch(ctx context.Context, calls []BatchCall) error {
+	if len(calls) == 0 {
+		return nil
+	}
+	requests := make([]types.RPCRequest, len(calls))
+	index := make(map[int64]int, len(calls))
+	for i, call := range calls {
+		id := c.nextID.Add(1)
+		requests[i] = types.RPCRequest{
+			JsonRPC: "2.0",
+			ID:      id,
+			Method:  call.Method,
+			Params:  call.Params,
+		}
+		index[id] = i
+	}
+	requestBytes, err := json.Marshal(requests)
+	if err != nil {
+		return fmt.Errorf("batch: failed to marshal request: %w", err)
+	}
+
+	body, err := c.post(ctx, requestBytes)
+	if err != nil {
+		return fmt.Errorf("batch: %w", err)
+	}
+
+	// Nodes that reject a batch answer with a single response object.
+	body = bytes.TrimSpace(body)
+	if len(body) > 0 && body[0] == '{' {
+		var response types.RPCResponse
+		if err := json.Unmarshal(body, &response); err != nil {
+			return fmt.Errorf("batch: failed to unmarshal response: %w", err)
+		}
+		if response.Error != nil {
+			return fmt.Errorf("batch: %w", response.Error)
+		}
+		return fmt.Errorf("batch: unexpected single response")
+	}
+
+	var responses []types.RPCResponse
+	if err := json.Unmarshal(body, &responses); err != nil {
+		return fmt.Errorf("batch: failed to unmarshal response: %w", err)
+	}
+	for i := range calls {
+		calls[i].Err = errMissingResponse
+	}
+	for _, response := range responses {
+		i, ok := index[response.ID]
+		if !ok {
+			continue
+		}
+		call := &calls[i]
+		switch {
+		case response.Error != nil:
+			call.Err = fmt.Errorf("%s: %w", call.Method, response.Error)
+		case call.Result == nil || len(response.Result) == 0:
+			call.Err = nil
+		default:
+			call.Err = json.Unmarshal(response.Result, call.Result)
+			if call.Err != nil {
+				call.Err = fmt.Errorf("%s: failed to unmarshal result: %w", call.Method, call.Err)
+			}
+		}
+	}
+	return nil
+}
+
+// post sends a raw JSON-RPC payload and returns the response body.
+func (c *RPCClient) post(ctx context.Context, payload []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range c.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &HTTPError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// parseRetryAfter understands both forms of the Retry-After header: a delay in
+// seconds or an HTTP date.
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay
+		}
+	}
+	return 0
+}
+
+// sleepContext waits for d or until ctx is done, whichever comes first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -1,14 +1,16 @@
 package requests
 
 import (
-	"bytes"
-	"encoding/json"
+	"context"
+	"encoding/base64"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"math"
-	"net/http"
+	"sol_test/cache"
+	"sol_test/solana"
 	"sol_test/types"
-	"strconv"
+	"sort"
+	"sync"
 	"time"
 
 	"github.com/charmbracelet/log"
@@ -16,186 +18,416 @@ import (
 
 const solanaRPC = "https://api.mainnet-beta.solana.com"
 
-// queryRPC queries the Solana RPC and returns a prettified json string
-func queryRPC(method string, params []interface{}) string {
-	requestPayload := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      1,
-		"method":  method,
-		"params":  params,
-	}
+// rpc performs every call made by this package, see UseRPC.
+var rpc Caller = NewRPCClient(solanaRPC)
 
-	requestBytes, err := json.Marshal(requestPayload)
+func RequestAccountInfo(ctx context.Context, address string) (types.Wallet, error) {
+	var response types.GetAccountInfoResponse
+	err := rpc.Call(ctx, "getAccountInfo", []interface{}{
+		address,
+		map[string]interface{}{
+			"encoding": "base64",
+		},
+	}, &response.Result)
 	if err != nil {
-		log.Error("Error occured", "Stack", err)
+		return types.Wallet{}, err
 	}
-	//TODO: what to do if request returns error instead of data
-	resp, err := http.Post(solanaRPC, "application/json", bytes.NewBuffer(requestBytes))
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, body, "", "  "); err != nil {
-		log.Errorf("Error indenting JSON:", err)
-		return ""
+	var balance types.GetWalletResult
+	if err := rpc.Call(ctx, "getBalance", []interface{}{address}, &balance); err != nil {
+		return types.Wallet{}, err
 	}
-
-	return prettyJSON.String()
+	divisor := math.Pow10(9)
+	floatValue := float64(balance.Value) / divisor
+	return types.Wallet{AccountInfo: response, SolAmount: floatValue}, nil
 }
 
-func RequestAccountInfo(address string) types.Wallet {
-	data := queryRPC("getAccountInfo", []interface{}{address})
-	var response types.GetAccountInfoResponse
-	err := json.Unmarshal([]byte(data), &response)
-	if err != nil {
-		log.Error("Error occured", "Stack", err)
+// RequestTokenAccounts returns the wallet's token accounts under both the SPL
+// Token and the Token-2022 program. Each account's Owner is its program.
+func RequestTokenAccounts(ctx context.Context, address string) (types.GetTokenAccountsByOwnerResponse, error) {
+	programs := []string{solana.TokenProgramID, solana.Token2022ProgramID}
+	results := make([]types.GetTokenAccountsByOwnerResult, len(programs))
+	calls := make([]BatchCall, len(programs))
+	for i, program := range programs {
+		calls[i] = BatchCall{
+			Method: "getTokenAccountsByOwner",
+			Params: []interface{}{
+				address,
+				map[string]interface{}{
+					"programId": program,
+				},
+				map[string]interface{}{
+					"encoding": "jsonParsed",
+				},
+			},
+			Result: &results[i],
+		}
 	}
-	balance := queryRPC("getBalance", []interface{}{address})
-	var walletresponse types.GetWalletResponse
-	err = json.Unmarshal([]byte(balance), &walletresponse)
-	if err != nil {
-		log.Error("Error occured", "Stack", err)
+	var response types.GetTokenAccountsByOwnerResponse
+	if err := rpc.Batch(ctx, calls); err != nil {
+		return response, err
 	}
-	divisor := math.Pow10(9)
-	floatValue := float64(walletresponse.Result.Value) / divisor
-	return types.Wallet{response, floatValue}
+	for i, call := range calls {
+		if call.Err != nil {
+			return response, fmt.Errorf("%s accounts: %w", programs[i], call.Err)
+		}
+		response.Result.Context.Slot = max(response.Result.Context.Slot, results[i].Context.Slot)
+		response.Result.Context.ApiVersion = results[i].Context.ApiVersion
+		response.Result.Value = append(response.Result.Value, results[i].Value...)
+	}
+	return response, nil
 }
 
-func RequestTokenAccounts(address string) types.GetTokenAccountsByOwnerResponse {
-	data := queryRPC("getTokenAccountsByOwner", []interface{}{
+// GetAccountData returns the raw data of an account and the program that owns it.
+func GetAccountData(ctx context.Context, address string) ([]byte, string, error) {
+	var result types.GetAccountInfoResult
+	err := rpc.Call(ctx, "getAccountInfo", []interface{}{
 		address,
 		map[string]interface{}{
-			"programId": "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA",
+			"encoding": "base64",
 		},
+	}, &result)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(result.Value.Data) == 0 {
+		return nil, "", fmt.Errorf("account %s not found", address)
+	}
+	data, err := base64.StdEncoding.DecodeString(result.Value.Data[0])
+	if err != nil {
+		return nil, "", fmt.Errorf("account %s: %w", address, err)
+	}
+	return data, result.Value.Owner, nil
+}
+
+// GetMultipleAccountsData returns the raw data of several accounts in one
+// call. Missing accounts have nil data.
+func GetMultipleAccountsData(ctx context.Context, addresses []string) ([][]byte, error) {
+	var result struct {
+		Value []*types.GetAccountInfoValue `json:"value"`
+	}
+	err := rpc.Call(ctx, "getMultipleAccounts", []interface{}{
+		addresses,
 		map[string]interface{}{
-			"encoding": "jsonParsed",
+			"encoding": "base64",
 		},
-	})
-	var response types.GetTokenAccountsByOwnerResponse
-	err := json.Unmarshal([]byte(data), &response)
+	}, &result)
 	if err != nil {
-		log.Error("Error occured", "Stack", err)
+		return nil, err
+	}
+	data := make([][]byte, len(addresses))
+	for i, value := range result.Value {
+		if i >= len(data) || value == nil || len(value.Data) == 0 {
+			continue
+		}
+		if data[i], err = base64.StdEncoding.DecodeString(value.Data[0]); err != nil {
+			return nil, fmt.Errorf("account %s: %w", addresses[i], err)
+		}
 	}
-	return response
+	return data, nil
 }
 
-func GetTokenMetadata(address string) types.GetTokenMetaDataResponse {
-	data := queryRPC("getAsset", []interface{}{address})
+// GetTokenMetadata returns the token's metadata from the DAS getAsset method,
+// falling back to the Metaplex metadata account when the RPC doesn't
+// implement DAS or doesn't know the token.
+func GetTokenMetadata(ctx context.Context, address string) (types.GetTokenMetaDataResponse, error) {
+	return cache.Fetch(ctx, responseCache, "metadata", address, metadataCacheTTL, func(ctx context.Context) (types.GetTokenMetaDataResponse, error) {
+		return getTokenMetadata(ctx, address)
+	})
+}
+
+func getTokenMetadata(ctx context.Context, address string) (types.GetTokenMetaDataResponse, error) {
 	var response types.GetTokenMetaDataResponse
-	err := json.Unmarshal([]byte(data), &response)
-	if err != nil {
-		log.Error("Error occured", "Stack", err)
+	err := rpc.Call(ctx, "getAsset", []interface{}{address}, &response.Result)
+	if err == nil && response.Result.Content.Metadata.Name != "" {
+		return response, nil
 	}
-	return response
-}
-func queryRPCWithRetry(method string, params []interface{}) (string, error) {
-	requestPayload := map[string]interface{}{
-		"jsonrpc": "2.0",
-		"id":      1,
-		"method":  method,
-		"params":  params,
+	if ctx.Err() != nil {
+		return response, ctx.Err()
 	}
-
-	requestBytes, err := json.Marshal(requestPayload)
-	if err != nil {
-		log.Error("Error marshalling request", "Stack", err)
-		return "", err
+	fallback, fallbackErr := GetMetaplexMetadata(ctx, address)
+	if fallbackErr != nil {
+		if err != nil {
+			return response, errors.Join(err, fallbackErr)
+		}
+		// getAsset knew the token, just not its name.
+		return response, nil
 	}
+	return fallback, nil
+}
 
-	resp, err := http.Post(solanaRPC, "application/json", bytes.NewBuffer(requestBytes))
-	if err != nil {
-		log.Error("HTTP Post error", "Stack", err)
-		return "", err
-	}
-	defer resp.Body.Close()
+// defaultTransactionBatchSize is the number of getTransaction calls sent per batch.
+const defaultTransactionBatchSize = 50
+
+// signaturePageSize is the maximum number of signatures getSignaturesForAddress returns per call.
+const signaturePageSize = 1000
+
+// SignatureOptions bounds the signature history returned by GetSignatures.
+// Zero values leave the corresponding bound open.
+type SignatureOptions struct {
+	// Before starts the scan below this signature instead of at the newest one.
+	Before string
+	// Until stops the scan at this signature (exclusive). Pass the newest
+	// signature of a previous scan to fetch only what's new since then.
+	Until string
+	// Limit caps the number of signatures returned.
+	Limit int
+	// MinSlot and MaxSlot bound the window by slot, inclusive.
+	MinSlot int64
+	MaxSlot int64
+	// From and To bound the window by block time, inclusive.
+	From time.Time
+	To   time.Time
+}
+
+// Defaults for TransactionOptions fields left at zero.
+const (
+	defaultTransactionConcurrency = 4
+	defaultTransactionMaxAttempts = 5
+)
+
+// transactionBackoff spaces out retries of failed transaction fetches.
+var transactionBackoff = Backoff{Base: 500 * time.Millisecond, Max: 30 * time.Second}
 
-	// Check for rate limiting or other errors via status code.
-	if resp.StatusCode != http.StatusOK {
-		retryAfterHeader := resp.Header.Get("Retry-After")
-		if retryAfterHeader != "" {
-			if delaySec, err := strconv.Atoi(retryAfterHeader); err == nil {
-				return "", fmt.Errorf("retry after %d seconds", delaySec)
+// TransactionOptions controls how GetTransactions fetches transaction data.
+type TransactionOptions struct {
+	SignatureOptions
+	// BatchSize is the number of transactions requested per JSON-RPC batch.
+	BatchSize int
+	// Concurrency is the number of batches fetched in parallel.
+	Concurrency int
+	// MaxAttempts caps how often a single signature is requested before it is reported as failed.
+	MaxAttempts int
+}
+
+// GetSignatures pages through getSignaturesForAddress with the before/until
+// cursors and returns the signatures inside the window, newest first.
+func GetSignatures(ctx context.Context, address string, opts SignatureOptions) ([]types.WalletTransactionHashResponse, error) {
+	var signatures []types.WalletTransactionHashResponse
+	err := EachSignaturePage(ctx, address, opts, func(page []types.WalletTransactionHashResponse) error {
+		signatures = append(signatures, page...)
+		return nil
+	})
+	return signatures, err
+}
+
+// EachSignaturePage pages through getSignaturesForAddress like GetSignatures
+// and calls fn with the signatures of each page inside the window, newest
+// first, so callers can process a long history as it arrives. An error from
+// fn ends the scan and is returned.
+func EachSignaturePage(ctx context.Context, address string, opts SignatureOptions, fn func(page []types.WalletTransactionHashResponse) error) error {
+	before := opts.Before
+	found := 0
+	for {
+		config := map[string]interface{}{
+			"limit": signaturePageSize,
+		}
+		if before != "" {
+			config["before"] = before
+		}
+		if opts.Until != "" {
+			config["until"] = opts.Until
+		}
+
+		var page []types.WalletTransactionHashResponse
+		if err := rpc.Call(ctx, "getSignaturesForAddress", []interface{}{address, config}, &page); err != nil {
+			return err
+		}
+
+		var (
+			inside []types.WalletTransactionHashResponse
+			done   = len(page) < signaturePageSize
+		)
+		for _, sig := range page {
+			// Signatures arrive newest first, so the first one below the
+			// window's lower bound ends the scan.
+			if (opts.MinSlot > 0 && sig.Slot < opts.MinSlot) ||
+				(!opts.From.IsZero() && sig.BlockTime > 0 && sig.BlockTime < opts.From.Unix()) {
+				done = true
+				break
 			}
+			if (opts.MaxSlot > 0 && sig.Slot > opts.MaxSlot) ||
+				(!opts.To.IsZero() && sig.BlockTime > opts.To.Unix()) {
+				continue
+			}
+			inside = append(inside, sig)
+			if opts.Limit > 0 && found+len(inside) >= opts.Limit {
+				done = true
+				break
+			}
+		}
+		found += len(inside)
+		if len(inside) > 0 {
+			if err := fn(inside); err != nil {
+				return err
+			}
+		}
+		if done {
+			return nil
 		}
-		return "", fmt.Errorf("received non-200 status: %d", resp.StatusCode)
+		before = page[len(page)-1].Signature
 	}
+}
 
-	body, err := ioutil.ReadAll(resp.Body)
+// GetTransactions fetches the transaction signatures inside the window set by opts, then fetches
+// the transactions in JSON-RPC batches using a pool of opts.Concurrency workers.
+// Failed items are retried with exponential backoff, respecting the Retry-After header if the RPC
+// returns a rate limiting response. Signatures that still fail after opts.MaxAttempts, or fail
+// permanently (e.g. pruned transactions), are returned alongside the transactions.
+func GetTransactions(ctx context.Context, address string, opts TransactionOptions) ([]types.TransactionResponse, []types.FailedSignature, error) {
+	// First, get the signatures.
+	found, err := GetSignatures(ctx, address, opts.SignatureOptions)
 	if err != nil {
-		log.Error("Error reading response body", "Stack", err)
-		return "", err
+		return nil, nil, err
 	}
-
-	var prettyJSON bytes.Buffer
-	if err := json.Indent(&prettyJSON, body, "", "  "); err != nil {
-		log.Errorf("Error indenting JSON", err)
-		return "", err
+	signatures := make([]string, len(found))
+	for i, sig := range found {
+		signatures[i] = sig.Signature
 	}
-
-	return prettyJSON.String(), nil
+	transactions, failed, err := FetchTransactions(ctx, signatures, opts)
+	if err != nil {
+		return transactions, failed, err
+	}
+	log.Info("Found Transactions", "wallet", address, "TransactionAmount", len(transactions), "Failed", len(failed))
+	return transactions, failed, nil
 }
 
-// GetTransactions fetches the transaction signatures, then uses a queue to ensure that all transaction data is fetched.
-// It will respect the Retry-After header if the RPC returns a rate limiting response.
-func GetTransactions(address string) []types.TransactionResponse {
-	// First, get the signatures.
-	data := queryRPC("getSignaturesForAddress", []interface{}{address})
-	var sigResponse types.GetSignaturesForAddressResponse
-	if err := json.Unmarshal([]byte(data), &sigResponse); err != nil {
-		log.Error("Error unmarshalling getSignaturesForAddress response", "Stack", err)
-		return nil
+// FetchTransactions fetches the transactions of signatures like
+// GetTransactions, newest first. opts.SignatureOptions is ignored.
+func FetchTransactions(ctx context.Context, signatures []string, opts TransactionOptions) ([]types.TransactionResponse, []types.FailedSignature, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultTransactionBatchSize
 	}
-
-	// Create a queue of signatures.
-	var queue []string
-	for _, sig := range sigResponse.Result {
-		queue = append(queue, sig.Signature)
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = defaultTransactionConcurrency
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = defaultTransactionMaxAttempts
 	}
 
-	var transactions []types.TransactionResponse
+	batches := make(chan []string)
+	go func() {
+		defer close(batches)
+		for start := 0; start < len(signatures); start += opts.BatchSize {
+			batch := signatures[start:min(start+opts.BatchSize, len(signatures))]
+			select {
+			case batches <- batch:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var (
+		mu           sync.Mutex
+		wg           sync.WaitGroup
+		transactions []types.TransactionResponse
+		failed       []types.FailedSignature
+	)
+	for range opts.Concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				fetched, batchFailed := fetchTransactionBatch(ctx, batch, opts.MaxAttempts)
+				mu.Lock()
+				transactions = append(transactions, fetched...)
+				failed = append(failed, batchFailed...)
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return transactions, failed, ctx.Err()
+	}
 
-	// Process the queue.
-	for len(queue) > 0 {
-		// Dequeue the first signature.
-		signature := queue[0]
-		queue = queue[1:]
+	// Workers finish out of order; keep the newest-first order of the signatures.
+	sort.SliceStable(transactions, func(a, b int) bool {
+		return transactions[a].Result.Slot > transactions[b].Result.Slot
+	})
+	return transactions, failed, nil
+}
 
-		params := []interface{}{
-			signature,
-			map[string]interface{}{
-				"encoding":                       "json",
-				"maxSupportedTransactionVersion": 0,
-			},
+// fetchTransactionBatch fetches one batch of signatures, retrying only the
+// items that failed until they succeed, fail permanently or run out of attempts.
+func fetchTransactionBatch(ctx context.Context, signatures []string, maxAttempts int) ([]types.TransactionResponse, []types.FailedSignature) {
+	var (
+		transactions []types.TransactionResponse
+		failed       []types.FailedSignature
+	)
+	pending := signatures
+	for attempt := 1; len(pending) > 0; attempt++ {
+		results := make([]types.TransactionResponse, len(pending))
+		calls := make([]BatchCall, len(pending))
+		for i, signature := range pending {
+			calls[i] = BatchCall{
+				Method: "getTransaction",
+				Params: []interface{}{
+					signature,
+					map[string]interface{}{
+						"encoding":                       "json",
+						"maxSupportedTransactionVersion": 0,
+					},
+				},
+				Result: &results[i].Result,
+			}
 		}
 
-		// Attempt to fetch the transaction data.
-		result, err := queryRPCWithRetry("getTransaction", params)
-		if err != nil {
-			// If the error contains a retry delay, parse it.
-			var delaySeconds int
-			if n, _ := fmt.Sscanf(err.Error(), "retry after %d seconds", &delaySeconds); n == 1 {
-				log.Info("Rate limited. Retrying after delay", "delaySeconds", delaySeconds, "signature", signature)
-				time.Sleep(time.Duration(delaySeconds) * time.Second)
-			} else {
-				// For other errors, log and briefly wait before requeuing.
-				log.Error("Error fetching transaction", "signature", signature, "error", err)
-				time.Sleep(1 * time.Second)
+		// A failed batch counts as a failure of every item in it.
+		if err := rpc.Batch(ctx, calls); err != nil {
+			for i := range calls {
+				calls[i].Err = err
 			}
-			// Requeue the signature for a retry.
-			queue = append(queue, signature)
-			continue
+		}
+		if ctx.Err() != nil {
+			return transactions, failed
 		}
 
-		var txResponse types.TransactionResponse
-		if err = json.Unmarshal([]byte(result), &txResponse); err != nil {
-			log.Error("Error unmarshalling transaction", "signature", signature, "Stack", err)
-			// Optionally requeue for another attempt.
-			queue = append(queue, signature)
-			continue
+		var (
+			retry      []string
+			retryAfter time.Duration
+		)
+		for i, call := range calls {
+			signature := pending[i]
+			switch {
+			case call.Err == nil && results[i].Result == nil:
+				failed = append(failed, types.FailedSignature{Signature: signature, Attempts: attempt, Error: "transaction not available", Permanent: true})
+			case call.Err == nil:
+				transactions = append(transactions, results[i])
+			case isPermanent(call.Err) || attempt >= maxAttempts:
+				log.Error("Giving up on transaction", "signature", signature, "attempts", attempt, "error", call.Err)
+				failed = append(failed, types.FailedSignature{Signature: signature, Attempts: attempt, Error: call.Err.Error(), Permanent: isPermanent(call.Err)})
+			default:
+				retry = append(retry, signature)
+				var httpErr *HTTPError
+				if errors.As(call.Err, &httpErr) && httpErr.RetryAfter > retryAfter {
+					retryAfter = httpErr.RetryAfter
+				}
+			}
+		}
+		if len(retry) == 0 {
+			break
 		}
 
-		transactions = append(transactions, txResponse)
+		delay := transactionBackoff.Delay(attempt, retryAfter)
+		log.Info("Retrying failed transactions", "count", len(retry), "attempt", attempt, "delay", delay)
+		if err := sleepContext(ctx, delay); err != nil {
+			return transactions, failed
+		}
+		pending = retry
 	}
+	return transactions, failed
+}
 
-	log.Info("Found Transactions", "wallet", address, "TransactionAmount", len(transactions))
-	return transactions
+// isPermanent reports whether retrying err can't succeed.
+func isPermanent(err error) bool {
+	var rpcErr *types.SolanaError
+	if !errors.As(err, &rpcErr) {
+		return false
+	}
+	switch rpcErr.Code {
+	case rpcInvalidParams, rpcSlotSkipped, rpcLongTermStorageSlotSkipped, rpcTransactionHistoryNotAvailable:
+		return true
+	}
+	return false
 }
@@ -0,0 +1,209 @@
+package requests
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sol_test/solana"
+	"sol_test/types"
+	"sort"
+)
+
+// Offsets of the authorities in a stake account, after the 4 byte state
+// enum and the 8 byte rent exempt reserve.
+const (
+	stakeStakerOffset     = 12
+	stakeWithdrawerOffset = 44
+)
+
+// DefaultStakeRewardEpochs is the number of past epochs GetStakeAccounts
+// reports rewards for when none is given.
+const DefaultStakeRewardEpochs = 5
+
+// MaxStakeRewardEpochs is the most past epochs GetStakeAccounts reports
+// rewards for.
+const MaxStakeRewardEpochs = 10
+
+// stakeProgramAccount is a jsonParsed stake account from getProgramAccounts.
+type stakeProgramAccount struct {
+	Pubkey  string `json:"pubkey"`
+	Account struct {
+		Lamports uint64 `json:"lamports"`
+		Data     struct {
+			Parsed struct {
+				Type string `json:"type"`
+				Info struct {
+					Meta struct {
+						Authorized struct {
+							Staker     string `json:"staker"`
+							Withdrawer string `json:"withdrawer"`
+						} `json:"authorized"`
+					} `json:"meta"`
+					Stake *struct {
+						Delegation struct {
+							Voter             string `json:"voter"`
+							Stake             uint64 `json:"stake,string"`
+							ActivationEpoch   uint64 `json:"activationEpoch,string"`
+							DeactivationEpoch uint64 `json:"deactivationEpoch,string"`
+						} `json:"delegation"`
+					} `json:"stake"`
+				} `json:"info"`
+			} `json:"parsed"`
+		} `json:"data"`
+	} `json:"account"`
+}
+
+// GetStakeAccounts returns the stake accounts whose stake or withdraw
+// authority is wallet, with the inflation rewards of the last rewardEpochs
+// epochs, at most MaxStakeRewardEpochs. Activation is derived from the
+// delegation's epochs and assumes stake warms up and cools down within one
+// epoch, which holds unless the whole network is (de)activating an unusual
+// amount of stake at once.
+func GetStakeAccounts(ctx context.Context, wallet string, rewardEpochs int) ([]types.StakeAccount, error) {
+	rewardEpochs = min(max(rewardEpochs, 0), MaxStakeRewardEpochs)
+	offsets := []int{stakeStakerOffset, stakeWithdrawerOffset}
+	results := make([][]stakeProgramAccount, len(offsets))
+	calls := make([]BatchCall, len(offsets))
+	for i, offset := range offsets {
+		calls[i] = BatchCall{
+			Method: "getProgramAccounts",
+			Params: []interface{}{
+				solana.StakeProgramID,
+				map[string]interface{}{
+					"encoding": "jsonParsed",
+					"filters": []interface{}{
+						map[string]interface{}{
+							"memcmp": map[string]interface{}{"offset": offset, "bytes": wallet},
+						},
+					},
+				},
+			},
+			Result: &results[i],
+		}
+	}
+	if err := rpc.Batch(ctx, calls); err != nil {
+		return nil, err
+	}
+	for _, call := range calls {
+		if call.Err != nil {
+			return nil, fmt.Errorf("stake accounts: %w", call.Err)
+		}
+	}
+
+	epoch, err := getEpoch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var stakes []types.StakeAccount
+	seen := make(map[string]bool)
+	for _, result := range results {
+		for _, account := range result {
+			// A wallet that is both staker and withdrawer matches both filters.
+			if seen[account.Pubkey] {
+				continue
+			}
+			seen[account.Pubkey] = true
+			stakes = append(stakes, stakeAccount(account, epoch))
+		}
+	}
+	sort.Slice(stakes, func(i, j int) bool { return stakes[i].Balance > stakes[j].Balance })
+
+	if len(stakes) > 0 && rewardEpochs > 0 {
+		if err := addStakeRewards(ctx, stakes, epoch, rewardEpochs); err != nil {
+			return stakes, err
+		}
+	}
+	return stakes, nil
+}
+
+// stakeAccount converts a parsed stake account, working out its activation
+// state in epoch.
+func stakeAccount(account stakeProgramAccount, epoch uint64) types.StakeAccount {
+	info := account.Account.Data.Parsed.Info
+	stake := types.StakeAccount{
+		Address:    account.Pubkey,
+		Staker:     info.Meta.Authorized.Staker,
+		Withdrawer: info.Meta.Authorized.Withdrawer,
+		State:      types.StakeStateInactive,
+		Balance:    float64(account.Account.Lamports) / solana.LamportsPerSol,
+	}
+	var active uint64
+	if account.Account.Data.Parsed.Type == "delegated" && info.Stake != nil {
+		delegation := info.Stake.Delegation
+		stake.Validator = delegation.Voter
+		stake.ActivationEpoch = delegation.ActivationEpoch
+		// An undeactivated delegation has the maximum u64 as its deactivation epoch.
+		if delegation.DeactivationEpoch != math.MaxUint64 {
+			stake.DeactivationEpoch = delegation.DeactivationEpoch
+		}
+		switch {
+		case delegation.ActivationEpoch == delegation.DeactivationEpoch:
+			// Deactivated in the epoch it was activated, it never became active.
+		case delegation.DeactivationEpoch < epoch:
+		case delegation.DeactivationEpoch == epoch:
+			stake.State = types.StakeStateDeactivating
+			active = delegation.Stake
+		case delegation.ActivationEpoch >= epoch:
+			stake.State = types.StakeStateActivating
+		default:
+			stake.State = types.StakeStateActive
+			active = delegation.Stake
+		}
+	}
+	active = min(active, account.Account.Lamports)
+	stake.ActiveStake = float64(active) / solana.LamportsPerSol
+	stake.InactiveStake = float64(account.Account.Lamports-active) / solana.LamportsPerSol
+	return stake
+}
+
+// addStakeRewards requests the inflation rewards of the epochs before epoch
+// in one batch and attaches them to stakes, newest epoch first.
+func addStakeRewards(ctx context.Context, stakes []types.StakeAccount, epoch uint64, rewardEpochs int) error {
+	addresses := make([]string, len(stakes))
+	for i, stake := range stakes {
+		addresses[i] = stake.Address
+	}
+	type inflationReward struct {
+		Epoch       uint64 `json:"epoch"`
+		Amount      uint64 `json:"amount"`
+		PostBalance uint64 `json:"postBalance"`
+		Commission  *int   `json:"commission"`
+	}
+	rewardEpochs = min(rewardEpochs, int(epoch))
+	results := make([][]*inflationReward, rewardEpochs)
+	calls := make([]BatchCall, rewardEpochs)
+	for i := range calls {
+		calls[i] = BatchCall{
+			Method: "getInflationReward",
+			Params: []interface{}{
+				addresses,
+				map[string]interface{}{"epoch": epoch - 1 - uint64(i)},
+			},
+			Result: &results[i],
+		}
+	}
+	if err := rpc.Batch(ctx, calls); err != nil {
+		return err
+	}
+	for i, call := range calls {
+		if call.Err != nil {
+			// Nodes without the epoch's blocks can't report its rewards.
+			if isPermanent(call.Err) {
+				continue
+			}
+			return fmt.Errorf("inflation rewards: %w", call.Err)
+		}
+		for j, reward := range results[i] {
+			if j >= len(stakes) || reward == nil {
+				continue
+			}
+			stakes[j].Rewards = append(stakes[j].Rewards, types.StakeReward{
+				Epoch:       reward.Epoch,
+				Amount:      float64(reward.Amount) / solana.LamportsPerSol,
+				PostBalance: float64(reward.PostBalance) / solana.LamportsPerSol,
+				Commission:  reward.Commission,
+			})
+		}
+	}
+	return nil
+}
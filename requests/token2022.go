@@ -0,0 +1,168 @@
+package requests
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"sol_test/solana"
+	"sol_test/types"
+	"time"
+)
+
+// secondsPerYear is the year length Token-2022 uses to accrue interest.
+const secondsPerYear = 60 * 60 * 24 * 365.24
+
+// maxMultipleAccounts is the most keys getMultipleAccounts accepts per call.
+const maxMultipleAccounts = 100
+
+// GetMintExtensions reads Token-2022 mints, in batched calls of up to
+// maxMultipleAccounts, and returns the extensions that affect valuation, keyed
+// by mint. Mints that aren't Token-2022 mints or have none of those
+// extensions are left out.
+func GetMintExtensions(ctx context.Context, mints []string) (map[string]types.TokenExtensions, error) {
+	extensions := make(map[string]types.TokenExtensions)
+	if len(mints) == 0 {
+		return extensions, nil
+	}
+	var chunks [][]string
+	for start := 0; start < len(mints); start += maxMultipleAccounts {
+		chunks = append(chunks, mints[start:min(start+maxMultipleAccounts, len(mints))])
+	}
+	results := make([]struct {
+		Value []*types.GetAccountInfoValue `json:"value"`
+	}, len(chunks))
+	calls := make([]BatchCall, len(chunks))
+	for i, chunk := range chunks {
+		calls[i] = BatchCall{
+			Method: "getMultipleAccounts",
+			Params: []interface{}{
+				chunk,
+				map[string]interface{}{
+					"encoding": "base64",
+				},
+			},
+			Result: &results[i],
+		}
+	}
+	if err := rpc.Batch(ctx, calls); err != nil {
+		return nil, err
+	}
+	values := make([]*types.GetAccountInfoValue, 0, len(mints))
+	for i, call := range calls {
+		if call.Err != nil {
+			return nil, fmt.Errorf("mints: %w", call.Err)
+		}
+		// Pad short answers so values stay aligned with mints.
+		value := results[i].Value[:min(len(results[i].Value), len(chunks[i]))]
+		values = append(values, value...)
+		values = append(values, make([]*types.GetAccountInfoValue, len(chunks[i])-len(value))...)
+	}
+
+	var epoch uint64
+	now := time.Now()
+	for i, value := range values {
+		if value == nil || value.Owner != solana.Token2022ProgramID || len(value.Data) == 0 {
+			continue
+		}
+		data, err := base64.StdEncoding.DecodeString(value.Data[0])
+		if err != nil {
+			return nil, fmt.Errorf("mint %s: %w", mints[i], err)
+		}
+		decoded, err := solana.DecodeMintExtensions(data)
+		if err != nil {
+			return nil, fmt.Errorf("mint %s: %w", mints[i], err)
+		}
+		// The newer fee schedule only applies from its epoch on.
+		if decoded.NewerTransferFee != nil && epoch == 0 {
+			if epoch, err = getEpoch(ctx); err != nil {
+				return nil, err
+			}
+		}
+		if ext, ok := tokenExtensions(decoded, epoch, now); ok {
+			extensions[mints[i]] = ext
+		}
+	}
+	return extensions, nil
+}
+
+// tokenExtensions keeps what affects valuation of a mint's extensions, with
+// the transfer fee schedule in effect at epoch and the scaled UI multiplier
+// in effect at now. ok is false when there is nothing to keep.
+func tokenExtensions(decoded solana.MintExtensions, epoch uint64, now time.Time) (ext types.TokenExtensions, ok bool) {
+	if fee := decoded.NewerTransferFee; fee != nil {
+		if epoch < fee.Epoch {
+			fee = decoded.OlderTransferFee
+		}
+		ext.TransferFeeBasisPoints = fee.BasisPoints
+		ext.MaximumTransferFee = fee.MaximumFee
+		ok = true
+	}
+	if interest := decoded.InterestBearing; interest != nil {
+		ext.InterestRate = interest.CurrentRate
+		ext.PreUpdateAverageRate = interest.PreUpdateAverageRate
+		ext.InterestInitializedAt = interest.InitializedAt
+		ext.InterestLastUpdatedAt = interest.LastUpdatedAt
+		ok = true
+	}
+	if scaled := decoded.ScaledUIAmount; scaled != nil {
+		ext.ScaledUIMultiplier = scaled.Multiplier
+		if scaled.NewMultiplierEffectiveAt > 0 && now.Unix() >= scaled.NewMultiplierEffectiveAt {
+			ext.ScaledUIMultiplier = scaled.NewMultiplier
+		}
+		ok = true
+	}
+	if decoded.MetadataAddress != "" {
+		ext.MetadataAddress = decoded.MetadataAddress
+		ok = true
+	}
+	if metadata := decoded.Metadata; metadata != nil {
+		ext.Metadata = &types.OnChainMetadata{
+			Name:            metadata.Name,
+			Symbol:          metadata.Symbol,
+			URI:             metadata.URI,
+			UpdateAuthority: metadata.UpdateAuthority,
+		}
+		ok = true
+	}
+	if decoded.NonTransferable {
+		ext.NonTransferable = true
+		ok = true
+	}
+	return ext, ok
+}
+
+// getEpoch returns the current epoch.
+func getEpoch(ctx context.Context) (uint64, error) {
+	var info struct {
+		Epoch uint64 `json:"epoch"`
+	}
+	if err := rpc.Call(ctx, "getEpochInfo", []interface{}{}, &info); err != nil {
+		return 0, err
+	}
+	return info.Epoch, nil
+}
+
+// Token2022Amount converts a raw amount to a UI amount, applying the interest
+// accrued by interest-bearing mints and the multiplier of scaled UI amount
+// mints at time now.
+func Token2022Amount(ext types.TokenExtensions, raw uint64, decimals int, now time.Time) float64 {
+	amount := float64(raw)
+	if ext.InterestInitializedAt > 0 {
+		// Interest compounds continuously, at the average rate up to the
+		// last rate update and at the current rate since.
+		before := float64(ext.PreUpdateAverageRate) * float64(ext.InterestLastUpdatedAt-ext.InterestInitializedAt)
+		after := float64(ext.InterestRate) * float64(now.Unix()-ext.InterestLastUpdatedAt)
+		amount *= math.Exp((before + after) / secondsPerYear / 10000)
+	}
+	if ext.ScaledUIMultiplier > 0 {
+		amount *= ext.ScaledUIMultiplier
+	}
+	return amount / math.Pow10(decimals)
+}
+
+// TransferFee returns the raw fee withheld when transferring raw tokens.
+func TransferFee(ext types.TokenExtensions, raw uint64) uint64 {
+	fee := uint64(math.Ceil(float64(raw) * float64(ext.TransferFeeBasisPoints) / 10000))
+	return min(fee, ext.MaximumTransferFee)
+}
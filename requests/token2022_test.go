@@ -0,0 +1,183 @@
+package requests
+
+import (
+	"context"
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"sol_test/solana"
+	"sol_test/types"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// mintStandIn answers getMultipleAccounts with the Token-2022 mint fixtures
+// of the solana package, keyed by mint, and getEpochInfo with epoch.
+type mintStandIn struct {
+	fixtures map[string]string
+	epoch    uint64
+}
+
+func (s mintStandIn) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	response := `{"epoch":` + strconv.FormatUint(s.epoch, 10) + `}`
+	if method == "getMultipleAccounts" {
+		var values []string
+		for _, mint := range params[0].([]string) {
+			fixture, ok := s.fixtures[mint]
+			if !ok {
+				values = append(values, "null")
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join("..", "solana", "testdata", fixture))
+			if err != nil {
+				return err
+			}
+			values = append(values, `{"data":["`+strings.TrimSpace(string(data))+`","base64"],"owner":"`+solana.Token2022ProgramID+`"}`)
+		}
+		response = `{"value":[` + strings.Join(values, ",") + `]}`
+	}
+	return json.Unmarshal([]byte(response), result)
+}
+
+func (s mintStandIn) Batch(ctx context.Context, calls []BatchCall) error {
+	for i := range calls {
+		calls[i].Err = s.Call(ctx, calls[i].Method, calls[i].Params, calls[i].Result)
+	}
+	return nil
+}
+
+func TestGetMintExtensions(t *testing.T) {
+	fixtures := map[string]string{
+		"FeeMint":      "token2022_transfer_fee.b64",
+		"InterestMint": "token2022_interest_bearing.b64",
+		"ScaledMint":   "token2022_scaled_ui_amount.b64",
+		"MetadataMint": "token2022_metadata.b64",
+		"BoundMint":    "token2022_non_transferable.b64",
+	}
+	tests := []struct {
+		epoch uint64
+		fee   types.TokenExtensions
+	}{
+		// The newer schedule takes over at its epoch, 700.
+		{699, types.TokenExtensions{TransferFeeBasisPoints: 100, MaximumTransferFee: 5_000_000}},
+		{700, types.TokenExtensions{TransferFeeBasisPoints: 250, MaximumTransferFee: 1_000_000}},
+	}
+	for _, test := range tests {
+		UseRPC(mintStandIn{fixtures: fixtures, epoch: test.epoch})
+		got, err := GetMintExtensions(context.Background(), []string{"FeeMint", "InterestMint", "ScaledMint", "MetadataMint", "BoundMint", "MissingMint"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 5 {
+			t.Errorf("epoch %d: extensions of %d mints, want 5", test.epoch, len(got))
+		}
+		if fee := got["FeeMint"]; fee != test.fee {
+			t.Errorf("epoch %d: fee = %+v, want %+v", test.epoch, fee, test.fee)
+		}
+	}
+
+	all := mintExtensions(t, fixtures)
+	interest := types.TokenExtensions{InterestRate: -200, PreUpdateAverageRate: 500, InterestInitializedAt: 1_700_000_000, InterestLastUpdatedAt: 1_710_000_000}
+	if ext := all["InterestMint"]; ext != interest {
+		t.Errorf("interest = %+v, want %+v", ext, interest)
+	}
+	metadata := all["MetadataMint"]
+	if metadata.MetadataAddress != "2b1kV6DkPAnxd5ixfnxCpjxmKwqjjaYmCZfHsFu24GXo" || metadata.Metadata == nil || metadata.Metadata.Symbol != "PGT" {
+		t.Errorf("metadata = %+v", metadata)
+	}
+	if !all["BoundMint"].NonTransferable {
+		t.Error("non-transferable mint not flagged")
+	}
+}
+
+// mintExtensions reads the extensions of the fixture mints.
+func mintExtensions(t *testing.T, fixtures map[string]string) map[string]types.TokenExtensions {
+	t.Helper()
+	UseRPC(mintStandIn{fixtures: fixtures})
+	mints := make([]string, 0, len(fixtures))
+	for mint := range fixtures {
+		mints = append(mints, mint)
+	}
+	extensions, err := GetMintExtensions(context.Background(), mints)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return extensions
+}
+
+func TestTokenExtensionsScaledMultiplier(t *testing.T) {
+	scaled := solana.MintExtensions{
+		ScaledUIAmount: &solana.ScaledUIAmountConfig{Multiplier: 1.5, NewMultiplierEffectiveAt: 1_760_000_000, NewMultiplier: 3},
+	}
+	tests := []struct {
+		now  int64
+		want float64
+	}{
+		{1_759_999_999, 1.5},
+		{1_760_000_000, 3},
+	}
+	for _, test := range tests {
+		ext, ok := tokenExtensions(scaled, 0, time.Unix(test.now, 0))
+		if !ok || ext.ScaledUIMultiplier != test.want {
+			t.Errorf("at %d: multiplier %v, want %v", test.now, ext.ScaledUIMultiplier, test.want)
+		}
+	}
+}
+
+func TestToken2022Amount(t *testing.T) {
+	const year = int64(secondsPerYear)
+	tests := []struct {
+		name     string
+		ext      types.TokenExtensions
+		raw      uint64
+		decimals int
+		now      int64
+		want     float64
+	}{
+		{"plain", types.TokenExtensions{}, 1_234_500, 2, 0, 12345},
+		{
+			// 5% a year for a year, compounding continuously.
+			"interest at the average rate", types.TokenExtensions{
+				PreUpdateAverageRate: 500, InterestInitializedAt: 1000, InterestLastUpdatedAt: 1000 + year,
+			},
+			1_000_000, 2, 1000 + year, 10000 * math.Exp(0.05),
+		},
+		{
+			// 5% for a year, then -2% for half a year.
+			"interest after a rate update", types.TokenExtensions{
+				PreUpdateAverageRate: 500, InterestRate: -200, InterestInitializedAt: 1000, InterestLastUpdatedAt: 1000 + year,
+			},
+			1_000_000, 2, 1000 + year + year/2, 10000 * math.Exp(0.05-0.01),
+		},
+		{"scaled", types.TokenExtensions{ScaledUIMultiplier: 1.5}, 2_000_000_000, 9, 0, 3},
+	}
+	for _, test := range tests {
+		got := Token2022Amount(test.ext, test.raw, test.decimals, time.Unix(test.now, 0))
+		if math.Abs(got-test.want) > 1e-6*test.want {
+			t.Errorf("%s: amount = %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestTransferFee(t *testing.T) {
+	ext := types.TokenExtensions{TransferFeeBasisPoints: 250, MaximumTransferFee: 1_000_000}
+	tests := []struct {
+		raw, want uint64
+	}{
+		{0, 0},
+		{1000, 25},
+		// Fees round up.
+		{1001, 26},
+		{40_000_000, 1_000_000},
+		// Capped at the maximum fee.
+		{1_000_000_000, 1_000_000},
+	}
+	for _, test := range tests {
+		if got := TransferFee(ext, test.raw); got != test.want {
+			t.Errorf("fee on %d = %d, want %d", test.raw, got, test.want)
+		}
+	}
+}
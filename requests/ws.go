@@ -0,0 +1,477 @@
+package requests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sol_test/types"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket connection timings. Pings keep the connection open through
+// proxies and detect dead peers faster than TCP does.
+const (
+	wsWriteTimeout = 10 * time.Second
+	wsPingInterval = 20 * time.Second
+	wsPongTimeout  = 60 * time.Second
+)
+
+// subscriptionBuffer is the number of notifications a subscription holds for
+// a slow reader before new ones are dropped.
+const subscriptionBuffer = 64
+
+// errWSClosed fails requests still waiting when the connection drops.
+var errWSClosed = errors.New("websocket connection closed")
+
+// WebSocketURL returns the pubsub URL of an HTTP RPC endpoint, which Solana
+// nodes serve on the same host with a ws or wss scheme.
+func WebSocketURL(endpoint string) string {
+	switch {
+	case strings.HasPrefix(endpoint, "https://"):
+		return "wss://" + strings.TrimPrefix(endpoint, "https://")
+	case strings.HasPrefix(endpoint, "http://"):
+		return "ws://" + strings.TrimPrefix(endpoint, "http://")
+	}
+	return endpoint
+}
+
+// WSClient is a client for the Solana pubsub WebSocket API. Run keeps one
+// connection open, redialing with backoff when it drops, and subscribes
+// every open Subscription again after each reconnect. Notifications sent
+// while disconnected are lost; OnConnect handlers let callers catch up.
+type WSClient struct {
+	url     string
+	header  http.Header
+	dialer  *websocket.Dialer
+	backoff Backoff
+
+	// writeMu serializes writes, which the websocket package requires.
+	writeMu sync.Mutex
+
+	mu        sync.Mutex
+	conn      *websocket.Conn
+	nextID    int64
+	pending   map[int64]*wsRequest
+	subs      map[*Subscription]struct{}
+	active    map[int64]*Subscription // by the node's subscription ID
+	onConnect []func()
+	stopped   bool
+}
+
+// wsRequest is a request waiting for its response. Subscribe requests carry
+// their subscription so it is registered before any notification is read.
+type wsRequest struct {
+	method string
+	sub    *Subscription
+	done   chan wsResult
+}
+
+type wsResult struct {
+	result json.RawMessage
+	err    error
+}
+
+// wsMessage is either a response, with an ID, or a notification.
+type wsMessage struct {
+	ID     *int64             `json:"id"`
+	Result json.RawMessage    `json:"result"`
+	Error  *types.SolanaError `json:"error"`
+	Method string             `json:"method"`
+	Params struct {
+		Result       json.RawMessage `json:"result"`
+		Subscription int64           `json:"subscription"`
+	} `json:"params"`
+}
+
+// NewWSClient creates a client for the WebSocket endpoint url. headers are
+// sent with every handshake, e.g. for API keys.
+func NewWSClient(url string, headers map[string]string) *WSClient {
+	header := make(http.Header)
+	for key, value := range headers {
+		header.Set(key, value)
+	}
+	return &WSClient{
+		url:     url,
+		header:  header,
+		dialer:  &websocket.Dialer{HandshakeTimeout: 10 * time.Second},
+		backoff: Backoff{Base: 500 * time.Millisecond, Max: 30 * time.Second},
+		pending: make(map[int64]*wsRequest),
+		subs:    make(map[*Subscription]struct{}),
+		active:  make(map[int64]*Subscription),
+	}
+}
+
+// OnConnect registers f to run, in its own goroutine, after every connection
+// is established and its subscriptions are restored.
+func (c *WSClient) OnConnect(f func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onConnect = append(c.onConnect, f)
+}
+
+// Connected reports whether the client is connected right now.
+func (c *WSClient) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn != nil
+}
+
+// Subscription delivers the notifications of one pubsub subscription.
+type Subscription struct {
+	client *WSClient
+	method string
+	params []interface{}
+	// once marks subscriptions the node cancels after their first
+	// notification, like signatureSubscribe.
+	once bool
+	c    chan json.RawMessage
+
+	// Guarded by client.mu. sending is the connection a subscribe request
+	// for it is in flight on, so a reconnect doesn't restore it twice.
+	id      int64
+	sending *websocket.Conn
+	closed  bool
+}
+
+// C returns the channel notification results are sent on. It is closed
+// when the subscription ends: on Unsubscribe, after the only notification
+// of a signature subscription, or when Run returns.
+func (s *Subscription) C() <-chan json.RawMessage {
+	return s.c
+}
+
+// AccountSubscribe notifies of every change to account's lamports or data.
+// Results are types.AccountNotification with jsonParsed values.
+func (c *WSClient) AccountSubscribe(ctx context.Context, account, commitment string) (*Subscription, error) {
+	config := map[string]interface{}{"encoding": "jsonParsed"}
+	if commitment != "" {
+		config["commitment"] = commitment
+	}
+	return c.subscribe(ctx, "accountSubscribe", []interface{}{account, config}, false)
+}
+
+// LogsSubscribe notifies of every transaction that mentions address.
+// Results are types.LogsNotification.
+func (c *WSClient) LogsSubscribe(ctx context.Context, mentions, commitment string) (*Subscription, error) {
+	params := []interface{}{map[string]interface{}{"mentions": []string{mentions}}}
+	if commitment != "" {
+		params = append(params, map[string]interface{}{"commitment": commitment})
+	}
+	return c.subscribe(ctx, "logsSubscribe", params, false)
+}
+
+// SignatureSubscribe notifies once signature reaches commitment, then ends.
+// The result is a types.SignatureNotification.
+func (c *WSClient) SignatureSubscribe(ctx context.Context, signature, commitment string) (*Subscription, error) {
+	params := []interface{}{signature}
+	if commitment != "" {
+		params = append(params, map[string]interface{}{"commitment": commitment})
+	}
+	return c.subscribe(ctx, "signatureSubscribe", params, true)
+}
+
+// subscribe registers the subscription and, when connected, sends it right
+// away. Errors from the node are returned; a connection failure isn't,
+// since the subscription is sent again once Run reconnects.
+func (c *WSClient) subscribe(ctx context.Context, method string, params []interface{}, once bool) (*Subscription, error) {
+	sub := &Subscription{
+		client: c,
+		method: method,
+		params: params,
+		once:   once,
+		c:      make(chan json.RawMessage, subscriptionBuffer),
+	}
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		return nil, errWSClosed
+	}
+	c.subs[sub] = struct{}{}
+	conn := c.conn
+	sub.sending = conn
+	c.mu.Unlock()
+	if conn == nil {
+		return sub, nil
+	}
+	_, err := c.request(ctx, conn, method, params, sub)
+	var rpcErr *types.SolanaError
+	if errors.As(err, &rpcErr) || ctx.Err() != nil {
+		c.mu.Lock()
+		c.end(sub)
+		c.mu.Unlock()
+		return nil, err
+	}
+	return sub, nil
+}
+
+// Unsubscribe ends the subscription and closes its channel.
+func (s *Subscription) Unsubscribe(ctx context.Context) error {
+	c := s.client
+	c.mu.Lock()
+	if s.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	id := s.id
+	c.end(s)
+	conn := c.conn
+	c.mu.Unlock()
+	if id == 0 || conn == nil {
+		return nil
+	}
+	_, err := c.request(ctx, conn, unsubscribeMethod(s.method), []interface{}{id}, nil)
+	return err
+}
+
+// end forgets the subscription and closes its channel. c.mu must be held.
+func (c *WSClient) end(s *Subscription) {
+	if s.closed {
+		return
+	}
+	s.closed = true
+	close(s.c)
+	delete(c.subs, s)
+	if s.id != 0 && c.active[s.id] == s {
+		delete(c.active, s.id)
+	}
+}
+
+// unsubscribeMethod maps e.g. accountSubscribe to accountUnsubscribe.
+func unsubscribeMethod(method string) string {
+	return strings.TrimSuffix(method, "Subscribe") + "Unsubscribe"
+}
+
+// request sends a JSON-RPC request over conn and waits for its response.
+// A subscribe request given up on stays pending, so that the subscription
+// the node opens anyway is cancelled when its ID arrives.
+func (c *WSClient) request(ctx context.Context, conn *websocket.Conn, method string, params []interface{}, sub *Subscription) (json.RawMessage, error) {
+	c.mu.Lock()
+	// Requests on a connection readLoop has given up on would never finish.
+	if c.conn != conn {
+		c.mu.Unlock()
+		return nil, fmt.Errorf("%s: %w", method, errWSClosed)
+	}
+	c.nextID++
+	id := c.nextID
+	req := &wsRequest{method: method, sub: sub, done: make(chan wsResult, 1)}
+	c.pending[id] = req
+	c.mu.Unlock()
+	forget := func() {
+		c.mu.Lock()
+		delete(c.pending, id)
+		c.mu.Unlock()
+	}
+
+	err := c.write(conn, types.RPCRequest{JsonRPC: "2.0", ID: id, Method: method, Params: params})
+	if err != nil {
+		forget()
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	select {
+	case <-ctx.Done():
+		if sub == nil {
+			forget()
+		}
+		return nil, ctx.Err()
+	case result := <-req.done:
+		return result.result, result.err
+	}
+}
+
+func (c *WSClient) write(conn *websocket.Conn, value interface{}) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return conn.WriteJSON(value)
+}
+
+// Run connects and stays connected until ctx is done, then closes every
+// subscription.
+func (c *WSClient) Run(ctx context.Context) {
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.stopped = true
+		for sub := range c.subs {
+			c.end(sub)
+		}
+	}()
+	attempt := 0
+	for {
+		connected, err := c.connect(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if connected {
+			attempt = 0
+		}
+		attempt++
+		delay := c.backoff.Delay(attempt, 0)
+		log.Warn("WebSocket disconnected, reconnecting", "url", c.url, "in", delay, "error", err)
+		if sleepContext(ctx, delay) != nil {
+			return
+		}
+	}
+}
+
+// connect dials, restores the subscriptions and serves the connection until
+// it fails. connected reports whether the dial succeeded.
+func (c *WSClient) connect(ctx context.Context) (connected bool, err error) {
+	conn, _, err := c.dialer.DialContext(ctx, c.url, c.header)
+	if err != nil {
+		return false, err
+	}
+	conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+	})
+	// conn is set before readLoop starts so that its failure always resets it.
+	c.mu.Lock()
+	c.conn = conn
+	subs := make([]*Subscription, 0, len(c.subs))
+	for sub := range c.subs {
+		if sub.id != 0 || sub.sending == conn {
+			continue
+		}
+		sub.sending = conn
+		subs = append(subs, sub)
+	}
+	handlers := c.onConnect
+	c.mu.Unlock()
+	readErr := make(chan error, 1)
+	go func() {
+		readErr <- c.readLoop(conn)
+	}()
+
+	restored := 0
+	for _, sub := range subs {
+		_, err := c.request(ctx, conn, sub.method, sub.params, sub)
+		var rpcErr *types.SolanaError
+		if errors.As(err, &rpcErr) {
+			log.Warn("Resubscribe rejected", "method", sub.method, "error", err)
+			c.mu.Lock()
+			c.end(sub)
+			c.mu.Unlock()
+			continue
+		}
+		if err != nil {
+			// The connection failed again; readLoop reports why.
+			break
+		}
+		restored++
+	}
+	log.Info("WebSocket connected", "url", c.url, "subscriptions", restored)
+	for _, f := range handlers {
+		go f()
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+			<-readErr
+			return true, ctx.Err()
+		case err := <-readErr:
+			conn.Close()
+			return true, err
+		case <-ping.C:
+			c.writeMu.Lock()
+			err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout))
+			c.writeMu.Unlock()
+			if err != nil {
+				// Closing makes readLoop fail, which ends the loop.
+				conn.Close()
+			}
+		}
+	}
+}
+
+// readLoop dispatches responses and notifications until reading fails, then
+// fails the pending requests and marks every subscription inactive.
+func (c *WSClient) readLoop(conn *websocket.Conn) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			c.mu.Lock()
+			if c.conn == conn {
+				c.conn = nil
+			}
+			for id, req := range c.pending {
+				req.done <- wsResult{err: errWSClosed}
+				delete(c.pending, id)
+			}
+			for sub := range c.subs {
+				sub.id = 0
+				sub.sending = nil
+			}
+			c.active = make(map[int64]*Subscription)
+			c.mu.Unlock()
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(wsPongTimeout))
+		var message wsMessage
+		if err := json.Unmarshal(data, &message); err != nil {
+			log.Warn("Invalid WebSocket message", "error", err)
+			continue
+		}
+		c.dispatch(conn, message)
+	}
+}
+
+func (c *WSClient) dispatch(conn *websocket.Conn, message wsMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if message.ID != nil {
+		req, ok := c.pending[*message.ID]
+		if !ok {
+			return
+		}
+		delete(c.pending, *message.ID)
+		if req.sub != nil {
+			req.sub.sending = nil
+		}
+		if message.Error != nil {
+			req.done <- wsResult{err: fmt.Errorf("%s: %w", req.method, message.Error)}
+			return
+		}
+		if req.sub != nil {
+			var id int64
+			if err := json.Unmarshal(message.Result, &id); err != nil {
+				req.done <- wsResult{err: fmt.Errorf("%s: failed to unmarshal subscription ID: %w", req.method, err)}
+				return
+			}
+			if req.sub.closed || req.sub.id != 0 {
+				// Unsubscribed or given up on while the request was in
+				// flight, or already subscribed by another request.
+				go c.request(context.Background(), conn, unsubscribeMethod(req.sub.method), []interface{}{id}, nil)
+			} else {
+				req.sub.id = id
+				c.active[id] = req.sub
+			}
+		}
+		req.done <- wsResult{result: message.Result}
+		return
+	}
+
+	sub, ok := c.active[message.Params.Subscription]
+	if !ok {
+		return
+	}
+	select {
+	case sub.c <- message.Params.Result:
+	default:
+		log.Warn("Subscription buffer full, dropping notification", "method", sub.method)
+	}
+	if sub.once {
+		c.end(sub)
+	}
+}
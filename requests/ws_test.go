@@ -0,0 +1,307 @@
+package requests
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sol_test/types"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// wsTestTimeout bounds every wait on the stand-in node.
+const wsTestTimeout = 5 * time.Second
+
+// pubsubRequest is a request the stand-in node received. Sub is the
+// subscription ID it answered a subscribe request with.
+type pubsubRequest struct {
+	conn    *websocket.Conn
+	request types.RPCRequest
+	sub     int64
+}
+
+// pubsubStandIn is a local pubsub endpoint. It answers subscribe requests
+// with increasing subscription IDs and unsubscribe requests with true, and
+// leaves the methods in silent unanswered.
+type pubsubStandIn struct {
+	*httptest.Server
+	silent   map[string]bool
+	requests chan pubsubRequest
+
+	writeMu sync.Mutex
+	nextSub atomic.Int64
+}
+
+func newPubsubStandIn(t *testing.T, silent ...string) *pubsubStandIn {
+	t.Helper()
+	s := &pubsubStandIn{silent: make(map[string]bool), requests: make(chan pubsubRequest, 64)}
+	for _, method := range silent {
+		s.silent[method] = true
+	}
+	upgrader := websocket.Upgrader{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			var request types.RPCRequest
+			if err := conn.ReadJSON(&request); err != nil {
+				return
+			}
+			received := pubsubRequest{conn: conn, request: request}
+			switch {
+			case s.silent[request.Method]:
+			case strings.HasSuffix(request.Method, "Unsubscribe"):
+				s.write(conn, map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": true})
+			case strings.HasSuffix(request.Method, "Subscribe"):
+				received.sub = s.nextSub.Add(1)
+				s.write(conn, map[string]interface{}{"jsonrpc": "2.0", "id": request.ID, "result": received.sub})
+			}
+			s.requests <- received
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *pubsubStandIn) write(conn *websocket.Conn, value interface{}) {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	conn.WriteJSON(value)
+}
+
+// notify sends result to subscription sub over conn.
+func (s *pubsubStandIn) notify(conn *websocket.Conn, method string, sub int64, result interface{}) {
+	s.write(conn, map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  map[string]interface{}{"result": result, "subscription": sub},
+	})
+}
+
+// next returns the next request for method, skipping others.
+func (s *pubsubStandIn) next(t *testing.T, method string) pubsubRequest {
+	t.Helper()
+	timeout := time.After(wsTestTimeout)
+	for {
+		select {
+		case received := <-s.requests:
+			if received.request.Method == method {
+				return received
+			}
+		case <-timeout:
+			t.Fatalf("no %s request", method)
+		}
+	}
+}
+
+// runWSClient runs a client of s until the test ends. setup, when not nil,
+// configures the client before it connects.
+func runWSClient(t *testing.T, s *pubsubStandIn, setup func(*WSClient)) *WSClient {
+	t.Helper()
+	client := NewWSClient(WebSocketURL(s.URL), nil)
+	client.backoff = Backoff{Base: 10 * time.Millisecond, Max: 50 * time.Millisecond}
+	if setup != nil {
+		setup(client)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		client.Run(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return client
+}
+
+// receive returns the next notification of sub.
+func receive(t *testing.T, sub *Subscription) json.RawMessage {
+	t.Helper()
+	select {
+	case result, ok := <-sub.C():
+		if !ok {
+			t.Fatal("subscription ended")
+		}
+		return result
+	case <-time.After(wsTestTimeout):
+		t.Fatal("no notification")
+	}
+	return nil
+}
+
+func TestWSResubscribesAfterReconnect(t *testing.T) {
+	node := newPubsubStandIn(t)
+	connects := make(chan struct{}, 2)
+	client := runWSClient(t, node, func(client *WSClient) {
+		client.OnConnect(func() { connects <- struct{}{} })
+	})
+
+	sub, err := client.AccountSubscribe(context.Background(), "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU", "confirmed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := node.next(t, "accountSubscribe")
+	node.notify(first.conn, "accountNotification", first.sub, map[string]interface{}{"value": 1})
+	if got := string(receive(t, sub)); got != `{"value":1}` {
+		t.Errorf("first notification = %s", got)
+	}
+
+	first.conn.Close()
+	second := node.next(t, "accountSubscribe")
+	if second.conn == first.conn || second.sub == first.sub {
+		t.Fatal("subscription wasn't restored on a new connection")
+	}
+	if params, _ := json.Marshal(second.request.Params); !strings.Contains(string(params), "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU") {
+		t.Errorf("resubscribed with params %s", params)
+	}
+	// Notifications for the old ID are no longer delivered.
+	node.notify(second.conn, "accountNotification", first.sub, map[string]interface{}{"value": 2})
+	node.notify(second.conn, "accountNotification", second.sub, map[string]interface{}{"value": 3})
+	if got := string(receive(t, sub)); got != `{"value":3}` {
+		t.Errorf("notification after reconnecting = %s", got)
+	}
+	for i := range 2 {
+		select {
+		case <-connects:
+		case <-time.After(wsTestTimeout):
+			t.Fatalf("OnConnect ran %d times, want 2", i)
+		}
+	}
+}
+
+func TestWSSignatureSubscriptionEndsAfterNotification(t *testing.T) {
+	node := newPubsubStandIn(t)
+	client := runWSClient(t, node, nil)
+
+	// Subscriptions made before the first connection are sent once it is up.
+	sub, err := client.SignatureSubscribe(context.Background(), "5h6xBEauJ3PK6SWCZ1PGjBvj8vDdWG3KpwATGy1ARAXFSDwt8GFXM7W5Ncn16wmqokgpiKRLuS83KUxyZyv2sUYv", "finalized")
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := node.next(t, "signatureSubscribe")
+	node.notify(request.conn, "signatureNotification", request.sub, map[string]interface{}{"value": map[string]interface{}{"err": nil}})
+	receive(t, sub)
+	select {
+	case result, ok := <-sub.C():
+		if ok {
+			t.Fatalf("second notification %s, want the channel closed", result)
+		}
+	case <-time.After(wsTestTimeout):
+		t.Fatal("channel not closed after the notification")
+	}
+	// The node cancels it, so there is nothing to unsubscribe.
+	if err := sub.Unsubscribe(context.Background()); err != nil {
+		t.Errorf("Unsubscribe = %v", err)
+	}
+}
+
+func TestWSPendingRequestsFailWhenConnectionDrops(t *testing.T) {
+	node := newPubsubStandIn(t, "slotSubscribe")
+	client := runWSClient(t, node, nil)
+
+	// Wait for the connection with a subscription the node answers.
+	if _, err := client.AccountSubscribe(context.Background(), "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU", ""); err != nil {
+		t.Fatal(err)
+	}
+	connected := node.next(t, "accountSubscribe")
+	client.mu.Lock()
+	conn := client.conn
+	client.mu.Unlock()
+	if conn == nil {
+		t.Fatal("client isn't connected")
+	}
+
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.request(context.Background(), conn, "slotSubscribe", nil, nil)
+		errs <- err
+	}()
+	node.next(t, "slotSubscribe")
+	connected.conn.Close()
+	select {
+	case err := <-errs:
+		if !errors.Is(err, errWSClosed) {
+			t.Errorf("pending request failed with %v, want errWSClosed", err)
+		}
+	case <-time.After(wsTestTimeout):
+		t.Fatal("pending request still waiting after the connection dropped")
+	}
+
+	// Requests on the dropped connection fail right away.
+	if _, err := client.request(context.Background(), conn, "slotSubscribe", nil, nil); !errors.Is(err, errWSClosed) {
+		t.Errorf("request on the dropped connection = %v, want errWSClosed", err)
+	}
+}
+
+func TestWSUnsubscribesWhenSubscribeIsAnsweredLate(t *testing.T) {
+	node := newPubsubStandIn(t, "accountSubscribe")
+	client := runWSClient(t, node, nil)
+	deadline := time.Now().Add(wsTestTimeout)
+	for !client.Connected() {
+		if time.Now().After(deadline) {
+			t.Fatal("client never connected")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() {
+		_, err := client.AccountSubscribe(ctx, "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU", "")
+		errs <- err
+	}()
+	request := node.next(t, "accountSubscribe")
+	cancel()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("subscribe = %v, want context.Canceled", err)
+	}
+
+	// The node opened the subscription anyway; its ID is unsubscribed.
+	node.write(request.conn, map[string]interface{}{"jsonrpc": "2.0", "id": request.request.ID, "result": 7})
+	unsubscribe := node.next(t, "accountUnsubscribe")
+	if params, _ := json.Marshal(unsubscribe.request.Params); string(params) != "[7]" {
+		t.Errorf("unsubscribed %s, want [7]", params)
+	}
+}
+
+func TestWSUnsubscribesDuplicateSubscriptions(t *testing.T) {
+	node := newPubsubStandIn(t)
+	client := runWSClient(t, node, nil)
+
+	sub, err := client.AccountSubscribe(context.Background(), "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := node.next(t, "accountSubscribe")
+	// Subscribe it again, as a restore racing with the first request would.
+	client.mu.Lock()
+	conn := client.conn
+	client.mu.Unlock()
+	if _, err := client.request(context.Background(), conn, sub.method, sub.params, sub); err != nil {
+		t.Fatal(err)
+	}
+	second := node.next(t, "accountSubscribe")
+	unsubscribe := node.next(t, "accountUnsubscribe")
+	if params, _ := json.Marshal(unsubscribe.request.Params); string(params) != "["+strconv.FormatInt(second.sub, 10)+"]" {
+		t.Errorf("unsubscribed %s, want the duplicate %d", params, second.sub)
+	}
+
+	// Notifications keep coming through the first subscription.
+	node.notify(first.conn, "accountNotification", first.sub, map[string]interface{}{"value": 1})
+	if got := string(receive(t, sub)); got != `{"value":1}` {
+		t.Errorf("notification = %s", got)
+	}
+}
@@ -0,0 +1,368 @@
+// Package scheduler rescans tracked wallets in the background so the API can
+// serve their latest snapshot right away.
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sol_test/requests"
+	"sol_test/storage"
+	"sol_test/types"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/charmbracelet/log"
+)
+
+// ErrInvalidWallet is returned by Add for wallets that can't be tracked.
+var ErrInvalidWallet = errors.New("invalid tracked wallet")
+
+// ScanFunc scans the wallet and persists the result.
+type ScanFunc func(ctx context.Context, address string) error
+
+// Config controls how often and how fast wallets are rescanned.
+type Config struct {
+	// Interval is the time between scans of wallets without their own interval.
+	Interval time.Duration
+	// MinInterval is the shortest interval a wallet may ask for.
+	MinInterval time.Duration
+	// Jitter spreads each wallet's next scan by up to this fraction of its
+	// interval either way, so wallets added together drift apart.
+	Jitter float64
+	// Workers is the number of wallets scanned at once.
+	Workers int
+	// Timeout bounds a single scan.
+	Timeout time.Duration
+	// RequestsPerSecond and Burst are the RPC budget shared by every
+	// background scan, on top of the global rate limit.
+	RequestsPerSecond float64
+	Burst             int
+}
+
+// LoadConfig returns the default configuration overridden by the SYNC_INTERVAL,
+// SYNC_MIN_INTERVAL and SYNC_TIMEOUT (Go durations), SYNC_JITTER, SYNC_WORKERS,
+// SYNC_RPS and SYNC_BURST environment variables.
+func LoadConfig() (Config, error) {
+	config := Config{
+		Interval:          15 * time.Minute,
+		MinInterval:       time.Minute,
+		Jitter:            0.1,
+		Workers:           4,
+		Timeout:           5 * time.Minute,
+		RequestsPerSecond: 5,
+		Burst:             50,
+	}
+	for key, target := range map[string]*time.Duration{
+		"SYNC_INTERVAL":     &config.Interval,
+		"SYNC_MIN_INTERVAL": &config.MinInterval,
+		"SYNC_TIMEOUT":      &config.Timeout,
+	} {
+		if raw := os.Getenv(key); raw != "" {
+			value, err := time.ParseDuration(raw)
+			if err != nil || value <= 0 {
+				return config, fmt.Errorf("invalid %s %q", key, raw)
+			}
+			*target = value
+		}
+	}
+	for key, target := range map[string]*int{
+		"SYNC_WORKERS": &config.Workers,
+		"SYNC_BURST":   &config.Burst,
+	} {
+		if raw := os.Getenv(key); raw != "" {
+			value, err := strconv.Atoi(raw)
+			if err != nil || value <= 0 {
+				return config, fmt.Errorf("invalid %s %q", key, raw)
+			}
+			*target = value
+		}
+	}
+	for key, target := range map[string]*float64{
+		"SYNC_JITTER": &config.Jitter,
+		"SYNC_RPS":    &config.RequestsPerSecond,
+	} {
+		if raw := os.Getenv(key); raw != "" {
+			value, err := strconv.ParseFloat(raw, 64)
+			if err != nil || value < 0 {
+				return config, fmt.Errorf("invalid %s %q", key, raw)
+			}
+			*target = value
+		}
+	}
+	config.Jitter = min(config.Jitter, 1)
+	return config, nil
+}
+
+// entry is a tracked wallet and its scheduling state. rescan is set when
+// the wallet is added again while it is being scanned, so the scan in
+// progress doesn't postpone the one asked for.
+type entry struct {
+	wallet  types.TrackedWallet
+	running bool
+	rescan  bool
+}
+
+// Scheduler keeps the tracked wallets and rescans each one when it is due.
+// Due wallets are scanned highest priority first, then most overdue first.
+type Scheduler struct {
+	config  Config
+	store   storage.Repository
+	scan    ScanFunc
+	budget  *requests.RateLimiter
+	backoff requests.Backoff
+	logger  *log.Logger
+	now     func() time.Time
+
+	mu      sync.Mutex
+	wallets map[string]*entry
+	wake    chan struct{}
+}
+
+// New loads the tracked wallets from store. Wallets whose scan came due
+// while the process was down are scanned as soon as Run starts.
+func New(config Config, store storage.Repository, scan ScanFunc) (*Scheduler, error) {
+	tracked, err := store.TrackedWallets()
+	if err != nil {
+		return nil, err
+	}
+	s := &Scheduler{
+		config:  config,
+		store:   store,
+		scan:    scan,
+		budget:  requests.NewRateLimiter(config.RequestsPerSecond, config.Burst),
+		backoff: requests.Backoff{Base: time.Minute, Max: config.Interval},
+		logger: log.NewWithOptions(os.Stderr, log.Options{
+			ReportTimestamp: true,
+			Prefix:          "Scheduler ",
+		}),
+		now:     time.Now,
+		wallets: make(map[string]*entry, len(tracked)),
+		wake:    make(chan struct{}, 1),
+	}
+	for _, wallet := range tracked {
+		s.wallets[wallet.Address] = &entry{wallet: wallet}
+	}
+	return s, nil
+}
+
+// Add starts tracking the wallet, or updates the interval and priority of
+// an already tracked one, and schedules a scan right away.
+func (s *Scheduler) Add(wallet types.TrackedWallet) (types.TrackedWallet, error) {
+	if wallet.Interval < 0 || (wallet.Interval > 0 && time.Duration(wallet.Interval)*time.Second < s.config.MinInterval) {
+		return wallet, fmt.Errorf("%w: interval must be at least %s", ErrInvalidWallet, s.config.MinInterval)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if e, ok := s.wallets[wallet.Address]; ok {
+		e.wallet.Interval = wallet.Interval
+		e.wallet.Priority = wallet.Priority
+		e.wallet.NextScan = now
+		e.rescan = e.running
+		wallet = e.wallet
+	} else {
+		wallet = types.TrackedWallet{
+			Address:  wallet.Address,
+			Interval: wallet.Interval,
+			Priority: wallet.Priority,
+			AddedAt:  now,
+			NextScan: now,
+		}
+		s.wallets[wallet.Address] = &entry{wallet: wallet}
+	}
+	if err := s.store.SaveTrackedWallet(wallet); err != nil {
+		return wallet, err
+	}
+	s.notify()
+	return wallet, nil
+}
+
+// Remove stops tracking the wallet. A scan in progress finishes but its
+// result no longer updates the registry.
+func (s *Scheduler) Remove(address string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.wallets[address]; !ok {
+		return storage.ErrNotFound
+	}
+	delete(s.wallets, address)
+	return s.store.DeleteTrackedWallet(address)
+}
+
+// Tracked returns the wallet's registry entry.
+func (s *Scheduler) Tracked(address string) (types.TrackedWallet, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.wallets[address]
+	if !ok {
+		return types.TrackedWallet{}, false
+	}
+	return e.wallet, true
+}
+
+// Wallets returns every tracked wallet, highest priority first.
+func (s *Scheduler) Wallets() []types.TrackedWallet {
+	s.mu.Lock()
+	wallets := make([]types.TrackedWallet, 0, len(s.wallets))
+	for _, e := range s.wallets {
+		wallets = append(wallets, e.wallet)
+	}
+	s.mu.Unlock()
+	sort.Slice(wallets, func(i, j int) bool {
+		if wallets[i].Priority != wallets[j].Priority {
+			return wallets[i].Priority > wallets[j].Priority
+		}
+		return wallets[i].Address < wallets[j].Address
+	})
+	return wallets
+}
+
+// Budget returns the RPC budget of background scans, for other background
+// work to share.
+func (s *Scheduler) Budget() *requests.RateLimiter {
+	return s.budget
+}
+
+// Run scans due wallets with up to Config.Workers scans at once until ctx is
+// done, then waits for the scans in progress to stop.
+func (s *Scheduler) Run(ctx context.Context) {
+	s.logger.Info("Scheduler started", "wallets", len(s.Wallets()), "workers", s.config.Workers)
+	done := make(chan struct{}, s.config.Workers)
+	running := 0
+	var scans sync.WaitGroup
+	defer scans.Wait()
+	for {
+		s.mu.Lock()
+		due, wait := s.due(s.now(), s.config.Workers-running)
+		for _, e := range due {
+			e.running = true
+		}
+		s.mu.Unlock()
+		for _, e := range due {
+			running++
+			scans.Add(1)
+			go func() {
+				defer scans.Done()
+				s.scanWallet(ctx, e)
+				done <- struct{}{}
+			}()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-done:
+			running--
+		case <-s.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// due returns up to slots wallets to scan now and how long to wait for the
+// next one otherwise. s.mu must be held.
+func (s *Scheduler) due(now time.Time, slots int) ([]*entry, time.Duration) {
+	var due []*entry
+	wait := s.config.Interval
+	for _, e := range s.wallets {
+		if e.running {
+			continue
+		}
+		if until := e.wallet.NextScan.Sub(now); until > 0 {
+			wait = min(wait, until)
+			continue
+		}
+		due = append(due, e)
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if due[i].wallet.Priority != due[j].wallet.Priority {
+			return due[i].wallet.Priority > due[j].wallet.Priority
+		}
+		return due[i].wallet.NextScan.Before(due[j].wallet.NextScan)
+	})
+	if len(due) > slots {
+		// The rest wait for a worker to finish, which wakes Run.
+		due = due[:max(slots, 0)]
+	}
+	return due, wait
+}
+
+// scanWallet scans one wallet within the background budget and schedules
+// its next scan: an interval later on success, or after a backoff that
+// grows with consecutive failures up to the interval. A wallet added again
+// during the scan is due right away instead.
+func (s *Scheduler) scanWallet(ctx context.Context, e *entry) {
+	s.mu.Lock()
+	address := e.wallet.Address
+	s.mu.Unlock()
+
+	scanCtx, cancel := context.WithTimeout(requests.WithBudget(ctx, s.budget), s.config.Timeout)
+	start := s.now()
+	err := s.scan(scanCtx, address)
+	cancel()
+	if ctx.Err() != nil {
+		s.mu.Lock()
+		e.running = false
+		s.mu.Unlock()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.running = false
+	now := s.now()
+	interval := s.interval(e.wallet)
+	if err != nil {
+		e.wallet.Failures++
+		e.wallet.LastError = err.Error()
+		e.wallet.NextScan = now.Add(min(s.backoff.Delay(e.wallet.Failures, 0), interval))
+		s.logger.Warn("Scan failed", "address", address, "failures", e.wallet.Failures, "error", err)
+	} else {
+		e.wallet.Failures = 0
+		e.wallet.LastError = ""
+		e.wallet.LastScan = now
+		e.wallet.NextScan = now.Add(s.jitter(interval))
+		s.logger.Info("Scanned wallet", "address", address, "duration", now.Sub(start))
+	}
+	if e.rescan {
+		e.rescan = false
+		e.wallet.NextScan = now
+	}
+	// A wallet removed during the scan stays removed.
+	if s.wallets[address] != e {
+		return
+	}
+	if err := s.store.SaveTrackedWallet(e.wallet); err != nil {
+		s.logger.Warn("Failed to store tracked wallet", "address", address, "error", err)
+	}
+}
+
+// interval returns the wallet's scan interval.
+func (s *Scheduler) interval(wallet types.TrackedWallet) time.Duration {
+	if wallet.Interval > 0 {
+		return time.Duration(wallet.Interval) * time.Second
+	}
+	return s.config.Interval
+}
+
+// jitter moves d by a random amount of up to Config.Jitter of it either way.
+func (s *Scheduler) jitter(d time.Duration) time.Duration {
+	spread := float64(d) * s.config.Jitter
+	return d + time.Duration((rand.Float64()*2-1)*spread)
+}
+
+// notify wakes Run to pick up a newly due wallet.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
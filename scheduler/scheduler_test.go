@@ -0,0 +1,304 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sol_test/requests"
+	"sol_test/storage"
+	"sol_test/types"
+	"sync"
+	"testing"
+	"time"
+)
+
+const testAddress = "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU"
+
+// fakeClock is a clock that only moves when told to.
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// countingCaller answers every call with nothing and counts them.
+type countingCaller struct {
+	mu    sync.Mutex
+	calls int
+}
+
+func (c *countingCaller) Call(ctx context.Context, method string, params []interface{}, result interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.calls++
+	return nil
+}
+
+func (c *countingCaller) Batch(ctx context.Context, calls []requests.BatchCall) error {
+	return c.Call(ctx, "", nil, nil)
+}
+
+var testConfig = Config{
+	Interval:          15 * time.Minute,
+	MinInterval:       time.Minute,
+	Workers:           2,
+	Timeout:           time.Minute,
+	RequestsPerSecond: 5,
+	Burst:             50,
+}
+
+// newTestScheduler returns a scheduler on a fresh store, with a fake clock
+// and scans that run scan.
+func newTestScheduler(t *testing.T, config Config, scan func(s *Scheduler, ctx context.Context) error) (*Scheduler, *fakeClock, storage.Repository) {
+	t.Helper()
+	store, err := storage.OpenBolt(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	var s *Scheduler
+	s, err = New(config, store, func(ctx context.Context, address string) error {
+		return scan(s, ctx)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)}
+	s.now = clock.Now
+	return s, clock, store
+}
+
+func TestScanWallet(t *testing.T) {
+	errScan := errors.New("node unavailable")
+	tests := []struct {
+		name     string
+		config   func(*Config)
+		wallet   types.TrackedWallet
+		failures int
+		// scan runs as the wallet's scan; the clock starts at start.
+		scan func(s *Scheduler, clock *fakeClock, ctx context.Context) error
+		// want checks the wallet after the scan, at time end.
+		want func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time)
+	}{
+		{
+			name: "success schedules the next scan an interval later",
+			scan: func(s *Scheduler, clock *fakeClock, ctx context.Context) error {
+				clock.Advance(10 * time.Second)
+				return nil
+			},
+			want: func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time) {
+				if !wallet.LastScan.Equal(end) || !wallet.NextScan.Equal(end.Add(15*time.Minute)) {
+					t.Errorf("last scan %v, next %v, want %v and 15m later", wallet.LastScan, wallet.NextScan, end)
+				}
+			},
+		},
+		{
+			name:   "success uses the wallet's own interval",
+			wallet: types.TrackedWallet{Interval: 120},
+			scan: func(s *Scheduler, clock *fakeClock, ctx context.Context) error {
+				return nil
+			},
+			want: func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time) {
+				if !wallet.NextScan.Equal(end.Add(2 * time.Minute)) {
+					t.Errorf("next scan %v, want 2m after %v", wallet.NextScan, end)
+				}
+			},
+		},
+		{
+			name:     "success resets the failures",
+			failures: 3,
+			scan: func(s *Scheduler, clock *fakeClock, ctx context.Context) error {
+				return nil
+			},
+			want: func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time) {
+				if wallet.Failures != 0 || wallet.LastError != "" {
+					t.Errorf("failures %d, last error %q after a success", wallet.Failures, wallet.LastError)
+				}
+			},
+		},
+		{
+			name:     "failure backs off with consecutive failures",
+			failures: 1,
+			scan: func(s *Scheduler, clock *fakeClock, ctx context.Context) error {
+				return errScan
+			},
+			want: func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time) {
+				// The second failure in a row waits 2m, jittered down to half.
+				if wait := wallet.NextScan.Sub(end); wait < time.Minute || wait > 2*time.Minute {
+					t.Errorf("backoff %v, want between 1m and 2m", wait)
+				}
+				if wallet.Failures != 2 || wallet.LastError != errScan.Error() {
+					t.Errorf("failures %d, last error %q", wallet.Failures, wallet.LastError)
+				}
+			},
+		},
+		{
+			name:     "backoff is capped by the interval",
+			wallet:   types.TrackedWallet{Interval: 60},
+			failures: 9,
+			scan: func(s *Scheduler, clock *fakeClock, ctx context.Context) error {
+				return errScan
+			},
+			want: func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time) {
+				if !wallet.NextScan.Equal(end.Add(time.Minute)) {
+					t.Errorf("next scan %v, want the 1m interval after %v", wallet.NextScan, end)
+				}
+			},
+		},
+		{
+			name: "scan runs within the background budget",
+			config: func(config *Config) {
+				config.RequestsPerSecond = 0.001
+				config.Burst = 2
+				config.Timeout = 50 * time.Millisecond
+			},
+			scan: func(s *Scheduler, clock *fakeClock, ctx context.Context) error {
+				caller := &countingCaller{}
+				rpc := requests.WithRateLimit(caller, requests.NewRateLimiter(1000, 1000))
+				for range 3 {
+					if err := rpc.Call(ctx, "getSlot", nil, nil); err != nil {
+						if caller.calls != 2 {
+							t.Errorf("%d calls before running out of budget, want 2", caller.calls)
+						}
+						return err
+					}
+				}
+				return nil
+			},
+			want: func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time) {
+				if wallet.Failures != 1 {
+					t.Error("scan beyond the budget didn't time out")
+				}
+			},
+		},
+		{
+			name: "adding during a scan rescans right after it",
+			scan: func(s *Scheduler, clock *fakeClock, ctx context.Context) error {
+				if _, err := s.Add(types.TrackedWallet{Address: testAddress, Priority: 5}); err != nil {
+					t.Fatal(err)
+				}
+				clock.Advance(time.Minute)
+				return nil
+			},
+			want: func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time) {
+				if !wallet.NextScan.Equal(end) || !wallet.LastScan.Equal(end) {
+					t.Errorf("next scan %v, want now (%v)", wallet.NextScan, end)
+				}
+				if wallet.Priority != 5 {
+					t.Errorf("priority %d, want the added 5", wallet.Priority)
+				}
+			},
+		},
+		{
+			name: "removing during a scan keeps the wallet removed",
+			scan: func(s *Scheduler, clock *fakeClock, ctx context.Context) error {
+				if err := s.Remove(testAddress); err != nil {
+					t.Fatal(err)
+				}
+				return nil
+			},
+			want: func(t *testing.T, wallet types.TrackedWallet, tracked bool, end time.Time) {
+				if tracked {
+					t.Error("wallet tracked again after its scan")
+				}
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			config := testConfig
+			if test.config != nil {
+				test.config(&config)
+			}
+			var clock *fakeClock
+			s, clock, store := newTestScheduler(t, config, func(s *Scheduler, ctx context.Context) error {
+				return test.scan(s, clock, ctx)
+			})
+			wallet := test.wallet
+			wallet.Address = testAddress
+			if _, err := s.Add(wallet); err != nil {
+				t.Fatal(err)
+			}
+			e := s.wallets[testAddress]
+			e.wallet.Failures = test.failures
+			e.running = true
+
+			s.scanWallet(context.Background(), e)
+			if e.running {
+				t.Error("wallet still marked running")
+			}
+			tracked, ok := s.Tracked(testAddress)
+			test.want(t, tracked, ok, clock.Now())
+
+			// The registry is stored as the scheduler sees it.
+			stored, err := store.TrackedWallets()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !ok && len(stored) != 0 {
+				t.Errorf("removed wallet stored: %+v", stored)
+			}
+			if ok && (len(stored) != 1 || !stored[0].NextScan.Equal(tracked.NextScan) || stored[0].Failures != tracked.Failures) {
+				t.Errorf("stored %+v, want %+v", stored, tracked)
+			}
+		})
+	}
+}
+
+func TestDue(t *testing.T) {
+	now := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	wallets := []struct {
+		address  string
+		priority int
+		next     time.Duration // relative to now
+		running  bool
+	}{
+		{"old", 0, -time.Hour, false},
+		{"recent", 0, -time.Minute, false},
+		{"urgent", 9, -time.Second, false},
+		{"busy", 9, -time.Hour, true},
+		{"soon", 9, 5 * time.Minute, false},
+		{"later", 0, 20 * time.Minute, false},
+	}
+	tests := []struct {
+		name  string
+		slots int
+		want  []string
+		wait  time.Duration
+	}{
+		{"priority first, then most overdue", 4, []string{"urgent", "old", "recent"}, 5 * time.Minute},
+		{"limited by free workers", 2, []string{"urgent", "old"}, 5 * time.Minute},
+		{"no free workers", 0, nil, 5 * time.Minute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, _, _ := newTestScheduler(t, testConfig, func(*Scheduler, context.Context) error { return nil })
+			for _, w := range wallets {
+				s.wallets[w.address] = &entry{
+					wallet:  types.TrackedWallet{Address: w.address, Priority: w.priority, NextScan: now.Add(w.next)},
+					running: w.running,
+				}
+			}
+			due, wait := s.due(now, test.slots)
+			var got []string
+			for _, e := range due {
+				got = append(got, e.wallet.Address)
+			}
+			if !reflect.DeepEqual(got, test.want) || wait != test.wait {
+				t.Errorf("due %v, wait %v, want %v and %v", got, wait, test.want, test.wait)
+			}
+		})
+	}
+}
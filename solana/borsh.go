@@ -0,0 +1,71 @@
+package solana
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+)
+
+// borshReader reads Borsh encoded values in sequence, remembering the first
+// out of range read.
+type borshReader struct {
+	data   []byte
+	offset int
+	err    error
+}
+
+func (r *borshReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || r.offset+n > len(r.data) {
+		r.err = fmt.Errorf("%d bytes at offset %d out of range (%d bytes)", n, r.offset, len(r.data))
+		return nil
+	}
+	b := r.data[r.offset : r.offset+n]
+	r.offset += n
+	return b
+}
+
+func (r *borshReader) u8() uint8 {
+	if b := r.next(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *borshReader) u16() uint16 {
+	if b := r.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *borshReader) u32() uint32 {
+	if b := r.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *borshReader) u64() uint64 {
+	if b := r.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *borshReader) pubkey() string {
+	if b := r.next(32); b != nil {
+		return EncodeBase58(b)
+	}
+	return ""
+}
+
+func (r *borshReader) string() string {
+	n := r.u32()
+	if b := r.next(int(n)); b != nil {
+		return strings.TrimRight(string(b), "\x00")
+	}
+	return ""
+}
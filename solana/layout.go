@@ -0,0 +1,35 @@
+package solana
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/big"
+)
+
+// ReadPubkey returns the base58 public key stored at offset.
+func ReadPubkey(data []byte, offset int) (string, error) {
+	if offset < 0 || offset+32 > len(data) {
+		return "", fmt.Errorf("public key at offset %d out of range (%d bytes)", offset, len(data))
+	}
+	return EncodeBase58(data[offset : offset+32]), nil
+}
+
+// ReadUint64 returns the little endian u64 stored at offset.
+func ReadUint64(data []byte, offset int) (uint64, error) {
+	if offset < 0 || offset+8 > len(data) {
+		return 0, fmt.Errorf("u64 at offset %d out of range (%d bytes)", offset, len(data))
+	}
+	return binary.LittleEndian.Uint64(data[offset:]), nil
+}
+
+// ReadUint128 returns the little endian u128 stored at offset.
+func ReadUint128(data []byte, offset int) (*big.Int, error) {
+	if offset < 0 || offset+16 > len(data) {
+		return nil, fmt.Errorf("u128 at offset %d out of range (%d bytes)", offset, len(data))
+	}
+	be := make([]byte, 16)
+	for i := range be {
+		be[i] = data[offset+15-i]
+	}
+	return new(big.Int).SetBytes(be), nil
+}
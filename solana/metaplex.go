@@ -0,0 +1,114 @@
+package solana
+
+import "fmt"
+
+// TokenMetadataProgramID is the Metaplex Token Metadata program.
+const TokenMetadataProgramID = "metaqbxxUerdq28cj1RbAWkYQm3ybzjb6a8bt518x1s"
+
+// metadataDataOffset is where the Borsh encoded data starts in a metadata
+// account, after the key byte, the update authority and the mint.
+const metadataDataOffset = 1 + 32 + 32
+
+// Creator is a creator listed in Metaplex metadata. Share is a percentage.
+type Creator struct {
+	Address  string
+	Verified bool
+	Share    int
+}
+
+// Metadata is the decoded prefix of a Metaplex metadata account.
+type Metadata struct {
+	UpdateAuthority      string
+	Mint                 string
+	Name                 string
+	Symbol               string
+	URI                  string
+	SellerFeeBasisPoints int
+	Creators             []Creator
+	// TokenStandard is named as by the DAS API, e.g. "NonFungible", and
+	// empty for accounts created before token standards existed.
+	TokenStandard string
+	// Collection is the mint of the collection the token belongs to, set
+	// only when the collection authority verified it.
+	Collection string
+}
+
+// tokenStandards names the TokenStandard enum of Metaplex metadata.
+var tokenStandards = []string{
+	"NonFungible",
+	"FungibleAsset",
+	"Fungible",
+	"NonFungibleEdition",
+	"ProgrammableNonFungible",
+	"ProgrammableNonFungibleEdition",
+}
+
+// MetadataAddress returns the Metaplex metadata account of mint.
+func MetadataAddress(mint string) (string, error) {
+	program, err := DecodeBase58(TokenMetadataProgramID)
+	if err != nil {
+		return "", err
+	}
+	mintKey, err := DecodeBase58(mint)
+	if err != nil {
+		return "", err
+	}
+	address, _, err := FindProgramAddress([][]byte{[]byte("metadata"), program, mintKey}, TokenMetadataProgramID)
+	return address, err
+}
+
+// DecodeMetadata decodes a Metaplex metadata account. Strings are stored
+// padded with NUL bytes, which are trimmed. Accounts of older versions end
+// before the token standard and collection, which are left empty.
+func DecodeMetadata(data []byte) (Metadata, error) {
+	var m Metadata
+	var err error
+	if m.UpdateAuthority, err = ReadPubkey(data, 1); err != nil {
+		return m, err
+	}
+	if m.Mint, err = ReadPubkey(data, 33); err != nil {
+		return m, err
+	}
+
+	r := borshReader{data: data, offset: metadataDataOffset}
+	m.Name = r.string()
+	m.Symbol = r.string()
+	m.URI = r.string()
+	m.SellerFeeBasisPoints = int(r.u16())
+	if r.u8() == 1 {
+		count := r.u32()
+		for i := uint32(0); i < count && r.err == nil; i++ {
+			creator := Creator{Address: r.pubkey()}
+			creator.Verified = r.u8() == 1
+			creator.Share = int(r.u8())
+			m.Creators = append(m.Creators, creator)
+		}
+	}
+	if r.err != nil {
+		return Metadata{}, fmt.Errorf("metadata: %w", r.err)
+	}
+
+	r.next(2) // primary sale happened, is mutable
+	if r.u8() == 1 {
+		r.next(1) // edition nonce
+	}
+	var standard string
+	if r.u8() == 1 {
+		if kind := int(r.u8()); kind < len(tokenStandards) {
+			standard = tokenStandards[kind]
+		}
+	}
+	var collection string
+	if r.u8() == 1 {
+		verified := r.u8() == 1
+		key := r.pubkey()
+		if verified {
+			collection = key
+		}
+	}
+	if r.err == nil {
+		m.TokenStandard = standard
+		m.Collection = collection
+	}
+	return m, nil
+}
@@ -0,0 +1,131 @@
+package solana
+
+import "testing"
+
+func TestMetadataAddress(t *testing.T) {
+	// The metadata account of USDC on mainnet.
+	address, err := MetadataAddress("EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "5x38Kp4hvdomTCnCrAny4UtMUt5rQBdB6px2K1Ui45Wq"; address != want {
+		t.Errorf("MetadataAddress = %s, want %s", address, want)
+	}
+}
+
+func TestFindProgramAddress(t *testing.T) {
+	// Pump.fun's global state account.
+	address, _, err := FindProgramAddress([][]byte{[]byte("global")}, "6EF8rrecthR5Dkzon8Nwu78hRvfCKubJ14M5uBEwF6P")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "4wTV1YmiEkRvAtNtsSGPtUrqRYQMe5SKy2uB4Jjaxnjf"; address != want {
+		t.Errorf("FindProgramAddress = %s, want %s", address, want)
+	}
+}
+
+func TestIsOnCurve(t *testing.T) {
+	tests := []struct {
+		address string
+		want    bool
+	}{
+		// Keypair addresses are points on the curve.
+		{"EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v", true},
+		{"7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU", true},
+		// Program derived addresses never are.
+		{"5x38Kp4hvdomTCnCrAny4UtMUt5rQBdB6px2K1Ui45Wq", false},
+		{"4wTV1YmiEkRvAtNtsSGPtUrqRYQMe5SKy2uB4Jjaxnjf", false},
+	}
+	for _, test := range tests {
+		key, err := DecodeBase58(test.address)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := isOnCurve(key); got != test.want {
+			t.Errorf("isOnCurve(%s) = %v, want %v", test.address, got, test.want)
+		}
+	}
+}
+
+func TestDecodeMetadata(t *testing.T) {
+	m, err := DecodeMetadata(loadAccount(t, "metadata_usdc.b64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Mint != "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v" || m.UpdateAuthority != "2wmVCSfPxGPjrnMMn7rchp4uaeoTqN39mXFC2zhPdri9" {
+		t.Errorf("mint %s, update authority %s", m.Mint, m.UpdateAuthority)
+	}
+	// Names and symbols are stored NUL padded to a fixed length.
+	if m.Name != "USD Coin" || m.Symbol != "USDC" || m.URI != "" {
+		t.Errorf("name %q, symbol %q, uri %q", m.Name, m.Symbol, m.URI)
+	}
+	if m.SellerFeeBasisPoints != 0 || m.Creators != nil {
+		t.Errorf("seller fee %d, creators %v; want none", m.SellerFeeBasisPoints, m.Creators)
+	}
+	if m.TokenStandard != "Fungible" || m.Collection != "" {
+		t.Errorf("token standard %q, collection %q", m.TokenStandard, m.Collection)
+	}
+}
+
+func TestDecodeMetadataCollection(t *testing.T) {
+	tests := []struct {
+		fixture    string
+		collection string
+	}{
+		{"metadata_nft.b64", "HHunKySupezBgYLixnB5mPdu4Xw4MayciAp7bgReyUNL"},
+		// Anyone can claim a collection; only verified ones count.
+		{"metadata_nft_unverified.b64", ""},
+	}
+	for _, test := range tests {
+		m, err := DecodeMetadata(loadAccount(t, test.fixture))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if m.Mint != "3SnGEvwDf8zfdasKa185pLYiR9z67oy25oKLrd99zoMn" || m.Name != "Test Lad #42" {
+			t.Errorf("%s: mint %s, name %q", test.fixture, m.Mint, m.Name)
+		}
+		if m.TokenStandard != "ProgrammableNonFungible" || m.Collection != test.collection {
+			t.Errorf("%s: token standard %q, collection %q, want ProgrammableNonFungible and %q", test.fixture, m.TokenStandard, m.Collection, test.collection)
+		}
+	}
+
+	// Accounts written before collections existed end after the creators.
+	data := loadAccount(t, "metadata_nft.b64")
+	m, err := DecodeMetadata(data[:metadataDataOffset+4+32+4+10+4+200+2+1+4+34])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.TokenStandard != "" || m.Collection != "" {
+		t.Errorf("old account: token standard %q, collection %q, want none", m.TokenStandard, m.Collection)
+	}
+}
+
+func TestDecodeMetadataCreators(t *testing.T) {
+	m, err := DecodeMetadata(loadAccount(t, "metadata_bonk.b64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Name != "Bonk" || m.URI != "https://arweave.net/hQiPZOsRZXGXBJd_82PhVdlM_hACsT_q6wqwf5cSY7I" || m.SellerFeeBasisPoints != 500 {
+		t.Errorf("name %q, uri %q, seller fee %d", m.Name, m.URI, m.SellerFeeBasisPoints)
+	}
+	want := []Creator{
+		{Address: "9AhKqLR67hwapvG8SA2JFXaCshXc9nALJjpKaHZrsbkw", Verified: true, Share: 40},
+		{Address: "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU", Verified: false, Share: 60},
+	}
+	if len(m.Creators) != len(want) {
+		t.Fatalf("creators = %v, want %v", m.Creators, want)
+	}
+	for i := range want {
+		if m.Creators[i] != want[i] {
+			t.Errorf("creator %d = %+v, want %+v", i, m.Creators[i], want[i])
+		}
+	}
+}
+
+func TestDecodeMetadataTruncated(t *testing.T) {
+	data := loadAccount(t, "metadata_bonk.b64")
+	// Cut inside the creators list.
+	if _, err := DecodeMetadata(data[:metadataDataOffset+4+32+4+10+4+200+2+1+4+20]); err == nil {
+		t.Error("decoded truncated metadata")
+	}
+}
@@ -0,0 +1,73 @@
+package solana
+
+import (
+	"crypto/sha256"
+	"errors"
+	"math/big"
+)
+
+// ErrNoProgramAddress is returned when no bump seed yields an address off the curve.
+var ErrNoProgramAddress = errors.New("unable to find a valid program address")
+
+// curveP is the ed25519 field prime 2^255 - 19.
+var curveP = new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), 255), big.NewInt(19))
+
+// curveD is the ed25519 curve constant -121665/121666 mod p.
+var curveD = func() *big.Int {
+	d := new(big.Int).ModInverse(big.NewInt(121666), curveP)
+	d.Mul(d, big.NewInt(-121665))
+	return d.Mod(d, curveP)
+}()
+
+// FindProgramAddress derives the program derived address (PDA) of seeds
+// under programID, trying bump seeds from 255 down like the Solana SDK.
+func FindProgramAddress(seeds [][]byte, programID string) (string, uint8, error) {
+	program, err := DecodeBase58(programID)
+	if err != nil {
+		return "", 0, err
+	}
+	for bump := 255; bump >= 0; bump-- {
+		hash := sha256.New()
+		for _, seed := range seeds {
+			hash.Write(seed)
+		}
+		hash.Write([]byte{byte(bump)})
+		hash.Write(program)
+		hash.Write([]byte("ProgramDerivedAddress"))
+		address := hash.Sum(nil)
+		if !isOnCurve(address) {
+			return EncodeBase58(address), uint8(bump), nil
+		}
+	}
+	return "", 0, ErrNoProgramAddress
+}
+
+// isOnCurve reports whether b decompresses to an ed25519 point, accepting
+// non-canonical encodings like curve25519-dalek does. A point exists when
+// x^2 = (y^2 - 1) / (d*y^2 + 1) has a solution mod p.
+func isOnCurve(b []byte) bool {
+	// The encoding is little endian with the sign of x in the top bit.
+	le := make([]byte, 32)
+	for i := range le {
+		le[i] = b[31-i]
+	}
+	le[0] &= 0x7f
+	y := new(big.Int).SetBytes(le)
+	y.Mod(y, curveP)
+
+	y2 := new(big.Int).Mul(y, y)
+	y2.Mod(y2, curveP)
+	u := new(big.Int).Sub(y2, big.NewInt(1))
+	u.Mod(u, curveP)
+	v := new(big.Int).Mul(curveD, y2)
+	v.Add(v, big.NewInt(1))
+	v.Mod(v, curveP)
+	if v.Sign() == 0 {
+		return u.Sign() == 0
+	}
+
+	x2 := new(big.Int).ModInverse(v, curveP)
+	x2.Mul(x2, u)
+	x2.Mod(x2, curveP)
+	return x2.Sign() == 0 || big.Jacobi(x2, curveP) == 1
+}
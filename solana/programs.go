@@ -0,0 +1,21 @@
+package solana
+
+// Well known program and mint addresses.
+const (
+	SystemProgramID    = "11111111111111111111111111111111"
+	TokenProgramID     = "TokenkegQfeZyiNwAJbNbGKPFXCWuBvf9Ss623VQ5DA"
+	Token2022ProgramID = "TokenzQdBNbLqP5VEhdkAS6EPFLC1PHnBqCXEpPxuEb"
+	StakeProgramID     = "Stake11111111111111111111111111111111111111"
+	// NativeMint is the wrapped SOL mint, also used to label native SOL movements.
+	NativeMint = "So11111111111111111111111111111111111111112"
+	USDCMint   = "EPjFWdd5AufqSSqeM2qN1xzybapC8G4wEGGkZwyTDt1v"
+	USDTMint   = "Es9vMFrzaCERmJfrF4H2FYD4KCoNkY11McCe8BenwNYB"
+)
+
+// IsStablecoin reports whether mint is a USD stablecoin.
+func IsStablecoin(mint string) bool {
+	return mint == USDCMint || mint == USDTMint
+}
+
+// LamportsPerSol is the number of lamports in one SOL.
+const LamportsPerSol = 1_000_000_000
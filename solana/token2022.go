@@ -0,0 +1,150 @@
+package solana
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// Token-2022 mint account layout: the 82 byte base mint is padded to the
+// length of a token account, then an account type byte is followed by the
+// extensions, each a u16 type and a u16 length before its data.
+const (
+	mintBaseLength      = 82
+	tokenAccountLength  = 165
+	accountTypeMint     = 1
+	extensionsOffset    = tokenAccountLength + 1
+	extensionHeaderSize = 4
+)
+
+// Token-2022 extension types the mint decoder reads.
+const (
+	extensionTransferFeeConfig     = 1
+	extensionNonTransferable       = 9
+	extensionInterestBearingConfig = 10
+	extensionMetadataPointer       = 18
+	extensionTokenMetadata         = 19
+	extensionScaledUIAmount        = 25
+)
+
+// TransferFeeSchedule is one of the two fee schedules of a transfer fee
+// config. It applies from Epoch on.
+type TransferFeeSchedule struct {
+	Epoch       uint64
+	MaximumFee  uint64
+	BasisPoints int
+}
+
+// InterestBearingConfig is the state of an interest-bearing mint. Rates are
+// yearly, in basis points.
+type InterestBearingConfig struct {
+	InitializedAt        int64
+	PreUpdateAverageRate int
+	LastUpdatedAt        int64
+	CurrentRate          int
+}
+
+// ScaledUIAmountConfig is the state of a scaled UI amount mint. NewMultiplier
+// replaces Multiplier from NewMultiplierEffectiveAt on.
+type ScaledUIAmountConfig struct {
+	Multiplier               float64
+	NewMultiplierEffectiveAt int64
+	NewMultiplier            float64
+}
+
+// MintExtensions are the extensions of a Token-2022 mint that affect how its
+// tokens are valued and labelled. Nil fields are extensions the mint lacks.
+type MintExtensions struct {
+	OlderTransferFee *TransferFeeSchedule
+	NewerTransferFee *TransferFeeSchedule
+	InterestBearing  *InterestBearingConfig
+	ScaledUIAmount   *ScaledUIAmountConfig
+	MetadataAddress  string
+	// Metadata is the token metadata stored in the mint itself.
+	Metadata        *Metadata
+	NonTransferable bool
+}
+
+// DecodeMintExtensions decodes the extensions of a Token-2022 mint account.
+// Mints without extensions are only 82 bytes long and have none; other
+// extensions are skipped.
+func DecodeMintExtensions(data []byte) (MintExtensions, error) {
+	var ext MintExtensions
+	if len(data) <= mintBaseLength {
+		return ext, nil
+	}
+	if len(data) < extensionsOffset || data[tokenAccountLength] != accountTypeMint {
+		return ext, fmt.Errorf("not a Token-2022 mint (%d bytes)", len(data))
+	}
+	for offset := extensionsOffset; offset+extensionHeaderSize <= len(data); {
+		kind := binary.LittleEndian.Uint16(data[offset:])
+		length := int(binary.LittleEndian.Uint16(data[offset+2:]))
+		start := offset + extensionHeaderSize
+		if start+length > len(data) {
+			return ext, fmt.Errorf("extension %d of %d bytes at offset %d out of range (%d bytes)", kind, length, start, len(data))
+		}
+		// Unused space after the last extension is zeroed.
+		if kind == 0 {
+			break
+		}
+		r := borshReader{data: data[start : start+length]}
+		switch kind {
+		case extensionTransferFeeConfig:
+			// Skip the fee config and withdraw authorities and the withheld amount.
+			r.next(32 + 32 + 8)
+			ext.OlderTransferFee = r.transferFee()
+			ext.NewerTransferFee = r.transferFee()
+		case extensionNonTransferable:
+			ext.NonTransferable = true
+		case extensionInterestBearingConfig:
+			r.next(32) // rate authority
+			ext.InterestBearing = &InterestBearingConfig{
+				InitializedAt:        int64(r.u64()),
+				PreUpdateAverageRate: int(int16(r.u16())),
+				LastUpdatedAt:        int64(r.u64()),
+				CurrentRate:          int(int16(r.u16())),
+			}
+		case extensionMetadataPointer:
+			r.next(32) // authority
+			ext.MetadataAddress = r.optionalPubkey()
+		case extensionTokenMetadata:
+			metadata := &Metadata{UpdateAuthority: r.optionalPubkey(), Mint: r.pubkey()}
+			metadata.Name = r.string()
+			metadata.Symbol = r.string()
+			metadata.URI = r.string()
+			ext.Metadata = metadata
+		case extensionScaledUIAmount:
+			r.next(32) // authority
+			ext.ScaledUIAmount = &ScaledUIAmountConfig{
+				Multiplier:               math.Float64frombits(r.u64()),
+				NewMultiplierEffectiveAt: int64(r.u64()),
+				NewMultiplier:            math.Float64frombits(r.u64()),
+			}
+		}
+		if r.err != nil {
+			return ext, fmt.Errorf("extension %d: %w", kind, r.err)
+		}
+		offset = start + length
+	}
+	return ext, nil
+}
+
+func (r *borshReader) transferFee() *TransferFeeSchedule {
+	return &TransferFeeSchedule{
+		Epoch:       r.u64(),
+		MaximumFee:  r.u64(),
+		BasisPoints: int(r.u16()),
+	}
+}
+
+// optionalPubkey reads a Token-2022 optional public key, which is all zeros
+// when unset.
+func (r *borshReader) optionalPubkey() string {
+	b := r.next(32)
+	for _, c := range b {
+		if c != 0 {
+			return EncodeBase58(b)
+		}
+	}
+	return ""
+}
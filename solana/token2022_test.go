@@ -0,0 +1,87 @@
+package solana
+
+import (
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// loadAccount reads base64 account data from testdata.
+func loadAccount(t *testing.T, name string) []byte {
+	t.Helper()
+	encoded, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return data
+}
+
+func TestDecodeMintExtensions(t *testing.T) {
+	// Fixtures are mints with the authority 7xKX...sgAsU, each followed by
+	// its extensions in the Token-2022 layout.
+	tests := []struct {
+		fixture string
+		want    MintExtensions
+	}{
+		{"token2022_transfer_fee.b64", MintExtensions{
+			OlderTransferFee: &TransferFeeSchedule{Epoch: 500, MaximumFee: 5_000_000, BasisPoints: 100},
+			NewerTransferFee: &TransferFeeSchedule{Epoch: 700, MaximumFee: 1_000_000, BasisPoints: 250},
+		}},
+		{"token2022_interest_bearing.b64", MintExtensions{
+			InterestBearing: &InterestBearingConfig{
+				InitializedAt:        1_700_000_000,
+				PreUpdateAverageRate: 500,
+				LastUpdatedAt:        1_710_000_000,
+				CurrentRate:          -200,
+			},
+		}},
+		{"token2022_scaled_ui_amount.b64", MintExtensions{
+			ScaledUIAmount: &ScaledUIAmountConfig{Multiplier: 1.5, NewMultiplierEffectiveAt: 1_760_000_000, NewMultiplier: 3},
+		}},
+		{"token2022_metadata.b64", MintExtensions{
+			MetadataAddress: "2b1kV6DkPAnxd5ixfnxCpjxmKwqjjaYmCZfHsFu24GXo",
+			Metadata: &Metadata{
+				UpdateAuthority: "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU",
+				Mint:            "2b1kV6DkPAnxd5ixfnxCpjxmKwqjjaYmCZfHsFu24GXo",
+				Name:            "Paxos Gold Test",
+				Symbol:          "PGT",
+				URI:             "https://example.com/pgt.json",
+			},
+		}},
+		// A permanent delegate is skipped and zeroed padding ends the list.
+		{"token2022_non_transferable.b64", MintExtensions{NonTransferable: true}},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			ext, err := DecodeMintExtensions(loadAccount(t, test.fixture))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(ext, test.want) {
+				t.Errorf("extensions = %+v, want %+v", ext, test.want)
+			}
+		})
+	}
+}
+
+func TestDecodeMintExtensionsRejectsBadLayouts(t *testing.T) {
+	data := loadAccount(t, "token2022_transfer_fee.b64")
+	if ext, err := DecodeMintExtensions(data[:mintBaseLength]); err != nil || !reflect.DeepEqual(ext, MintExtensions{}) {
+		t.Errorf("mint without extensions = %+v, %v", ext, err)
+	}
+	if _, err := DecodeMintExtensions(data[:len(data)-1]); err == nil {
+		t.Error("decoded a truncated extension")
+	}
+	account := append([]byte(nil), data...)
+	account[tokenAccountLength] = 2
+	if _, err := DecodeMintExtensions(account); err == nil {
+		t.Error("decoded a token account as a mint")
+	}
+}
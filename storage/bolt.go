@@ -0,0 +1,411 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sol_test/types"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Top level buckets. Each holds one nested bucket per wallet address or mint,
+// except trackedBucket, failedBucket and cursorsBucket which map addresses to
+// their TrackedWallet, failed signatures and SyncCursor.
+var (
+	walletsBucket      = []byte("wallets")
+	transactionsBucket = []byte("transactions")
+	pricesBucket       = []byte("prices")
+	trackedBucket      = []byte("tracked")
+	failedBucket       = []byte("failed")
+	cursorsBucket      = []byte("cursors")
+)
+
+// BoltRepository is a Repository in a single bbolt file. Keys start with a
+// big endian slot or timestamp so that cursors walk them in time order.
+type BoltRepository struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens or creates the database at path.
+func OpenBolt(path string) (*BoltRepository, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{walletsBucket, transactionsBucket, pricesBucket, trackedBucket, failedBucket, cursorsBucket} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltRepository{db: db}, nil
+}
+
+func (r *BoltRepository) Close() error {
+	return r.db.Close()
+}
+
+// timeKey encodes the first 8 bytes of a key.
+func timeKey(value int64, suffix []byte) []byte {
+	key := make([]byte, 8+len(suffix))
+	binary.BigEndian.PutUint64(key, uint64(value))
+	copy(key[8:], suffix)
+	return key
+}
+
+// put JSON encodes value into the nested bucket name of parent.
+func put(tx *bolt.Tx, parent []byte, name string, key []byte, value interface{}) error {
+	bucket, err := tx.Bucket(parent).CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, encoded)
+}
+
+func (r *BoltRepository) SaveWallet(wallet types.MyWallet) error {
+	wallet.Transactions = nil
+	key := timeKey(wallet.Slot, binary.BigEndian.AppendUint64(nil, uint64(wallet.LastUpdated.UnixNano())))
+	return r.db.Update(func(tx *bolt.Tx) error {
+		latest, err := stripLatestWallet(tx, wallet.Address, key)
+		if err != nil {
+			return err
+		}
+		if !latest {
+			wallet.Activity, wallet.Swaps = nil, nil
+		}
+		return put(tx, walletsBucket, wallet.Address, key, wallet)
+	})
+}
+
+// stripLatestWallet drops the activity and swaps of the wallet's latest
+// snapshot when a newer one, stored under key, is saved, and reports whether
+// the new one is the latest. Activity and swaps grow with the wallet's
+// history and can be rebuilt from its stored transactions, so only the
+// snapshot that is served keeps them.
+func stripLatestWallet(tx *bolt.Tx, address string, key []byte) (bool, error) {
+	bucket := tx.Bucket(walletsBucket).Bucket([]byte(address))
+	if bucket == nil {
+		return true, nil
+	}
+	last, value := bucket.Cursor().Last()
+	if last == nil {
+		return true, nil
+	}
+	if bytes.Compare(last, key) > 0 {
+		return false, nil
+	}
+	var previous types.MyWallet
+	if err := json.Unmarshal(value, &previous); err != nil {
+		return false, err
+	}
+	if previous.Activity == nil && previous.Swaps == nil {
+		return true, nil
+	}
+	previous.Activity, previous.Swaps = nil, nil
+	encoded, err := json.Marshal(previous)
+	if err != nil {
+		return false, err
+	}
+	return true, bucket.Put(last, encoded)
+}
+
+func (r *BoltRepository) LatestWallet(address string) (types.MyWallet, error) {
+	var wallet types.MyWallet
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(walletsBucket).Bucket([]byte(address))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		_, value := bucket.Cursor().Last()
+		if value == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(value, &wallet)
+	})
+	return wallet, err
+}
+
+func (r *BoltRepository) Wallets(address string, from, to time.Time) ([]types.MyWallet, error) {
+	var wallets []types.MyWallet
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(walletsBucket).Bucket([]byte(address))
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(_, value []byte) error {
+			var wallet types.MyWallet
+			if err := json.Unmarshal(value, &wallet); err != nil {
+				return err
+			}
+			if (from.IsZero() || !wallet.LastUpdated.Before(from)) && (to.IsZero() || !wallet.LastUpdated.After(to)) {
+				wallets = append(wallets, wallet)
+			}
+			return nil
+		})
+	})
+	return wallets, err
+}
+
+func (r *BoltRepository) SaveTransactions(address string, transactions []types.TransactionResponse) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		for _, transaction := range transactions {
+			if transaction.Result == nil || len(transaction.Result.Transaction.Signatures) == 0 {
+				continue
+			}
+			key := timeKey(int64(transaction.Result.Slot), []byte(transaction.Result.Transaction.Signatures[0]))
+			if err := put(tx, transactionsBucket, address, key, transaction); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (r *BoltRepository) Transactions(address string) ([]types.TransactionResponse, error) {
+	var transactions []types.TransactionResponse
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(transactionsBucket).Bucket([]byte(address))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		for key, value := cursor.Last(); key != nil; key, value = cursor.Prev() {
+			var transaction types.TransactionResponse
+			if err := json.Unmarshal(value, &transaction); err != nil {
+				return fmt.Errorf("transaction %s: %w", key[8:], err)
+			}
+			transactions = append(transactions, transaction)
+		}
+		return nil
+	})
+	return transactions, err
+}
+
+// LatestSignature returns the signature of the newest stored transaction.
+// Transactions sharing the newest slot are ordered by signature, which is
+// arbitrary but stable. Using it as an Until cursor fetches the slot's other
+// transactions again, so callers dedupe by signature.
+func (r *BoltRepository) LatestSignature(address string) (string, error) {
+	var signature string
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(transactionsBucket).Bucket([]byte(address))
+		if bucket == nil {
+			return ErrNotFound
+		}
+		key, _ := bucket.Cursor().Last()
+		if key == nil {
+			return ErrNotFound
+		}
+		signature = string(key[8:])
+		return nil
+	})
+	return signature, err
+}
+
+func (r *BoltRepository) UpdateFailedSignatures(address string, fetched []string, failed []types.FailedSignature, maxAttempts int) ([]types.FailedSignature, error) {
+	var dropped []types.FailedSignature
+	err := r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(failedBucket)
+		var stored []types.FailedSignature
+		if value := bucket.Get([]byte(address)); value != nil {
+			if err := json.Unmarshal(value, &stored); err != nil {
+				return err
+			}
+		}
+		removed := make(map[string]bool, len(fetched))
+		for _, signature := range fetched {
+			removed[signature] = true
+		}
+		index := make(map[string]int, len(stored))
+		var kept []types.FailedSignature
+		for _, entry := range stored {
+			if !removed[entry.Signature] {
+				index[entry.Signature] = len(kept)
+				kept = append(kept, entry)
+			}
+		}
+		for _, entry := range failed {
+			if i, ok := index[entry.Signature]; ok {
+				entry.Attempts += kept[i].Attempts
+				kept[i] = entry
+				continue
+			}
+			index[entry.Signature] = len(kept)
+			kept = append(kept, entry)
+		}
+		outstanding := kept[:0]
+		for _, entry := range kept {
+			if entry.Permanent || entry.Attempts >= maxAttempts {
+				dropped = append(dropped, entry)
+				continue
+			}
+			outstanding = append(outstanding, entry)
+		}
+
+		if len(outstanding) == 0 {
+			return bucket.Delete([]byte(address))
+		}
+		encoded, err := json.Marshal(outstanding)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(address), encoded)
+	})
+	return dropped, err
+}
+
+func (r *BoltRepository) FailedSignatures(address string) ([]types.FailedSignature, error) {
+	var failed []types.FailedSignature
+	err := r.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(failedBucket).Get([]byte(address))
+		if value == nil {
+			return nil
+		}
+		return json.Unmarshal(value, &failed)
+	})
+	return failed, err
+}
+
+func (r *BoltRepository) SaveSyncCursor(address string, cursor SyncCursor) error {
+	if cursor == (SyncCursor{}) {
+		return r.db.Update(func(tx *bolt.Tx) error {
+			return tx.Bucket(cursorsBucket).Delete([]byte(address))
+		})
+	}
+	encoded, err := json.Marshal(cursor)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(cursorsBucket).Put([]byte(address), encoded)
+	})
+}
+
+func (r *BoltRepository) SyncCursor(address string) (SyncCursor, error) {
+	var cursor SyncCursor
+	err := r.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(cursorsBucket).Get([]byte(address))
+		if value == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(value, &cursor)
+	})
+	return cursor, err
+}
+
+// Price history kept per mint: one point per priceResolution at most, for
+// priceRetention. Every scan records the prices of its tokens, so without
+// these the history of popular mints would grow with every request.
+const (
+	priceResolution = 5 * time.Minute
+	priceRetention  = 180 * 24 * time.Hour
+)
+
+func (r *BoltRepository) SavePrices(at time.Time, quotes map[string]types.PriceQuote) error {
+	key := timeKey(at.UnixNano(), nil)
+	cutoff := timeKey(at.Add(-priceRetention).UnixNano(), nil)
+	return r.db.Update(func(tx *bolt.Tx) error {
+		for mint, quote := range quotes {
+			bucket, err := tx.Bucket(pricesBucket).CreateBucketIfNotExists([]byte(mint))
+			if err != nil {
+				return err
+			}
+			if last, _ := bucket.Cursor().Last(); last != nil {
+				since := time.Duration(at.UnixNano() - int64(binary.BigEndian.Uint64(last)))
+				if since < priceResolution && since > -priceResolution {
+					continue
+				}
+			}
+			if err := put(tx, pricesBucket, mint, key, PricePoint{Time: at, Quote: quote}); err != nil {
+				return err
+			}
+			var expired [][]byte
+			cursor := bucket.Cursor()
+			for old, _ := cursor.First(); old != nil && bytes.Compare(old, cutoff) < 0; old, _ = cursor.Next() {
+				expired = append(expired, old)
+			}
+			for _, old := range expired {
+				if err := bucket.Delete(old); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (r *BoltRepository) Prices(mint string, from, to time.Time) ([]PricePoint, error) {
+	var points []PricePoint
+	err := r.db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(pricesBucket).Bucket([]byte(mint))
+		if bucket == nil {
+			return nil
+		}
+		cursor := bucket.Cursor()
+		key, value := cursor.First()
+		if !from.IsZero() {
+			key, value = cursor.Seek(timeKey(from.UnixNano(), nil))
+		}
+		var end []byte
+		if !to.IsZero() {
+			end = timeKey(to.UnixNano(), nil)
+		}
+		for ; key != nil && (end == nil || bytes.Compare(key, end) <= 0); key, value = cursor.Next() {
+			var point PricePoint
+			if err := json.Unmarshal(value, &point); err != nil {
+				return err
+			}
+			points = append(points, point)
+		}
+		return nil
+	})
+	return points, err
+}
+
+func (r *BoltRepository) SaveTrackedWallet(wallet types.TrackedWallet) error {
+	encoded, err := json.Marshal(wallet)
+	if err != nil {
+		return err
+	}
+	return r.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(trackedBucket).Put([]byte(wallet.Address), encoded)
+	})
+}
+
+func (r *BoltRepository) DeleteTrackedWallet(address string) error {
+	return r.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(trackedBucket)
+		if bucket.Get([]byte(address)) == nil {
+			return ErrNotFound
+		}
+		return bucket.Delete([]byte(address))
+	})
+}
+
+func (r *BoltRepository) TrackedWallets() ([]types.TrackedWallet, error) {
+	var wallets []types.TrackedWallet
+	err := r.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(trackedBucket).ForEach(func(key, value []byte) error {
+			var wallet types.TrackedWallet
+			if err := json.Unmarshal(value, &wallet); err != nil {
+				return fmt.Errorf("tracked wallet %s: %w", key, err)
+			}
+			wallets = append(wallets, wallet)
+			return nil
+		})
+	})
+	return wallets, err
+}
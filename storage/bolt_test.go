@@ -0,0 +1,121 @@
+package storage
+
+import (
+	"errors"
+	"path/filepath"
+	"reflect"
+	"sol_test/types"
+	"testing"
+	"time"
+)
+
+func openTestBolt(t *testing.T) *BoltRepository {
+	t.Helper()
+	repo, err := OpenBolt(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.Close() })
+	return repo
+}
+
+const testAddress = "7xKXtg2CW87d97TXJSDpbD5jBkheTqA83TZRuJosgAsU"
+
+func TestUpdateFailedSignatures(t *testing.T) {
+	repo := openTestBolt(t)
+	dropped, err := repo.UpdateFailedSignatures(testAddress, nil, []types.FailedSignature{
+		{Signature: "a", Attempts: 5, Error: "timeout"},
+		{Signature: "b", Attempts: 5, Error: "timeout"},
+		{Signature: "c", Attempts: 1, Error: "transaction not available", Permanent: true},
+	}, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 || dropped[0].Signature != "c" {
+		t.Errorf("dropped %+v, want the permanent failure", dropped)
+	}
+
+	// b was fetched, a failed again and d is new; attempts add up.
+	dropped, err = repo.UpdateFailedSignatures(testAddress, []string{"b"}, []types.FailedSignature{
+		{Signature: "a", Attempts: 5, Error: "rate limited"},
+		{Signature: "d", Attempts: 5, Error: "timeout"},
+	}, 12)
+	if err != nil || len(dropped) != 0 {
+		t.Fatalf("dropped %+v, %v", dropped, err)
+	}
+	failed, err := repo.FailedSignatures(testAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []types.FailedSignature{
+		{Signature: "a", Attempts: 10, Error: "rate limited"},
+		{Signature: "d", Attempts: 5, Error: "timeout"},
+	}
+	if !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed = %+v, want %+v", failed, want)
+	}
+
+	// a reaches the limit and is given up; d is fetched, leaving nothing.
+	dropped, err = repo.UpdateFailedSignatures(testAddress, []string{"d"}, []types.FailedSignature{
+		{Signature: "a", Attempts: 5, Error: "rate limited"},
+	}, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dropped) != 1 || dropped[0].Signature != "a" || dropped[0].Attempts != 15 {
+		t.Errorf("dropped %+v, want a after 15 attempts", dropped)
+	}
+	if failed, err := repo.FailedSignatures(testAddress); err != nil || len(failed) != 0 {
+		t.Errorf("failed = %+v, %v, want none", failed, err)
+	}
+}
+
+func TestSyncCursor(t *testing.T) {
+	repo := openTestBolt(t)
+	if _, err := repo.SyncCursor(testAddress); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("SyncCursor = %v, want ErrNotFound", err)
+	}
+	cursor := SyncCursor{Before: "older", Until: "newer"}
+	if err := repo.SaveSyncCursor(testAddress, cursor); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := repo.SyncCursor(testAddress); err != nil || got != cursor {
+		t.Errorf("SyncCursor = %+v, %v, want %+v", got, err, cursor)
+	}
+	if err := repo.SaveSyncCursor(testAddress, SyncCursor{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.SyncCursor(testAddress); !errors.Is(err, ErrNotFound) {
+		t.Errorf("SyncCursor after clearing = %v, want ErrNotFound", err)
+	}
+}
+
+func TestSavePricesKeepsBoundedHistory(t *testing.T) {
+	repo := openTestBolt(t)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	save := func(at time.Time, price float64) {
+		t.Helper()
+		if err := repo.SavePrices(at, map[string]types.PriceQuote{"mint": {Price: price}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	save(start, 1)
+	// Too close to the previous point, before or after it.
+	save(start.Add(time.Minute), 2)
+	save(start.Add(-time.Minute), 3)
+	save(start.Add(priceResolution), 4)
+	// Only the first point is past the retention by now.
+	save(start.Add(priceRetention+priceResolution/2), 5)
+
+	points, err := repo.Prices("mint", time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prices []float64
+	for _, point := range points {
+		prices = append(prices, point.Quote.Price)
+	}
+	if !reflect.DeepEqual(prices, []float64{4, 5}) {
+		t.Errorf("prices = %v, want [4 5]", prices)
+	}
+}
@@ -0,0 +1,80 @@
+// Package storage persists wallet scans so repeat scans only fetch what is
+// new and keep history that RPC nodes have since pruned.
+package storage
+
+import (
+	"errors"
+	"sol_test/types"
+	"time"
+)
+
+// ErrNotFound is returned when nothing is stored for a key.
+var ErrNotFound = errors.New("not found")
+
+// PricePoint is a price recorded at a point in time.
+type PricePoint struct {
+	Time  time.Time        `json:"time"`
+	Quote types.PriceQuote `json:"quote"`
+}
+
+// SyncCursor marks the part of a wallet's history an interrupted sync has
+// yet to fetch: the signatures older than Before and newer than Until. An
+// empty Until reaches back to the wallet's first transaction.
+type SyncCursor struct {
+	Before string `json:"before"`
+	Until  string `json:"until,omitempty"`
+}
+
+// Repository stores wallet snapshots, transactions and prices.
+type Repository interface {
+	// SaveWallet stores a snapshot of the wallet keyed by its address and
+	// slot. The raw transactions aren't part of the snapshot; they are
+	// stored with SaveTransactions. Only the latest snapshot keeps its
+	// Activity and Swaps.
+	SaveWallet(wallet types.MyWallet) error
+	// LatestWallet returns the snapshot with the highest slot, or ErrNotFound.
+	LatestWallet(address string) (types.MyWallet, error)
+	// Wallets returns the snapshots taken between from and to, inclusive,
+	// oldest first. Zero times leave the range open.
+	Wallets(address string, from, to time.Time) ([]types.MyWallet, error)
+
+	// SaveTransactions stores transactions of the wallet, keyed by slot and
+	// signature. Storing a transaction again replaces it.
+	SaveTransactions(address string, transactions []types.TransactionResponse) error
+	// Transactions returns every stored transaction of the wallet, newest first.
+	Transactions(address string) ([]types.TransactionResponse, error)
+	// LatestSignature returns the signature of the newest stored transaction,
+	// or ErrNotFound.
+	LatestSignature(address string) (string, error)
+	// UpdateFailedSignatures updates the wallet's signatures whose
+	// transactions couldn't be fetched, which scans retry since the cursor has
+	// passed them. In one transaction, it removes the fetched signatures and
+	// adds the failed ones, adding up the attempts of those that failed
+	// before. Permanent failures and signatures that reached maxAttempts are
+	// dropped and returned.
+	UpdateFailedSignatures(address string, fetched []string, failed []types.FailedSignature, maxAttempts int) ([]types.FailedSignature, error)
+	// FailedSignatures returns the wallet's outstanding failed signatures.
+	FailedSignatures(address string) ([]types.FailedSignature, error)
+	// SaveSyncCursor records where an interrupted sync of the wallet stopped.
+	// The zero cursor clears it.
+	SaveSyncCursor(address string, cursor SyncCursor) error
+	// SyncCursor returns the wallet's sync cursor, or ErrNotFound.
+	SyncCursor(address string) (SyncCursor, error)
+
+	// SavePrices records quotes taken at time at. Implementations may skip
+	// quotes taken shortly after the last recorded one and forget old ones.
+	SavePrices(at time.Time, quotes map[string]types.PriceQuote) error
+	// Prices returns the recorded prices of mint between from and to,
+	// inclusive, oldest first. Zero times leave the range open.
+	Prices(mint string, from, to time.Time) ([]PricePoint, error)
+
+	// SaveTrackedWallet adds the wallet to the tracked wallets or replaces it.
+	SaveTrackedWallet(wallet types.TrackedWallet) error
+	// DeleteTrackedWallet stops tracking the wallet, or returns ErrNotFound.
+	// Its snapshots and transactions are kept.
+	DeleteTrackedWallet(address string) error
+	// TrackedWallets returns every tracked wallet ordered by address.
+	TrackedWallets() ([]types.TrackedWallet, error)
+
+	Close() error
+}
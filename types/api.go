@@ -9,19 +9,316 @@ type MyWallet struct {
 	Value        float64               `json:"walletValue"`
 	Tokens       []MyToken             `json:"tokens"`
 	Transactions []TransactionResponse `json:"transactions"`
-	LastUpdated  time.Time             `json:"last_updated"`
+	// Activity holds the transactions decoded into transfer events.
+	Activity []DecodedTransaction `json:"activity"`
+	// Swaps lists the trades detected in Transactions.
+	Swaps []Swap `json:"swaps"`
+	// FailedTransactions lists the signatures whose transactions couldn't be fetched.
+	FailedTransactions []FailedSignature `json:"failedTransactions,omitempty"`
+	LastUpdated        time.Time         `json:"last_updated"`
+	// Currency is the currency of every value and price except the USD-suffixed ones.
+	Currency string `json:"currency"`
+	// NFTs holds the wallet's NFTs, compressed or not, grouped by collection.
+	NFTs []NFTCollection `json:"nfts"`
+	// StakedSol is the SOL held in StakeAccounts, counted in Value.
+	StakedSol     float64        `json:"stakedSol"`
+	StakeAccounts []StakeAccount `json:"stakeAccounts,omitempty"`
+	// Slot is the slot the balances were read at.
+	Slot int64 `json:"slot"`
+	// Staleness is the age in seconds of a stored snapshot served instead of
+	// a live scan, zero for live scans.
+	Staleness float64 `json:"staleness,omitempty"`
+	// UnpricedTokens lists the mints of Tokens that no price provider knows.
+	UnpricedTokens []string `json:"unpricedTokens,omitempty"`
 }
 
 type MyToken struct {
-	Name           string    `json:"name"`
-	Address        string    `json:"address"`
-	Pool           string    `json:"pool"`
-	Description    string    `json:"description"`
-	Image          string    `json:"image"`
-	Amount         float64   `json:"amount"`
-	Price          float64   `json:"price"`
-	History_prices []float64 `json:"history_prices"`
-	PnL            float64   `json:"pnl"`
-	Invested       float64   `json:"invested"`
-	Value          float64   `json:"value"`
+	Name           string   `json:"name"`
+	Address        string   `json:"address"`
+	Pool           string   `json:"pool"`
+	Description    string   `json:"description"`
+	Image          string   `json:"image"`
+	Amount         float64  `json:"amount"`
+	Price          float64  `json:"price"`
+	History_prices []Candle `json:"history_prices"`
+	PnL            float64  `json:"pnl"`
+	Invested       float64  `json:"invested"`
+	Value          float64  `json:"value"`
+	// Cost basis details behind PnL, computed with the requested lot matching method.
+	AverageEntryPrice float64 `json:"averageEntryPrice"`
+	RealizedPnL       float64 `json:"realizedPnl"`
+	UnrealizedPnL     float64 `json:"unrealizedPnl"`
+	ROI               float64 `json:"roi"`
+	// PriceMissing is set when no provider priced the token; Price and Value
+	// are then zero and UnrealizedPnL isn't computed.
+	PriceMissing bool `json:"priceMissing,omitempty"`
+	// PriceSource names the provider that produced Price.
+	PriceSource string `json:"priceSource"`
+	// PriceDisputed is set when a second provider disagreed with Price.
+	PriceDisputed bool `json:"priceDisputed,omitempty"`
+	// Pools ranks the token's markets, most canonical first; Pool is the first one.
+	Pools []MarketPool `json:"pools,omitempty"`
+	// NoMarket is set when the token isn't traded in any known pool.
+	NoMarket bool `json:"noMarket,omitempty"`
+	// Program is the token program the mint lives under, SPL Token or Token-2022.
+	Program string `json:"program"`
+	// Extensions holds the Token-2022 mint extensions that affect Amount and Value.
+	Extensions *TokenExtensions `json:"extensions,omitempty"`
+	// LST is set for liquid staking tokens, whose Price is their fair value:
+	// the SOL each token redeems for in its stake pool, SolEquivalent in
+	// total. DexPrice is the market price and Depeg its relative deviation
+	// from the fair value.
+	LST           bool    `json:"lst,omitempty"`
+	SolEquivalent float64 `json:"solEquivalent,omitempty"`
+	DexPrice      float64 `json:"dexPrice,omitempty"`
+	Depeg         float64 `json:"depeg,omitempty"`
+}
+
+// DecodedTransaction is a transaction reduced to the transfers it made.
+type DecodedTransaction struct {
+	Signature string          `json:"signature"`
+	Slot      int             `json:"slot"`
+	BlockTime int64           `json:"blockTime"`
+	FeePayer  string          `json:"feePayer"`
+	Fee       float64         `json:"fee"` // In SOL, paid by FeePayer.
+	FeeUSD    float64         `json:"feeUsd"`
+	Failed    bool            `json:"failed"`
+	Transfers []TransferEvent `json:"transfers"`
+}
+
+// Transfer event types.
+const (
+	TransferTypeTransfer     = "transfer"
+	TransferTypeMint         = "mint"
+	TransferTypeBurn         = "burn"
+	TransferTypeCloseAccount = "closeAccount"
+)
+
+// Transfer directions relative to the scanned wallet.
+const (
+	DirectionIn   = "in"
+	DirectionOut  = "out"
+	DirectionSelf = "self"
+	DirectionNone = "none"
+)
+
+// TransferEvent is a single movement of SOL or an SPL token. From and To are
+// wallet addresses (token account owners); the token accounts themselves are
+// kept in FromAccount and ToAccount.
+type TransferEvent struct {
+	Type        string  `json:"type"`
+	Program     string  `json:"program"`
+	From        string  `json:"from"`
+	To          string  `json:"to"`
+	FromAccount string  `json:"fromAccount,omitempty"`
+	ToAccount   string  `json:"toAccount,omitempty"`
+	Mint        string  `json:"mint"` // The wrapped SOL mint for native SOL.
+	RawAmount   uint64  `json:"rawAmount"`
+	Decimals    int     `json:"decimals"`
+	Amount      float64 `json:"amount"`
+	Direction   string  `json:"direction"`
+	// ValueUSD is the value at the transaction's block time, 0 when unknown.
+	ValueUSD float64 `json:"valueUsd"`
+}
+
+// Swap is a trade made by the scanned wallet. In is what the wallet gave up
+// and Out is what it received; SOL is reported under the wrapped SOL mint.
+type Swap struct {
+	Signature string  `json:"signature"`
+	Slot      int     `json:"slot"`
+	BlockTime int64   `json:"blockTime"`
+	InMint    string  `json:"inMint"`
+	InAmount  float64 `json:"inAmount"`
+	OutMint   string  `json:"outMint"`
+	OutAmount float64 `json:"outAmount"`
+	Venue     string  `json:"venue"`
+	Fee       float64 `json:"fee"` // Network fee in SOL paid by the wallet.
+	// ValueUSD and FeeUSD are valued at the swap's block time, 0 when unknown.
+	ValueUSD float64 `json:"valueUsd"`
+	FeeUSD   float64 `json:"feeUsd"`
+}
+
+// PriceQuote is a USD price together with the provider that produced it and
+// the result of cross-checking it against a second provider.
+type PriceQuote struct {
+	Price     float64 `json:"price"`
+	Source    string  `json:"source"`
+	CheckedBy string  `json:"checkedBy,omitempty"`
+	Deviation float64 `json:"deviation,omitempty"`
+	Disputed  bool    `json:"disputed,omitempty"`
+}
+
+// MarketPool is a pool trading a token, as ranked by pool selection.
+type MarketPool struct {
+	Address         string  `json:"address"`
+	Name            string  `json:"name"`
+	Dex             string  `json:"dex"`
+	BaseMint        string  `json:"baseMint"`
+	QuoteMint       string  `json:"quoteMint"`
+	ReserveUSD      float64 `json:"reserveUsd"`
+	VolumeUSD24h    float64 `json:"volumeUsd24h"`
+	Transactions24h int     `json:"transactions24h"`
+	Score           float64 `json:"score"`
+}
+
+// PoolSeries selects one token's OHLCV series in a pool. GeckoTerminal
+// reports either side of a pool; Token is "base" or "quote".
+type PoolSeries struct {
+	Pool  string `json:"pool"`
+	Token string `json:"token"`
+}
+
+// TokenExtensions are the Token-2022 mint extensions that change what a
+// holding is worth. Raw amounts are in the token's smallest unit.
+type TokenExtensions struct {
+	// TransferFeeBasisPoints is charged on every transfer, up to MaximumTransferFee.
+	TransferFeeBasisPoints int    `json:"transferFeeBasisPoints,omitempty"`
+	MaximumTransferFee     uint64 `json:"maximumTransferFee,omitempty"`
+	// InterestRate is the current yearly rate in basis points of an
+	// interest-bearing mint; the timestamps and the average rate before the
+	// last update are needed to compute the accrued amount.
+	InterestRate          int   `json:"interestRate,omitempty"`
+	PreUpdateAverageRate  int   `json:"preUpdateAverageRate,omitempty"`
+	InterestInitializedAt int64 `json:"interestInitializedAt,omitempty"`
+	InterestLastUpdatedAt int64 `json:"interestLastUpdatedAt,omitempty"`
+	// ScaledUIMultiplier is the multiplier in effect for scaled UI amount mints.
+	ScaledUIMultiplier float64 `json:"scaledUiMultiplier,omitempty"`
+	// MetadataAddress is the account the metadata pointer extension points to.
+	MetadataAddress string `json:"metadataAddress,omitempty"`
+	// Metadata is set when the mint stores its metadata itself.
+	Metadata *OnChainMetadata `json:"metadata,omitempty"`
+	// NonTransferable tokens are bound to their owner and can't be sold.
+	NonTransferable bool `json:"nonTransferable,omitempty"`
+}
+
+// OnChainMetadata is token metadata read from an account rather than an indexer.
+type OnChainMetadata struct {
+	Name            string `json:"name"`
+	Symbol          string `json:"symbol"`
+	URI             string `json:"uri"`
+	UpdateAuthority string `json:"updateAuthority,omitempty"`
+}
+
+// NFTCollection is the wallet's NFTs from one collection. Collection is
+// empty for NFTs that don't belong to a verified collection.
+type NFTCollection struct {
+	Collection string  `json:"collection"`
+	Name       string  `json:"name,omitempty"`
+	NFTs       []MyNFT `json:"nfts"`
+	// FloorPrice and Value are only set when floor prices were requested.
+	FloorPrice float64 `json:"floorPrice,omitempty"`
+	Value      float64 `json:"value,omitempty"`
+}
+
+// MyNFT is a single NFT held by the wallet.
+type MyNFT struct {
+	Address       string `json:"address"`
+	Name          string `json:"name"`
+	Symbol        string `json:"symbol"`
+	Image         string `json:"image"`
+	TokenStandard string `json:"tokenStandard,omitempty"`
+	Collection    string `json:"collection,omitempty"`
+	Compressed    bool   `json:"compressed"`
+	// Compression locates a compressed NFT in its Merkle tree.
+	Compression *Compression `json:"compression,omitempty"`
+}
+
+// Stake account activation states.
+const (
+	StakeStateActivating   = "activating"
+	StakeStateActive       = "active"
+	StakeStateDeactivating = "deactivating"
+	StakeStateInactive     = "inactive"
+)
+
+// StakeAccount is a native stake account the wallet can stake or withdraw
+// from. Amounts are in SOL.
+type StakeAccount struct {
+	Address    string `json:"address"`
+	Staker     string `json:"staker"`
+	Withdrawer string `json:"withdrawer"`
+	// Validator is the vote account the stake is delegated to, empty when undelegated.
+	Validator         string        `json:"validator,omitempty"`
+	State             string        `json:"state"`
+	Balance           float64       `json:"balance"`
+	ActiveStake       float64       `json:"activeStake"`
+	InactiveStake     float64       `json:"inactiveStake"`
+	ActivationEpoch   uint64        `json:"activationEpoch,omitempty"`
+	DeactivationEpoch uint64        `json:"deactivationEpoch,omitempty"`
+	Rewards           []StakeReward `json:"rewards,omitempty"`
+	Value             float64       `json:"value"`
+}
+
+// StakeReward is the inflation reward credited to a stake account for an epoch.
+type StakeReward struct {
+	Epoch       uint64  `json:"epoch"`
+	Amount      float64 `json:"amount"`
+	PostBalance float64 `json:"postBalance"`
+	Commission  *int    `json:"commission,omitempty"`
+}
+
+// PortfolioHistory is a wallet's value over time, oldest point first.
+type PortfolioHistory struct {
+	Address  string           `json:"address"`
+	Interval string           `json:"interval"`
+	Points   []PortfolioPoint `json:"points"`
+}
+
+// PortfolioPoint is what the wallet held at Timestamp and what it was worth
+// in USD at the time.
+type PortfolioPoint struct {
+	Timestamp  int64          `json:"timestamp"`
+	Value      float64        `json:"value"`
+	SolBalance float64        `json:"solBalance"`
+	SolValue   float64        `json:"solValue"`
+	Tokens     []TokenHolding `json:"tokens"`
+}
+
+// TokenHolding is a token balance within a PortfolioPoint. Price and Value
+// are 0 when no price was known at the time.
+type TokenHolding struct {
+	Mint   string  `json:"mint"`
+	Amount float64 `json:"amount"`
+	Price  float64 `json:"price"`
+	Value  float64 `json:"value"`
+}
+
+// TrackedWallet is a wallet rescanned in the background so that its latest
+// snapshot can be served without waiting for a scan.
+type TrackedWallet struct {
+	Address string `json:"address"`
+	// Interval is the time between scans in seconds, zero for the scheduler's default.
+	Interval int64 `json:"interval,omitempty"`
+	// Priority orders wallets that are due at the same time, highest first.
+	Priority  int       `json:"priority"`
+	AddedAt   time.Time `json:"addedAt"`
+	LastScan  time.Time `json:"lastScan"`
+	NextScan  time.Time `json:"nextScan"`
+	LastError string    `json:"lastError,omitempty"`
+	// Failures counts the scans that failed in a row.
+	Failures int `json:"failures,omitempty"`
+}
+
+// LiveWallet is a tracked wallet's state kept current by WebSocket
+// subscriptions, between the scans that produce full snapshots.
+type LiveWallet struct {
+	Address    string  `json:"address"`
+	SolBalance float64 `json:"solBalance"`
+	// TokenAccounts maps token account addresses to their balance.
+	TokenAccounts map[string]LiveTokenAccount `json:"tokenAccounts"`
+	// Signatures lists the newest confirmed signatures mentioning the wallet, newest first.
+	Signatures []string  `json:"signatures"`
+	Slot       int64     `json:"slot"`
+	UpdatedAt  time.Time `json:"updatedAt"`
+	// LastIngest is when the wallet's new transactions were last stored.
+	LastIngest time.Time `json:"lastIngest"`
+}
+
+// LiveTokenAccount is a token account balance of a LiveWallet.
+type LiveTokenAccount struct {
+	Mint     string  `json:"mint"`
+	Program  string  `json:"program"`
+	Amount   float64 `json:"amount"`
+	Decimals int     `json:"decimals"`
+	Slot     int64   `json:"slot"`
 }
@@ -1,5 +1,10 @@
 package types
 
+import (
+	"encoding/json"
+	"fmt"
+)
+
 type CoinGeckoPriceResponse struct {
 	Data TokenPriceData `json:"data"`
 }
@@ -111,9 +116,43 @@ type OHLCVData struct {
 
 // OHLCVAttributes contains the list of OHLCV records.
 type OHLCVAttributes struct {
-	// Each inner slice represents a record in the order:
-	// [timestamp, open, high, low, close, volume]
-	OHLCVList [][]float64 `json:"ohlcv_list"`
+	OHLCVList []Candle `json:"ohlcv_list"`
+}
+
+// Candle is a single OHLCV record. Timestamp is the unix start of the candle.
+type Candle struct {
+	Timestamp int64   `json:"timestamp"`
+	Open      float64 `json:"open"`
+	High      float64 `json:"high"`
+	Low       float64 `json:"low"`
+	Close     float64 `json:"close"`
+	Volume    float64 `json:"volume"`
+}
+
+// UnmarshalJSON accepts GeckoTerminal's array form,
+// [timestamp, open, high, low, close, volume], as well as the object form
+// Candle is marshalled to.
+func (c *Candle) UnmarshalJSON(data []byte) error {
+	if len(data) > 0 && data[0] == '{' {
+		type candle Candle
+		return json.Unmarshal(data, (*candle)(c))
+	}
+	var record []float64
+	if err := json.Unmarshal(data, &record); err != nil {
+		return err
+	}
+	if len(record) < 6 {
+		return fmt.Errorf("ohlcv record has %d fields, want 6", len(record))
+	}
+	*c = Candle{
+		Timestamp: int64(record[0]),
+		Open:      record[1],
+		High:      record[2],
+		Low:       record[3],
+		Close:     record[4],
+		Volume:    record[5],
+	}
+	return nil
 }
 
 // OHLCVMeta holds metadata for the response.
@@ -12,11 +12,21 @@ type GetSignaturesForAddressResponse struct {
 }
 
 type WalletTransactionHashResponse struct {
-	Err       string `json:"err"`
-	Memo      string `json:"memo"`
+	Err                interface{} `json:"err"` // Transaction error object, null on success.
+	Memo               string      `json:"memo"`
+	Signature          string      `json:"signature"`
+	Slot               int64       `json:"slot"`
+	BlockTime          int64       `json:"blockTime"`
+	ConfirmationStatus string      `json:"confirmationStatus"`
+}
+
+// FailedSignature reports a transaction that couldn't be fetched.
+type FailedSignature struct {
 	Signature string `json:"signature"`
-	Slot      int64  `json:"slot"`
-	BlockTime int64  `json:"blockTime"`
+	Attempts  int    `json:"attempts"`
+	Error     string `json:"error"`
+	// Permanent is set when retrying can't succeed, e.g. the slot was pruned.
+	Permanent bool `json:"permanent,omitempty"`
 }
 
 type Wallet struct {
@@ -51,12 +61,13 @@ type GetAccountInfoContext struct {
 	Slot       int64  `json:"slot"`
 }
 type GetAccountInfoValue struct {
-	Data       string `json:"data"`
-	Executable bool   `json:"executable"`
-	Lamports   int64  `json:"lamports"`
-	Owner      string `json:"owner"`
-	RentEpoch  uint64 `json:"rentEpoch"`
-	Space      int64  `json:"space"`
+	// Data is returned as [data, encoding].
+	Data       []string `json:"data"`
+	Executable bool     `json:"executable"`
+	Lamports   int64    `json:"lamports"`
+	Owner      string   `json:"owner"`
+	RentEpoch  uint64   `json:"rentEpoch"`
+	Space      int64    `json:"space"`
 }
 
 type GetTokenAccountsByOwnerResponse struct {
@@ -125,7 +136,7 @@ type TokenMetaData struct {
 	Content     Content       `json:"content"`
 	Authorities []Authority   `json:"authorities"`
 	Compression Compression   `json:"compression"`
-	Grouping    []interface{} `json:"grouping"`
+	Grouping    []Grouping    `json:"grouping"`
 	Royalty     Royalty       `json:"royalty"`
 	Creators    []interface{} `json:"creators"`
 	Ownership   Ownership     `json:"ownership"`
@@ -133,6 +144,23 @@ type TokenMetaData struct {
 	Burnt       bool          `json:"burnt"`
 }
 
+// Grouping places an asset in a group, such as its collection when GroupKey
+// is "collection". Verified is only reported for collections when unverified
+// ones are requested too.
+type Grouping struct {
+	GroupKey   string `json:"group_key"`
+	GroupValue string `json:"group_value"`
+	Verified   *bool  `json:"verified,omitempty"`
+}
+
+// GetAssetsByOwnerResult is a page of the DAS getAssetsByOwner method.
+type GetAssetsByOwnerResult struct {
+	Total int             `json:"total"`
+	Limit int             `json:"limit"`
+	Page  int             `json:"page"`
+	Items []TokenMetaData `json:"items"`
+}
+
 type Content struct {
 	Schema   string   `json:"$schema"`
 	JSONURI  string   `json:"json_uri"`
@@ -203,6 +231,27 @@ type SolanaError struct {
 	Message string `json:"message"`
 }
 
+// Error implements the error interface so RPC errors can be returned directly.
+func (e *SolanaError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+// RPCRequest is a JSON-RPC 2.0 request envelope.
+type RPCRequest struct {
+	JsonRPC string        `json:"jsonrpc"`
+	ID      int64         `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params,omitempty"`
+}
+
+// RPCResponse is a JSON-RPC 2.0 response envelope with the result left undecoded.
+type RPCResponse struct {
+	JsonRPC string          `json:"jsonrpc"`
+	Result  json.RawMessage `json:"result"`
+	Error   *SolanaError    `json:"error"`
+	ID      int64           `json:"id"`
+}
+
 // Version is a custom type that can handle both string and numeric JSON values
 type Version string
 
@@ -330,3 +379,29 @@ type MessageInstruction struct {
 	// stackHeight can be null so we use a pointer.
 	StackHeight *int `json:"stackHeight"`
 }
+
+// AccountNotification is the result of an accountSubscribe notification.
+// Value is null once the account is closed and otherwise holds the account
+// in the subscription's encoding.
+type AccountNotification struct {
+	Context GetAccountInfoContext `json:"context"`
+	Value   json.RawMessage       `json:"value"`
+}
+
+// LogsNotification is the result of a logsSubscribe notification.
+type LogsNotification struct {
+	Context GetAccountInfoContext `json:"context"`
+	Value   struct {
+		Signature string      `json:"signature"`
+		Err       interface{} `json:"err"` // Transaction error object, null on success.
+		Logs      []string    `json:"logs"`
+	} `json:"value"`
+}
+
+// SignatureNotification is the result of a signatureSubscribe notification.
+type SignatureNotification struct {
+	Context GetAccountInfoContext `json:"context"`
+	Value   struct {
+		Err interface{} `json:"err"` // Transaction error object, null on success.
+	} `json:"value"`
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sol_test/live"
+	"sol_test/requests"
+	"sol_test/scheduler"
+	"sol_test/solana"
+	"sol_test/storage"
+	"sol_test/types"
+	"time"
+
+	"github.com/charmbracelet/log"
+	"github.com/go-chi/chi/v5"
+)
+
+// tracker rescans the tracked wallets in the background. It is nil when
+// storage is disabled, since snapshots have nowhere to go.
+var tracker *scheduler.Scheduler
+
+// scanTrackedWallet is the scheduler's scan: a default, full scan whose
+// snapshot getWallet stores.
+func scanTrackedWallet(ctx context.Context, address string) error {
+	opts, err := parseWalletOptions(url.Values{})
+	if err != nil {
+		return err
+	}
+	_, err = getWallet(ctx, address, opts)
+	return err
+}
+
+// watcher keeps the tracked wallets' balances live and ingests their new
+// transactions as they happen. It is nil when live updates are disabled.
+var watcher *live.Watcher
+
+// ingestTransactions stores the wallet's new transactions, fetching only the
+// signatures after the stored ones. Wallets without a stored scan are left to
+// the scheduler, whose next scan is full.
+func ingestTransactions(ctx context.Context, address string) error {
+	_, err := store.LatestWallet(address)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	logger := log.NewWithOptions(os.Stderr, log.Options{
+		ReportTimestamp: true,
+		Prefix:          "Ingest ",
+	})
+	_, _, err = syncTransactions(ctx, logger, address, requests.TransactionOptions{})
+	return err
+}
+
+// getLiveWalletHandler serves GET /{address}/live, the balances of a tracked
+// wallet as of its latest account notification.
+func getLiveWalletHandler(w http.ResponseWriter, r *http.Request) {
+	wallet, ok := watcher.Wallet(chi.URLParam(r, "address"))
+	if !ok {
+		http.Error(w, "wallet is not tracked", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, wallet)
+}
+
+// trackWalletRequest is the body of POST /wallets.
+type trackWalletRequest struct {
+	Address string `json:"address"`
+	// Interval is the time between scans in seconds, zero for the default.
+	Interval int64 `json:"interval"`
+	Priority int   `json:"priority"`
+}
+
+// listTrackedWalletsHandler serves GET /wallets, the tracked wallets and
+// their scan status.
+func listTrackedWalletsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, tracker.Wallets())
+}
+
+// trackWalletHandler serves POST /wallets. Posting a tracked wallet again
+// updates its interval and priority and rescans it.
+func trackWalletHandler(w http.ResponseWriter, r *http.Request) {
+	var request trackWalletRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid body: %v", err), http.StatusBadRequest)
+		return
+	}
+	if key, err := solana.DecodeBase58(request.Address); err != nil || len(key) != 32 {
+		http.Error(w, fmt.Sprintf("invalid address %q", request.Address), http.StatusBadRequest)
+		return
+	}
+	_, existed := tracker.Tracked(request.Address)
+	wallet, err := tracker.Add(types.TrackedWallet{
+		Address:  request.Address,
+		Interval: request.Interval,
+		Priority: request.Priority,
+	})
+	if errors.Is(err, scheduler.ErrInvalidWallet) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	status := http.StatusCreated
+	if existed {
+		status = http.StatusOK
+	}
+	if watcher != nil {
+		if err := watcher.Watch(context.Background(), wallet.Address); err != nil {
+			log.Warn("Failed to watch wallet", "address", wallet.Address, "error", err)
+		}
+	}
+	writeJSON(w, status, wallet)
+}
+
+// untrackWalletHandler serves DELETE /wallets/{address}. The wallet's stored
+// snapshots and transactions are kept.
+func untrackWalletHandler(w http.ResponseWriter, r *http.Request) {
+	err := tracker.Remove(chi.URLParam(r, "address"))
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "wallet is not tracked", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if watcher != nil {
+		watcher.Unwatch(chi.URLParam(r, "address"))
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// trackedSnapshot returns the latest stored snapshot of a tracked wallet,
+// with its stored transactions and staleness, if there is one.
+func trackedSnapshot(address string) (types.MyWallet, bool, error) {
+	if tracker == nil {
+		return types.MyWallet{}, false, nil
+	}
+	if _, ok := tracker.Tracked(address); !ok {
+		return types.MyWallet{}, false, nil
+	}
+	wallet, err := store.LatestWallet(address)
+	if errors.Is(err, storage.ErrNotFound) {
+		return wallet, false, nil
+	}
+	if err != nil {
+		return wallet, false, err
+	}
+	if wallet.Transactions, err = store.Transactions(address); err != nil {
+		return wallet, false, err
+	}
+	wallet.Staleness = time.Since(wallet.LastUpdated).Seconds()
+	return wallet, true, nil
+}
+
+// writeJSON writes value as the JSON response body.
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	b, err := json.Marshal(value)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}